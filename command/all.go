@@ -0,0 +1,257 @@
+// Package command for all
+// All detects which HashiCorp runtime tools are running, gathers their data
+// along with system data, then archives and optionally uploads the result
+package command
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/mitchellh/cli"
+)
+
+// detectModules are the modules which run only when their process is found
+var detectModules = []string{Consul, Nomad, Vault}
+
+// AllCommand describes all related fields
+type AllCommand struct {
+	Timeout         time.Duration
+	Parallelism     int
+	Profile         string
+	NoRedact        bool
+	Strict          bool
+	ConfigPath      string
+	ArchivePath     string
+	Format          string
+	EncryptTo       stringsFlag
+	Recipients      stringsFlag
+	RecipientsFiles stringsFlag
+	SignKey         string
+	KeepData        bool
+	Upload          bool
+	OutputDir       string
+	HostName        string
+	OS              string
+	RunContext      *RunContext
+	UI              cli.Ui
+}
+
+// Help output
+func (c *AllCommand) Help() string {
+	helpText := `
+Usage: rover all [options]
+	Gather system data and data for each of Consul, Nomad and Vault which
+	is running on this host, then archive the results into a zip, tar.gz or
+	tar.zst file and optionally upload it to S3
+
+General Options:
+  -config=<path>	Task configuration file or directory [default: $ROVER_CONFIG_DIR]
+  -timeout=<duration>	Default deadline for each task [default: 2m]
+  -parallelism=<n>	Maximum number of tasks to run at once [default: 4]
+  -profile=<name>	Collection profile: minimal, standard, deep or custom [default: standard]
+  -no-redact		Do not redact secrets from output; internal use only [default: false]
+  -strict		Exit non-zero when any task fails, times out or is cancelled [default: false]
+  -format=<format>	Archive format: zip, tar.gz or tar.zst [default: zip]
+  -encrypt-to=<keyfile>	Encrypt the archive to an OpenPGP public key as rover archive does; repeatable
+  -recipient=<age1...>	Encrypt the archive to an age recipient as rover archive does; repeatable
+  -recipients-file=<file>	Encrypt the archive to the age recipients in a file; repeatable
+  -sign-key=<keyfile>	Sign the archive checksum with an ed25519 key as rover archive does
+  -keep-data		Whether to keep the archive source directory [default: false]
+  -output-dir=<dir>	Directory holding the <hostname> data directory [default: $ROVER_OUTPUT_DIR or a new run]
+  -log-level=<level>	Log level: trace, debug, info, warn or error [default: $ROVER_LOG_LEVEL or info]
+  -log-json		Write rover.log as JSON [default: true when $ROVER_LOG_FORMAT is json]
+  -log-mirror		Print logged warnings to the terminal [default: true]
+  -path			Path where archive file is written [default: the output directory]
+  -upload		Upload the archive to S3 as rover upload does [default: false]
+`
+
+	return strings.TrimSpace(helpText)
+}
+
+// Run command
+func (c *AllCommand) Run(args []string) int {
+	run := c.RunContext
+	if run == nil {
+		run = NewRunContext(c.UI)
+	}
+	c.OS = run.OS
+	cmdFlags := flag.NewFlagSet("all", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.StringVar(&c.ConfigPath, "config", "", configPathDescr)
+	cmdFlags.DurationVar(&c.Timeout, "timeout", DefaultTaskTimeout, timeoutDescr)
+	cmdFlags.IntVar(&c.Parallelism, "parallelism", DefaultParallelism, parallelismDescr)
+	cmdFlags.StringVar(&c.Profile, "profile", DefaultProfile, profileDescr)
+	cmdFlags.BoolVar(&c.NoRedact, "no-redact", false, noRedactDescr)
+	cmdFlags.BoolVar(&c.Strict, "strict", false, strictDescr)
+	cmdFlags.StringVar(&c.ArchivePath, "path", "", archivePathDescr)
+	cmdFlags.BoolVar(&c.KeepData, "keep-data", false, "Remove the zipfile source directory?")
+	cmdFlags.BoolVar(&c.Upload, "upload", false, "Upload the archive to S3")
+	cmdFlags.StringVar(&c.Format, "format", FormatZip, formatDescr)
+	cmdFlags.Var(&c.EncryptTo, "encrypt-to", encryptToDescr)
+	cmdFlags.Var(&c.Recipients, "recipient", recipientDescr)
+	cmdFlags.Var(&c.RecipientsFiles, "recipients-file", recipientsFileDescr)
+	cmdFlags.StringVar(&c.SignKey, "sign-key", "", signKeyDescr)
+	cmdFlags.StringVar(&c.OutputDir, "output-dir", "", outputDirDescr)
+	run.Flags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+	if err := run.Open(c.OutputDir, RunNew); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	defer run.Close()
+	c.HostName = run.HostName
+	logger := run.Logger
+	logger.Info("all", "hello from the All module at", c.HostName)
+	logger.Info("all", "our detected OS", c.OS)
+
+	// Check the archive options now rather than after all of the data is
+	// gathered
+	if !containsString(archiveFormats, c.Format) {
+		out := fmt.Sprintf("Unknown archive format %q; use one of %s", c.Format, strings.Join(archiveFormats, ", "))
+		c.UI.Error(out)
+		return 1
+	}
+	if _, err := newArchiveEncryption(c.EncryptTo, c.Recipients, c.RecipientsFiles); err != nil {
+		logger.Error("all", "cannot read encryption keys with error", err.Error())
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if c.SignKey != "" {
+		if _, err := readSigningKey(c.SignKey); err != nil {
+			logger.Error("all", "cannot read signing key with error", err.Error())
+			c.UI.Error(err.Error())
+			return 1
+		}
+	}
+	if err := LoadConfig(ConfigPath(c.ConfigPath), Collectors); err != nil {
+		logger.Error("all", "cannot load configuration with error", err.Error())
+		c.UI.Error(err.Error())
+		return 1
+	}
+	profile, err := Collectors.Profile(c.Profile)
+	if err != nil {
+		logger.Error("all", "cannot find profile with error", err.Error())
+		c.UI.Error(err.Error())
+		return 1
+	}
+	redactor := commandRedactor(logger, c.UI, c.NoRedact)
+
+	// System data is always gathered, the rest only when running
+	modules := []string{"system"}
+	pids := map[string]string{}
+	for _, m := range detectModules {
+		pid, err := run.CheckProc(m)
+		if err != nil || pid == "" {
+			logger.Info("all", "no process detected for module", m)
+			continue
+		}
+		logger.Info("all", "process detected for module", m, "pid", pid)
+		modules = append(modules, m)
+		pids[m] = pid
+	}
+
+	// Shout out to Ye Olde School BSD spinner!
+	roverSpinnerSet := []string{"/", "|", "\\", "-", "|", "\\", "-"}
+	s := spinner.New(roverSpinnerSet, 174*time.Millisecond)
+	s.Writer = os.Stderr
+	err = s.Color("fgHiCyan")
+	if err != nil {
+		logger.Warn("all", "weird-error", err.Error())
+	}
+	s.FinalMSG = fmt.Sprintf("Gathered %s data\n", strings.Join(modules, ", "))
+	s.Start()
+
+	ctx, cancel := InterruptContext()
+	defer cancel()
+	summary := &Summary{}
+	for i, m := range modules {
+		s.Lock()
+		s.Suffix = fmt.Sprintf(" Gathering %s data (%d/%d) ...", m, i+1, len(modules))
+		s.Unlock()
+		tasks, err := Collectors.Lookup(m)
+		if err != nil {
+			logger.Error("all", "cannot find task set with error", err.Error())
+			summary.Fail(m)
+			continue
+		}
+		outPath := filepath.Join(run.HostDir(), m)
+		if err := os.MkdirAll(outPath, os.ModePerm); err != nil {
+			logger.Error("all", "cannot create directory", outPath, "error", err.Error())
+			summary.Fail(m)
+			continue
+		}
+		env := TaskEnv{
+			Run:         run,
+			OS:          c.OS,
+			Vars:        moduleVars(pids[m]),
+			Timeout:     c.Timeout,
+			Parallelism: c.Parallelism,
+			Profile:     profile,
+			Redactor:    redactor,
+		}
+		if api, err := moduleAPI(m); err != nil {
+			logger.Warn("all", "cannot configure API client, skipping API tasks", err.Error())
+		} else {
+			env.API = api
+		}
+		if m != "system" {
+			env.Version = moduleVersion(ctx, run, m, env.API)
+		}
+		results := ExecuteTasks(ctx, logger, tasks, env)
+		if err := UpdateManifest(run.HostDir(), c.OS, profile.Name, results); err != nil {
+			logger.Warn("all", "cannot update manifest with error", err.Error())
+		}
+		summary.Add(m, results)
+	}
+	s.Stop()
+	// Failures are reported now but only decide the exit status once the
+	// data gathered despite them is archived
+	status := summary.Report(c.UI, c.Strict)
+
+	if ctx.Err() != nil {
+		c.UI.Warn("Interrupted; the gathered data was not archived.")
+		return 1
+	}
+	a := &ArchiveCommand{UI: c.UI, RunContext: run}
+	if c.ArchivePath == "" {
+		c.ArchivePath = run.ArchiveDir()
+	}
+	archiveArgs := []string{"-output-dir", run.OutputDir, "-path", c.ArchivePath, "-format", c.Format}
+	if c.KeepData {
+		archiveArgs = append(archiveArgs, "-keep-data")
+	}
+	for _, k := range c.EncryptTo {
+		archiveArgs = append(archiveArgs, "-encrypt-to", k)
+	}
+	for _, r := range c.Recipients {
+		archiveArgs = append(archiveArgs, "-recipient", r)
+	}
+	for _, f := range c.RecipientsFiles {
+		archiveArgs = append(archiveArgs, "-recipients-file", f)
+	}
+	if c.SignKey != "" {
+		archiveArgs = append(archiveArgs, "-sign-key", c.SignKey)
+	}
+	if code := a.Run(archiveArgs); code != 0 {
+		return code
+	}
+	if c.Upload {
+		u := &UploadCommand{UI: c.UI, RunContext: run}
+		if code := u.Run([]string{"-file", a.OutFile}); code != 0 {
+			return code
+		}
+	}
+	return status
+}
+
+// Synopsis output
+func (c *AllCommand) Synopsis() string {
+	return "Gather data from every detected module, then archive it"
+}
@@ -0,0 +1,401 @@
+// Package command for analyze
+// Analyze reads the data captured for a module and reports findings, such
+// as a cluster without a leader, so that a bundle can be triaged without an
+// expert reading every file
+package command
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/cli"
+)
+
+// FindingsFile is the name of the findings report at the root of the host
+// directory
+const FindingsFile = "findings.json"
+
+// Finding severities, from most to least severe
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// severityRank orders severities for sorting, most severe first
+var severityRank = map[string]int{SeverityCritical: 0, SeverityWarning: 1, SeverityInfo: 2}
+
+// Finding is a single problem or observation reported by an analyzer
+type Finding struct {
+	Module   string `json:"module"`
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	// Sources are the files the finding is based on, relative to the host
+	// directory
+	Sources []string `json:"sources,omitempty"`
+}
+
+// String formats the finding for humans
+func (f Finding) String() string {
+	return fmt.Sprintf("[%s] %s/%s: %s", f.Severity, f.Module, f.Check, f.Message)
+}
+
+// Bundle gives analyzers read access to captured data by the path of each
+// file relative to the host directory, e.g. consul/consul_info.txt
+type Bundle interface {
+	ReadFile(name string) ([]byte, error)
+}
+
+// DirBundle is a Bundle for a host directory on disk
+type DirBundle string
+
+// ReadFile reads a file from the host directory
+func (d DirBundle) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(string(d), filepath.FromSlash(name)))
+}
+
+// productVersion matches the version in the output of the version command
+// of a HashiCorp tool, e.g. Vault v1.4.3 ('a1b2c3')
+var productVersion = regexp.MustCompile(`\bv(\d+\.\d+\.\d+\S*)`)
+
+// raftServer is a raft peer as reported by Consul, Nomad or Vault
+type raftServer struct {
+	Node    string
+	Address string
+	Leader  bool
+	Voter   bool
+}
+
+// readBundleJSON decodes a JSON output from b into v; outputs replaced by a
+// rover note, e.g. for a refused API request, fail to decode
+func readBundleJSON(b Bundle, name string, v interface{}) error {
+	data, err := b.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// raftFindings checks the raft peers of module read from source for a
+// missing leader and an even number of voters
+func raftFindings(module string, servers []raftServer, source string) []Finding {
+	findings := []Finding{}
+	leaders, voters := 0, 0
+	for _, s := range servers {
+		if s.Leader {
+			leaders++
+		}
+		if s.Voter {
+			voters++
+		}
+	}
+	if leaders == 0 {
+		findings = append(findings, Finding{Module: module, Check: "no_leader", Severity: SeverityCritical,
+			Message: fmt.Sprintf("None of the %d raft peers is the leader", len(servers)), Sources: []string{source}})
+	}
+	if voters > 0 && voters%2 == 0 {
+		findings = append(findings, Finding{Module: module, Check: "even_voters", Severity: SeverityWarning,
+			Message: fmt.Sprintf("There are %d voting servers; an even number adds no fault tolerance over %d", voters, voters-1), Sources: []string{source}})
+	}
+	return findings
+}
+
+// thresholdSeverity returns the severity for d against its thresholds, or
+// an empty string when it is below both
+func thresholdSeverity(d, warning, critical time.Duration) string {
+	switch {
+	case d > critical:
+		return SeverityCritical
+	case d > warning:
+		return SeverityWarning
+	}
+	return ""
+}
+
+// listOrNone joins names, or returns "none" for an empty list
+func listOrNone(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// parseTable parses whitespace aligned CLI table output whose header line
+// starts with first into one map per row keyed by column name; values must
+// not contain spaces
+func parseTable(text string, first string) []map[string]string {
+	rows := []map[string]string{}
+	var header []string
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if header == nil {
+			if fields[0] == first {
+				header = fields
+			}
+			continue
+		}
+		if len(fields) < len(header) {
+			continue
+		}
+		row := map[string]string{}
+		for i, h := range header {
+			row[h] = fields[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// Analyzer inspects the captured data for one module
+type Analyzer func(b Bundle) []Finding
+
+var (
+	analyzersMu sync.RWMutex
+	analyzers   = map[string]Analyzer{}
+)
+
+// RegisterAnalyzer adds the analyzer for module, replacing any earlier one
+func RegisterAnalyzer(module string, a Analyzer) {
+	analyzersMu.Lock()
+	defer analyzersMu.Unlock()
+	analyzers[module] = a
+}
+
+// lookupAnalyzer returns the analyzer registered for module
+func lookupAnalyzer(module string) (Analyzer, error) {
+	analyzersMu.RLock()
+	defer analyzersMu.RUnlock()
+	a, ok := analyzers[module]
+	if !ok {
+		return nil, fmt.Errorf("no analyzer registered for %q; available analyzers are %v", module, analyzerNames())
+	}
+	return a, nil
+}
+
+// analyzerNames returns the sorted names of the registered analyzers; the
+// caller holds analyzersMu
+func analyzerNames() []string {
+	names := make([]string, 0, len(analyzers))
+	for n := range analyzers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortFindings orders findings by severity, then module and check
+func sortFindings(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if severityRank[a.Severity] != severityRank[b.Severity] {
+			return severityRank[a.Severity] < severityRank[b.Severity]
+		}
+		if a.Module != b.Module {
+			return a.Module < b.Module
+		}
+		return a.Check < b.Check
+	})
+}
+
+// FindingsReport is the content of findings.json
+type FindingsReport struct {
+	Updated time.Time `json:"updated"`
+	// Analyzed maps each module to the time it was last analyzed, so a
+	// module without findings can be told from one never analyzed
+	Analyzed map[string]time.Time `json:"analyzed"`
+	Findings []Finding            `json:"findings"`
+}
+
+// ReadFindings loads findings.json from the host directory
+func ReadFindings(dir string) (*FindingsReport, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, FindingsFile))
+	if err != nil {
+		return nil, err
+	}
+	r := &FindingsReport{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("cannot parse %s with error %v", FindingsFile, err)
+	}
+	return r, nil
+}
+
+// UpdateFindings replaces the findings for module in the report for the
+// host directory dir, as UpdateManifest does for task results
+func UpdateFindings(dir string, module string, findings []Finding) error {
+	r, err := ReadFindings(dir)
+	if os.IsNotExist(err) {
+		r = &FindingsReport{}
+	} else if err != nil {
+		return err
+	}
+	kept := []Finding{}
+	for _, f := range r.Findings {
+		if f.Module != module {
+			kept = append(kept, f)
+		}
+	}
+	if r.Analyzed == nil {
+		r.Analyzed = map[string]time.Time{}
+	}
+	r.Updated = time.Now().UTC()
+	r.Analyzed[module] = r.Updated
+	r.Findings = append(kept, findings...)
+	sortFindings(r.Findings)
+
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode %s with error %v", FindingsFile, err)
+	}
+	p := filepath.Join(dir, FindingsFile)
+	if err := ioutil.WriteFile(p+".tmp", append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("cannot write %s with error %v", FindingsFile, err)
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+// AnalyzeCommand describes analyze related fields
+type AnalyzeCommand struct {
+	ConfigPath string
+	DataPath   string
+	OutputDir  string
+	HostName   string
+	RunContext *RunContext
+	UI         cli.Ui
+}
+
+// Help output
+func (c *AnalyzeCommand) Help() string {
+	helpText := `
+Usage: rover analyze [options] <module> [<module> ...]
+	Analyze the data gathered for each module, print findings by severity
+	and record them in findings.json in the data directory
+
+General Options:
+  -config=<path>	Configuration file or directory with rule blocks [default: $ROVER_CONFIG_DIR]
+  -output-dir=<dir>	Directory holding the <hostname> data directory [default: $ROVER_OUTPUT_DIR or the latest run]
+  -log-level=<level>	Log level: trace, debug, info, warn or error [default: $ROVER_LOG_LEVEL or info]
+  -log-json		Write rover.log as JSON [default: true when $ROVER_LOG_FORMAT is json]
+  -log-mirror		Print logged warnings to the terminal [default: true]
+  -path=<dir>		Data directory to analyze [default: the <hostname> directory in the output directory]
+`
+
+	return strings.TrimSpace(helpText)
+}
+
+// Run command
+func (c *AnalyzeCommand) Run(args []string) int {
+	run := c.RunContext
+	if run == nil {
+		run = NewRunContext(c.UI)
+	}
+	cmdFlags := flag.NewFlagSet("analyze", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.StringVar(&c.ConfigPath, "config", "", configPathDescr)
+	cmdFlags.StringVar(&c.OutputDir, "output-dir", "", outputDirDescr)
+	cmdFlags.StringVar(&c.DataPath, "path", "", "Data directory to analyze")
+	run.Flags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+	modules := cmdFlags.Args()
+	if len(modules) == 0 {
+		analyzersMu.RLock()
+		names := analyzerNames()
+		analyzersMu.RUnlock()
+		out := fmt.Sprintf("%s\n\nAvailable analyzers: %s", c.Help(), strings.Join(names, ", "))
+		c.UI.Error(out)
+		return 1
+	}
+	// A data directory given with -path may be any bundle, such as one
+	// extracted from an archive, which is named for the host it came from
+	// and whose parent is locked as the output directory
+	dir, mode := c.OutputDir, RunExisting
+	if c.DataPath != "" {
+		p, err := filepath.Abs(c.DataPath)
+		if err == nil {
+			_, err = os.Stat(p)
+		}
+		if err != nil {
+			out := fmt.Sprintf("Cannot find data directory %s; gather data first or pass -path", c.DataPath)
+			c.UI.Error(out)
+			return 1
+		}
+		dir, mode = filepath.Dir(p), RunReuse
+		run.HostName = filepath.Base(p)
+	}
+	if err := run.Open(dir, mode); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	defer run.Close()
+	c.DataPath = run.HostDir()
+	c.HostName = run.HostName
+	logger := run.Logger
+	if err := LoadConfig(ConfigPath(c.ConfigPath), Collectors); err != nil {
+		logger.Error("analyze", "cannot load configuration with error", err.Error())
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	bundle := DirBundle(c.DataPath)
+	for _, m := range modules {
+		analyze, err := lookupAnalyzer(m)
+		if err != nil {
+			logger.Error("analyze", "cannot find analyzer with error", err.Error())
+			c.UI.Error(err.Error())
+			return 1
+		}
+		findings := analyze(bundle)
+		sortFindings(findings)
+		logger.Info("analyze", "analyzed module", m, "findings", len(findings))
+		if err := UpdateFindings(c.DataPath, m, findings); err != nil {
+			logger.Error("analyze", "cannot update findings with error", err.Error())
+			c.UI.Error(err.Error())
+			return 1
+		}
+		c.outputFindings(m, findings)
+	}
+
+	return 0
+}
+
+// outputFindings prints the findings for module, most severe first
+func (c *AnalyzeCommand) outputFindings(module string, findings []Finding) {
+	if len(findings) == 0 {
+		c.UI.Output(fmt.Sprintf("No findings for %s.", module))
+		return
+	}
+	counts := map[string]int{}
+	for _, f := range findings {
+		counts[f.Severity]++
+		switch f.Severity {
+		case SeverityCritical:
+			c.UI.Error(f.String())
+		case SeverityWarning:
+			c.UI.Warn(f.String())
+		default:
+			c.UI.Info(f.String())
+		}
+	}
+	out := fmt.Sprintf("%s: %d critical, %d warning, %d info; recorded in %s",
+		module, counts[SeverityCritical], counts[SeverityWarning], counts[SeverityInfo], filepath.Join(c.DataPath, FindingsFile))
+	c.UI.Output(out)
+}
+
+// Synopsis output
+func (c *AnalyzeCommand) Synopsis() string {
+	return "Analyze gathered data and report findings"
+}
@@ -0,0 +1,308 @@
+// Package command for the Consul analyzer
+// The Consul analyzer checks raft, membership and autopilot state captured
+// by rover consul, preferring API JSON and falling back to CLI output
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Thresholds for the Consul raft timing checks, following the Consul
+// monitoring guidance for consul.raft.commitTime and
+// consul.raft.leader.lastContact
+const (
+	consulCommitTimeWarning   = 100 * time.Millisecond
+	consulCommitTimeCritical  = 500 * time.Millisecond
+	consulLastContactWarning  = 200 * time.Millisecond
+	consulLastContactCritical = 500 * time.Millisecond
+	// consulIndexLagWarning is how far the applied index may trail the
+	// commit index before the FSM is considered to be falling behind
+	consulIndexLagWarning = 1000
+)
+
+// Consul outputs read by the analyzer
+const (
+	consulRaftConfigurationFile = "consul/consul_operator_raft_configuration.json"
+	consulRaftPeersFile         = "consul/consul_operator_raft_list_peers.txt"
+	consulInfoFile              = "consul/consul_info.txt"
+	consulMembersJSONFile       = "consul/consul_agent_members.json"
+	consulMembersFile           = "consul/consul_members.txt"
+	consulAgentSelfFile         = "consul/consul_agent_self.json"
+	consulMetricsFile           = "consul/consul_agent_metrics.json"
+	consulAutopilotFile         = "consul/consul_operator_autopilot_health.json"
+)
+
+func init() {
+	RegisterAnalyzer(Consul, analyzeConsul)
+}
+
+// serfMember is a gossip pool member from either the API or members output
+type serfMember struct {
+	Name    string
+	Status  string
+	Server  bool
+	Version string
+}
+
+// analyzeConsul runs every Consul check over the bundle
+func analyzeConsul(b Bundle) []Finding {
+	findings := []Finding{}
+	add := func(check, severity, message string, sources ...string) {
+		findings = append(findings, Finding{Module: Consul, Check: check, Severity: severity, Message: message, Sources: sources})
+	}
+
+	servers, raftSource := consulRaftServers(b)
+	self, selfErr := consulAgentSelf(b)
+	if raftSource == "" {
+		add("raft_data_missing", SeverityInfo, "No raft peer data was captured; raft checks were skipped")
+	} else {
+		findings = append(findings, raftFindings(Consul, servers, raftSource)...)
+		if selfErr == nil && self.Config.Server {
+			found := false
+			for _, s := range servers {
+				if s.Node == self.Config.NodeName {
+					found = true
+				}
+			}
+			if !found {
+				add("server_not_in_raft", SeverityWarning, fmt.Sprintf("This agent %s is a server but is not a raft peer", self.Config.NodeName), raftSource, consulAgentSelfFile)
+			}
+		}
+	}
+
+	// Raft timing from consul info and telemetry; the worst value is reported
+	info, infoErr := parseConsulInfo(b)
+	metrics, metricsErr := consulTimerMeans(b)
+	var lastContact, commitTime time.Duration
+	lastContactSources := []string{}
+	if infoErr == nil {
+		if d, err := time.ParseDuration(info["raft"]["last_contact"]); err == nil {
+			lastContact = d
+			lastContactSources = append(lastContactSources, consulInfoFile)
+		}
+		commit, cErr := strconv.ParseUint(info["raft"]["commit_index"], 10, 64)
+		applied, aErr := strconv.ParseUint(info["raft"]["applied_index"], 10, 64)
+		if cErr == nil && aErr == nil && commit > applied && commit-applied > consulIndexLagWarning {
+			add("raft_index_lag", SeverityWarning, fmt.Sprintf("The applied index %d trails the commit index %d by %d entries", applied, commit, commit-applied), consulInfoFile)
+		}
+	}
+	if metricsErr == nil {
+		if d, ok := metrics["raft.leader.lastContact"]; ok && d > lastContact {
+			lastContact = d
+			lastContactSources = append(lastContactSources, consulMetricsFile)
+		}
+		commitTime = metrics["raft.commitTime"]
+	}
+	if sev := thresholdSeverity(lastContact, consulLastContactWarning, consulLastContactCritical); sev != "" {
+		add("high_last_contact", sev, fmt.Sprintf("Time since last contact with the leader is %s (warning above %s)", lastContact, consulLastContactWarning), lastContactSources...)
+	}
+	if sev := thresholdSeverity(commitTime, consulCommitTimeWarning, consulCommitTimeCritical); sev != "" {
+		add("high_commit_time", sev, fmt.Sprintf("Mean raft commit time is %s (warning above %s)", commitTime, consulCommitTimeWarning), consulMetricsFile)
+	}
+
+	// Autopilot health
+	var autopilot struct {
+		Healthy          bool
+		FailureTolerance int
+		Servers          []struct {
+			Name    string
+			Healthy bool
+		}
+	}
+	if err := readBundleJSON(b, consulAutopilotFile, &autopilot); err == nil {
+		if !autopilot.Healthy {
+			unhealthy := []string{}
+			for _, s := range autopilot.Servers {
+				if !s.Healthy {
+					unhealthy = append(unhealthy, s.Name)
+				}
+			}
+			add("autopilot_unhealthy", SeverityCritical, fmt.Sprintf("Autopilot reports the cluster unhealthy; unhealthy servers: %s", listOrNone(unhealthy)), consulAutopilotFile)
+		} else if autopilot.FailureTolerance == 0 && len(autopilot.Servers) > 1 {
+			add("no_failure_tolerance", SeverityWarning, "Autopilot reports the cluster cannot tolerate the loss of a server", consulAutopilotFile)
+		}
+	}
+
+	// Membership
+	members, membersSource := consulMembers(b)
+	if membersSource != "" {
+		failed := []string{}
+		failedServer := false
+		versions := map[string]int{}
+		for _, m := range members {
+			if m.Status == "failed" {
+				failed = append(failed, m.Name)
+				failedServer = failedServer || m.Server
+			}
+			if m.Status == "alive" && m.Version != "" {
+				versions[m.Version]++
+			}
+		}
+		if len(failed) > 0 {
+			sev := SeverityWarning
+			if failedServer {
+				sev = SeverityCritical
+			}
+			add("failed_members", sev, fmt.Sprintf("%d member(s) have failed: %s", len(failed), strings.Join(failed, ", ")), membersSource)
+		}
+		if len(versions) > 1 {
+			list := []string{}
+			for v, n := range versions {
+				list = append(list, fmt.Sprintf("%s (%d)", v, n))
+			}
+			sort.Strings(list)
+			add("version_skew", SeverityWarning, fmt.Sprintf("Members run %d different versions: %s", len(versions), strings.Join(list, ", ")), membersSource)
+		}
+	}
+	return findings
+}
+
+// consulRaftServers returns the raft peers and the file they were read from,
+// which is empty when neither the API nor the CLI output could be parsed
+func consulRaftServers(b Bundle) ([]raftServer, string) {
+	var config struct {
+		Servers []struct {
+			Node    string
+			Address string
+			Leader  bool
+			Voter   bool
+		}
+	}
+	if err := readBundleJSON(b, consulRaftConfigurationFile, &config); err == nil && len(config.Servers) > 0 {
+		servers := []raftServer{}
+		for _, s := range config.Servers {
+			servers = append(servers, raftServer{Node: s.Node, Address: s.Address, Leader: s.Leader, Voter: s.Voter})
+		}
+		return servers, consulRaftConfigurationFile
+	}
+	data, err := b.ReadFile(consulRaftPeersFile)
+	if err != nil {
+		return nil, ""
+	}
+	rows := parseTable(string(data), "Node")
+	if len(rows) == 0 {
+		return nil, ""
+	}
+	servers := []raftServer{}
+	for _, r := range rows {
+		servers = append(servers, raftServer{
+			Node:    r["Node"],
+			Address: r["Address"],
+			Leader:  strings.EqualFold(r["State"], "leader"),
+			Voter:   r["Voter"] == "true",
+		})
+	}
+	return servers, consulRaftPeersFile
+}
+
+// consulMembers returns the gossip members and the file they were read from
+func consulMembers(b Bundle) ([]serfMember, string) {
+	var api []struct {
+		Name   string
+		Status int
+		Tags   map[string]string
+	}
+	if err := readBundleJSON(b, consulMembersJSONFile, &api); err == nil && len(api) > 0 {
+		// Serf member status codes
+		statuses := map[int]string{0: "none", 1: "alive", 2: "leaving", 3: "left", 4: "failed"}
+		members := []serfMember{}
+		for _, m := range api {
+			members = append(members, serfMember{
+				Name:    m.Name,
+				Status:  statuses[m.Status],
+				Server:  m.Tags["role"] == "consul",
+				Version: strings.SplitN(m.Tags["build"], ":", 2)[0],
+			})
+		}
+		return members, consulMembersJSONFile
+	}
+	data, err := b.ReadFile(consulMembersFile)
+	if err != nil {
+		return nil, ""
+	}
+	rows := parseTable(string(data), "Node")
+	if len(rows) == 0 {
+		return nil, ""
+	}
+	members := []serfMember{}
+	for _, r := range rows {
+		members = append(members, serfMember{
+			Name:    r["Node"],
+			Status:  r["Status"],
+			Server:  r["Type"] == "server",
+			Version: r["Build"],
+		})
+	}
+	return members, consulMembersFile
+}
+
+// consulSelf is the part of /v1/agent/self the analyzer uses
+type consulSelf struct {
+	Config struct {
+		NodeName string
+		Server   bool
+		Version  string
+	}
+}
+
+// consulAgentSelf reads the local agent's configuration
+func consulAgentSelf(b Bundle) (*consulSelf, error) {
+	self := &consulSelf{}
+	if err := readBundleJSON(b, consulAgentSelfFile, self); err != nil {
+		return nil, err
+	}
+	return self, nil
+}
+
+// consulTimerMeans returns the mean of each timer sample in the captured
+// telemetry keyed by name without its service prefix, e.g. raft.commitTime
+func consulTimerMeans(b Bundle) (map[string]time.Duration, error) {
+	var metrics struct {
+		Samples []struct {
+			Name string
+			Mean float64
+		}
+	}
+	if err := readBundleJSON(b, consulMetricsFile, &metrics); err != nil {
+		return nil, err
+	}
+	means := map[string]time.Duration{}
+	for _, s := range metrics.Samples {
+		// Timers are reported in milliseconds
+		means[strings.TrimPrefix(s.Name, "consul.")] = time.Duration(s.Mean * float64(time.Millisecond))
+	}
+	return means, nil
+}
+
+// parseConsulInfo parses consul info output into its sections, e.g.
+// info["raft"]["commit_index"]
+func parseConsulInfo(b Bundle) (map[string]map[string]string, error) {
+	data, err := b.ReadFile(consulInfoFile)
+	if err != nil {
+		return nil, err
+	}
+	info := map[string]map[string]string{}
+	section := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+		case !strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, " ") && strings.HasSuffix(trimmed, ":"):
+			section = strings.TrimSuffix(trimmed, ":")
+			info[section] = map[string]string{}
+		case section != "":
+			kv := strings.SplitN(trimmed, "=", 2)
+			if len(kv) == 2 {
+				info[section][strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+			}
+		}
+	}
+	if len(info) == 0 {
+		return nil, fmt.Errorf("no sections found in %s", consulInfoFile)
+	}
+	return info, nil
+}
@@ -0,0 +1,134 @@
+// Package command for the system analyzer
+// The system analyzer evaluates the registered rules over the output of
+// rover system; the built in rules below use the same format as rule blocks
+// in configuration, which can add to or override them
+package command
+
+import "fmt"
+
+// builtinRules are the system checks shipped with rover
+const builtinRules = `
+rule "swappiness" {
+  file    = "system/proc_sys_vm_swappiness.txt"
+  parser  = "regex"
+  pattern = "^\\s*(?P<value>\\d+)\\s*$"
+  op      = ">"
+  warning = 10
+  message = "vm.swappiness is {value}; {threshold} or lower keeps server memory from being swapped out"
+}
+
+rule "filesystem_full" {
+  file     = "system/df.txt"
+  parser   = "regex"
+  pattern  = "(?m)^\\S+\\s+\\d+\\s+\\d+\\s+\\d+\\s+(?P<value>\\d+)%\\s+(?P<name>.+)$"
+  op       = ">="
+  warning  = 85
+  critical = 95
+  message  = "Filesystem {name} is {value}% full"
+}
+
+rule "inodes_exhausted" {
+  file     = "system/df_i.txt"
+  parser   = "regex"
+  pattern  = "(?m)^\\S+\\s+\\d+\\s+\\d+\\s+\\d+\\s+(?P<value>\\d+)%\\s+(?P<name>.+)$"
+  op       = ">="
+  warning  = 85
+  critical = 95
+  message  = "Filesystem {name} has used {value}% of its inodes"
+}
+
+rule "rx_crc_errors" {
+  file    = "system/rx_crc_errors.txt"
+  parser  = "regex"
+  pattern = "(?m)^/sys/class/net/(?P<name>[^/\\s]+)\\n(?P<value>\\d+)$"
+  op      = ">"
+  warning = 0
+  message = "Interface {name} has {value} receive CRC errors, which usually point to a bad cable, port or NIC"
+}
+
+rule "io_scheduler" {
+  file    = "system/schedulers.txt"
+  parser  = "regex"
+  pattern = "(?m)^/sys/block/(?P<name>[^/\\s]+)\\n[^\\n]*\\[(?P<value>[^\\]]+)\\]"
+  op      = "=="
+  info    = "cfq"
+  message = "Block device {name} uses the {value} I/O scheduler; deadline or none suits SSDs and virtual disks better"
+}
+
+rule "selinux_enforcing" {
+  file    = "system/sestatus.txt"
+  parser  = "regex"
+  pattern = "(?m)^Current mode:\\s+(?P<value>\\S+)"
+  op      = "=="
+  info    = "enforcing"
+  message = "SELinux is {value}; check the audit log for denials if an agent cannot bind ports or read its files"
+}
+
+rule "nofile_limit" {
+  file    = "system/file_etc_security_limits.txt"
+  parser  = "regex"
+  pattern = "(?m)^[ \\t]*(?P<name>[^#\\s]\\S*)\\s+(?:soft|hard|-)\\s+nofile\\s+(?P<value>\\d+)"
+  op      = "<"
+  warning = 65536
+  message = "The nofile limit for {name} is {value}; at least {threshold} is recommended"
+}
+
+rule "clock_unsynchronized" {
+  file    = "system/timedatectl.txt"
+  parser  = "regex"
+  pattern = "(?m)^\\s*(?:System clock|NTP) synchronized:\\s*(?P<value>\\S+)"
+  op      = "=="
+  warning = "no"
+  message = "The system clock is not synchronized, which disturbs raft timing and TLS certificate validation"
+}
+
+rule "clock_drift" {
+  file     = "system/chronyc_tracking.txt"
+  parser   = "regex"
+  pattern  = "(?m)^System time\\s*:\\s*(?P<value>[0-9.]+) seconds (?P<name>fast|slow)"
+  op       = ">"
+  warning  = 0.1
+  critical = 1
+  message  = "The system clock is {value} seconds {name} of NTP time"
+}
+
+rule "oom_killer" {
+  file    = "system/dmesg.txt"
+  parser  = "match"
+  pattern = "(?i)out of memory|oom-killer|oom_kill_process"
+  op      = ">"
+  warning = 0
+  message = "The kernel log mentions the OOM killer {value} time(s), first: {name}"
+}
+`
+
+func init() {
+	cfg := &Config{}
+	if issues := parseConfigBytes(cfg, "builtin rules", []byte(builtinRules)); len(issues) > 0 {
+		panic(fmt.Sprintf("invalid built in rules: %v", issues))
+	}
+	for _, cr := range cfg.Rules {
+		Collectors.AddRule(cr.Rule)
+	}
+	RegisterAnalyzer("system", analyzeSystem)
+}
+
+// analyzeSystem evaluates every registered rule over the bundle; rules whose
+// output was not captured, e.g. on another OS, are skipped
+func analyzeSystem(b Bundle) []Finding {
+	findings := []Finding{}
+	evaluated := 0
+	for _, rule := range Collectors.Rules() {
+		f, err := rule.Evaluate("system", b)
+		if err != nil {
+			continue
+		}
+		evaluated++
+		findings = append(findings, f...)
+	}
+	if evaluated == 0 {
+		findings = append(findings, Finding{Module: "system", Check: "system_data_missing", Severity: SeverityInfo,
+			Message: "None of the outputs the rules read were captured; system checks were skipped"})
+	}
+	return findings
+}
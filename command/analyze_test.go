@@ -0,0 +1,254 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeBundle writes files into a temporary host directory
+func writeBundle(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "rover-analyze")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// checkFindings fails unless findings holds exactly the want check names
+// with their severities
+func checkFindings(t *testing.T, findings []Finding, want map[string]string) {
+	got := map[string]string{}
+	for _, f := range findings {
+		got[f.Check] = f.Severity
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected findings %v, got %v", want, findings)
+	}
+	for check, sev := range want {
+		if got[check] != sev {
+			t.Errorf("expected %s finding %s, got %q in %v", sev, check, got[check], findings)
+		}
+	}
+}
+
+func TestAnalyzeConsul(t *testing.T) {
+	dir := writeBundle(t, map[string]string{
+		// Text fallbacks: four voters without a leader
+		consulRaftPeersFile: `Node     ID          Address         State     Voter  RaftProtocol
+server1  0b4c-1     10.0.0.1:8300   follower  true   3
+server2  0b4c-2     10.0.0.2:8300   follower  true   3
+server3  0b4c-3     10.0.0.3:8300   follower  true   3
+server4  0b4c-4     10.0.0.4:8300   follower  false  3
+server5  0b4c-5     10.0.0.5:8300   follower  true   3
+`,
+		consulInfoFile: `agent:
+	check_monitors = 0
+raft:
+	applied_index = 1000
+	commit_index = 5000
+	last_contact = 350ms
+	state = Follower
+`,
+		consulMembersFile: `Node     Address         Status  Type    Build  Protocol  DC   Segment
+server1  10.0.0.1:8301   alive   server  1.4.3  2         dc1  <all>
+server2  10.0.0.2:8301   failed  server  1.4.3  2         dc1  <all>
+client1  10.0.0.9:8301   alive   client  1.4.2  2         dc1  <default>
+`,
+		consulAgentSelfFile: `{"Config": {"NodeName": "server9", "Server": true}}`,
+		consulMetricsFile:   `{"Samples": [{"Name": "consul.raft.commitTime", "Mean": 612.5}]}`,
+		consulAutopilotFile: `{"Healthy": false, "FailureTolerance": 0, "Servers": [{"Name": "server1", "Healthy": true}, {"Name": "server2", "Healthy": false}]}`,
+	})
+	defer os.RemoveAll(dir)
+
+	findings := analyzeConsul(DirBundle(dir))
+	checkFindings(t, findings, map[string]string{
+		"no_leader":           SeverityCritical,
+		"even_voters":         SeverityWarning,
+		"server_not_in_raft":  SeverityWarning,
+		"raft_index_lag":      SeverityWarning,
+		"high_last_contact":   SeverityWarning,
+		"high_commit_time":    SeverityCritical,
+		"autopilot_unhealthy": SeverityCritical,
+		"failed_members":      SeverityCritical,
+		"version_skew":        SeverityWarning,
+	})
+
+	// The API outputs take precedence over the CLI outputs
+	healthy := writeBundle(t, map[string]string{
+		consulRaftConfigurationFile: `{"Servers": [{"Node": "server1", "Leader": true, "Voter": true}, {"Node": "server2", "Voter": true}, {"Node": "server3", "Voter": true}]}`,
+		consulRaftPeersFile:         "Node  ID  Address  State  Voter  RaftProtocol\n",
+		consulMembersJSONFile:       `[{"Name": "server1", "Status": 1, "Tags": {"role": "consul", "build": "1.4.3:ea5210a3"}}, {"Name": "client1", "Status": 3, "Tags": {"build": "1.4.2:40cb1a3c"}}]`,
+	})
+	defer os.RemoveAll(healthy)
+	checkFindings(t, analyzeConsul(DirBundle(healthy)), map[string]string{})
+}
+
+func TestAnalyzeVault(t *testing.T) {
+	dir := writeBundle(t, map[string]string{
+		// CLI outputs only: a sealed standby with no audit devices
+		vaultStatusFile: `Key                Value
+---                -----
+Seal Type          shamir
+Initialized        true
+Sealed             true
+Total Shares       5
+Threshold          3
+Unseal Progress    0/3
+Version            1.3.2
+HA Enabled         true
+`,
+		vaultAuditListFile: "No audit devices are enabled.\n",
+		vaultVersionFile:   "Vault v1.4.3 ('dd2e2ec6bbd0e7e0e6c7e0a0d51d86ec7ea2d5aa')\n",
+		vaultLimitsFile: `Limit                     Soft Limit           Hard Limit           Units
+Max cpu time              unlimited            unlimited            seconds
+Max open files            1024                 4096                 files
+`,
+		vaultRaftConfigurationFile: `{"data": {"config": {"servers": [{"node_id": "vault1", "leader": false, "voter": true}, {"node_id": "vault2", "leader": false, "voter": true}]}}}`,
+		vaultHAStatusFile:          `{"nodes": [{"hostname": "vault1", "active_node": false}, {"hostname": "vault2", "active_node": false}]}`,
+		vaultLeaderFile:            `{"ha_enabled": true, "is_self": false, "leader_address": ""}`,
+	})
+	defer os.RemoveAll(dir)
+
+	checkFindings(t, analyzeVault(DirBundle(dir)), map[string]string{
+		"sealed":               SeverityCritical,
+		"no_active_node":       SeverityCritical,
+		"ha_active_nodes":      SeverityCritical,
+		"no_audit_devices":     SeverityWarning,
+		"outdated_server":      SeverityWarning,
+		"no_leader":            SeverityCritical,
+		"even_voters":          SeverityWarning,
+		"low_open_files_limit": SeverityWarning,
+	})
+
+	// The API outputs take precedence over the CLI outputs
+	healthy := writeBundle(t, map[string]string{
+		vaultHealthFile:    `{"initialized": true, "sealed": false, "standby": false, "version": "1.4.3"}`,
+		vaultStatusFile:    "Key  Value\nSealed  true\n",
+		vaultLeaderFile:    `{"ha_enabled": true, "is_self": true, "leader_address": "https://10.0.0.1:8200"}`,
+		vaultAuditFile:     `{"file/": {"type": "file", "path": "file/"}, "request_id": "d1f2"}`,
+		vaultAuditListFile: "No audit devices are enabled.\n",
+		vaultVersionFile:   "Vault v1.4.3\n",
+		vaultLimitsFile:    "Limit  Soft Limit  Hard Limit  Units\nMax open files  65536  65536  files\n",
+	})
+	defer os.RemoveAll(healthy)
+	checkFindings(t, analyzeVault(DirBundle(healthy)), map[string]string{})
+}
+
+func TestAnalyzeSystem(t *testing.T) {
+	dir := writeBundle(t, map[string]string{
+		"system/proc_sys_vm_swappiness.txt": "60\n",
+		"system/df.txt": `Filesystem     1K-blocks     Used Available Use% Mounted on
+/dev/sda1       41152736 40000000   1152736  98% /
+/dev/sdb1      103080224 99000000   4080224  96% /opt/consul data
+tmpfs             816388     1000    815388   1% /run
+`,
+		"system/df_i.txt": `Filesystem      Inodes  IUsed   IFree IUse% Mounted on
+/dev/sda1      2621440 100000 2521440    4% /
+`,
+		"system/rx_crc_errors.txt":            "/sys/class/net/eth0\n12\n/sys/class/net/lo\n0\n",
+		"system/schedulers.txt":               "/sys/block/sda\nnoop deadline [cfq]\n/sys/block/nvme0n1\n[none] mq-deadline\n",
+		"system/sestatus.txt":                 "SELinux status:                 enabled\nCurrent mode:                   enforcing\n",
+		"system/file_etc_security_limits.txt": "# * soft nofile 1024\nconsul soft nofile 4096\nvault - nofile 65536\n",
+		"system/timedatectl.txt":              "      Local time: Sat 2026-10-17 19:03:50 UTC\nSystem clock synchronized: no\n",
+		"system/chronyc_tracking.txt":         "Reference ID    : A9FEA97B (169.254.169.123)\nSystem time     : 1.500000000 seconds slow of NTP time\n",
+		"system/dmesg.txt":                    "[ 1.0] eth0: link up\n[ 9.1] consul invoked oom-killer: gfp_mask=0x100cca\n[ 9.2] Out of memory: Killed process 812 (consul)\n",
+	})
+	defer os.RemoveAll(dir)
+
+	findings := analyzeSystem(DirBundle(dir))
+	checkFindings(t, findings, map[string]string{
+		"swappiness":           SeverityWarning,
+		"filesystem_full":      SeverityCritical,
+		"rx_crc_errors":        SeverityWarning,
+		"io_scheduler":         SeverityInfo,
+		"selinux_enforcing":    SeverityInfo,
+		"nofile_limit":         SeverityWarning,
+		"clock_unsynchronized": SeverityWarning,
+		"clock_drift":          SeverityCritical,
+		"oom_killer":           SeverityWarning,
+	})
+	messages := []string{}
+	for _, f := range findings {
+		messages = append(messages, f.Message)
+	}
+	for _, want := range []string{
+		"Filesystem / is 98% full",
+		"Filesystem /opt/consul data is 96% full",
+		"Interface eth0 has 12 receive CRC errors",
+		"The nofile limit for consul is 4096",
+		"mentions the OOM killer 2 time(s)",
+	} {
+		if !strings.Contains(strings.Join(messages, "\n"), want) {
+			t.Errorf("expected a finding containing %q in %v", want, messages)
+		}
+	}
+
+	empty := writeBundle(t, nil)
+	defer os.RemoveAll(empty)
+	checkFindings(t, analyzeSystem(DirBundle(empty)), map[string]string{"system_data_missing": SeverityInfo})
+}
+
+func TestRuleTableParser(t *testing.T) {
+	rule, problems := ruleConfig{
+		File:       "system/free.txt",
+		Parser:     ParserTable,
+		Header:     "total",
+		Column:     "free",
+		NameColumn: "total",
+		Op:         "<",
+		Warning:    512,
+		Message:    "Only {value} MB free",
+	}.rule("low_memory")
+	if len(problems) > 0 {
+		t.Fatal(problems)
+	}
+	dir := writeBundle(t, map[string]string{"system/free.txt": "total used free shared cache available\n7976 7700 276 1 0 0\n"})
+	defer os.RemoveAll(dir)
+	findings, err := rule.Evaluate("system", DirBundle(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].Message != "Only 276 MB free" {
+		t.Fatalf("expected one low memory finding, got %v", findings)
+	}
+}
+
+func TestUpdateFindings(t *testing.T) {
+	dir := writeBundle(t, nil)
+	defer os.RemoveAll(dir)
+	if err := UpdateFindings(dir, Consul, []Finding{
+		{Module: Consul, Check: "even_voters", Severity: SeverityWarning},
+		{Module: Consul, Check: "no_leader", Severity: SeverityCritical},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateFindings(dir, Vault, []Finding{{Module: Vault, Check: "sealed", Severity: SeverityCritical}}); err != nil {
+		t.Fatal(err)
+	}
+	// Analyzing a module again replaces only its findings
+	if err := UpdateFindings(dir, Consul, nil); err != nil {
+		t.Fatal(err)
+	}
+	r, err := ReadFindings(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Findings) != 1 || r.Findings[0].Check != "sealed" {
+		t.Fatalf("expected only the vault finding, got %v", r.Findings)
+	}
+	if _, ok := r.Analyzed[Consul]; !ok {
+		t.Fatal("expected consul to be recorded as analyzed")
+	}
+}
@@ -0,0 +1,270 @@
+// Package command for the Vault analyzer
+// The Vault analyzer checks seal, HA, audit, version, raft and process limit
+// state captured by rover vault, preferring API JSON over CLI output
+package command
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// vaultOpenFilesMinimum is the lowest open file limit recommended for a
+// Vault server, which holds a descriptor per client connection and lease
+// storage file
+const vaultOpenFilesMinimum = 65536
+
+// Vault outputs read by the analyzer
+const (
+	vaultHealthFile            = "vault/vault_sys_health.json"
+	vaultSealStatusFile        = "vault/vault_sys_seal_status.json"
+	vaultLeaderFile            = "vault/vault_sys_leader.json"
+	vaultHAStatusFile          = "vault/vault_sys_ha_status.json"
+	vaultAuditFile             = "vault/vault_sys_audit.json"
+	vaultRaftConfigurationFile = "vault/vault_sys_storage_raft_configuration.json"
+	vaultStatusFile            = "vault/vault_status.txt"
+	vaultAuditListFile         = "vault/vault_audit_list.txt"
+	vaultVersionFile           = "vault/vault_version.txt"
+	vaultLimitsFile            = "vault/proc_vault_limits.txt"
+)
+
+// columnSeparator splits aligned CLI output whose values or names may
+// contain single spaces
+var columnSeparator = regexp.MustCompile(`\s{2,}|\t+`)
+
+func init() {
+	RegisterAnalyzer(Vault, analyzeVault)
+}
+
+// vaultHealth is the part of sys/health, with sys/seal-status and the
+// status command as fallbacks, that the analyzer uses
+type vaultHealth struct {
+	Initialized bool   `json:"initialized"`
+	Sealed      bool   `json:"sealed"`
+	Standby     bool   `json:"standby"`
+	Version     string `json:"version"`
+	// HAEnabled is only known from the status command
+	HAEnabled *bool `json:"-"`
+}
+
+// analyzeVault runs every Vault check over the bundle
+func analyzeVault(b Bundle) []Finding {
+	findings := []Finding{}
+	add := func(check, severity, message string, sources ...string) {
+		findings = append(findings, Finding{Module: Vault, Check: check, Severity: severity, Message: message, Sources: sources})
+	}
+
+	// Seal state
+	health, healthSource := vaultServerHealth(b)
+	if healthSource == "" {
+		add("status_data_missing", SeverityInfo, "No health or status data was captured; seal and version checks were skipped")
+	} else {
+		if !health.Initialized {
+			add("not_initialized", SeverityCritical, "Vault is not initialized", healthSource)
+		} else if health.Sealed {
+			add("sealed", SeverityCritical, "Vault is sealed and cannot serve requests until it is unsealed", healthSource)
+		}
+	}
+
+	// HA consistency between the leader, HA status and health views
+	var leader struct {
+		HAEnabled     bool   `json:"ha_enabled"`
+		IsSelf        bool   `json:"is_self"`
+		LeaderAddress string `json:"leader_address"`
+	}
+	if err := readBundleJSON(b, vaultLeaderFile, &leader); err == nil && leader.HAEnabled {
+		if leader.LeaderAddress == "" {
+			add("no_active_node", SeverityCritical, "HA is enabled but no active node is known", vaultLeaderFile)
+		}
+		if healthSource == vaultHealthFile && health.Initialized && !health.Sealed && health.Standby == leader.IsSelf {
+			state := "active"
+			if health.Standby {
+				state = "a standby"
+			}
+			add("ha_state_mismatch", SeverityWarning, fmt.Sprintf("The health check reports this node as %s, which the leader status contradicts", state), vaultHealthFile, vaultLeaderFile)
+		}
+	}
+	if nodes, err := vaultHANodes(b); err == nil && len(nodes) > 0 {
+		active := []string{}
+		for _, n := range nodes {
+			if n.ActiveNode {
+				active = append(active, n.Hostname)
+			}
+		}
+		if len(active) != 1 {
+			add("ha_active_nodes", SeverityCritical, fmt.Sprintf("Expected one active node among %d HA nodes, found %d: %s", len(nodes), len(active), listOrNone(active)), vaultHAStatusFile)
+		}
+	} else if healthSource == vaultStatusFile && health.HAEnabled != nil && !*health.HAEnabled {
+		add("ha_disabled", SeverityInfo, "HA is not enabled for this storage backend", vaultStatusFile)
+	}
+
+	// Audit devices
+	if devices, source, ok := vaultAuditDevices(b); ok && devices == 0 {
+		add("no_audit_devices", SeverityWarning, "No audit devices are enabled, so requests are not being audited", source)
+	}
+
+	// Server version compared with the vault binary on this host
+	if out, err := b.ReadFile(vaultVersionFile); err == nil && health.Version != "" {
+		if m := productVersion.FindStringSubmatch(string(out)); m != nil {
+			binary, bErr := version.NewVersion(m[1])
+			server, sErr := version.NewVersion(health.Version)
+			switch {
+			case bErr != nil || sErr != nil:
+			case server.LessThan(binary):
+				add("outdated_server", SeverityWarning, fmt.Sprintf("The server runs %s but the vault binary is %s; restart the server to complete the upgrade", server, binary), healthSource, vaultVersionFile)
+			case binary.LessThan(server):
+				add("outdated_binary", SeverityInfo, fmt.Sprintf("The vault binary %s is older than the server %s", binary, server), healthSource, vaultVersionFile)
+			}
+		}
+	}
+
+	// Integrated storage peers
+	var raft struct {
+		Data struct {
+			Config struct {
+				Servers []struct {
+					NodeID  string `json:"node_id"`
+					Address string `json:"address"`
+					Leader  bool   `json:"leader"`
+					Voter   bool   `json:"voter"`
+				} `json:"servers"`
+			} `json:"config"`
+		} `json:"data"`
+	}
+	if err := readBundleJSON(b, vaultRaftConfigurationFile, &raft); err == nil && len(raft.Data.Config.Servers) > 0 {
+		servers := []raftServer{}
+		for _, s := range raft.Data.Config.Servers {
+			servers = append(servers, raftServer{Node: s.NodeID, Address: s.Address, Leader: s.Leader, Voter: s.Voter})
+		}
+		findings = append(findings, raftFindings(Vault, servers, vaultRaftConfigurationFile)...)
+	}
+
+	// Open file limit of the server process
+	if out, err := b.ReadFile(vaultLimitsFile); err == nil {
+		if soft, ok := parseProcLimits(string(out))["Max open files"]; ok && soft != "unlimited" {
+			if n, err := strconv.Atoi(soft); err == nil && n < vaultOpenFilesMinimum {
+				add("low_open_files_limit", SeverityWarning, fmt.Sprintf("The open file limit is %d; at least %d is recommended", n, vaultOpenFilesMinimum), vaultLimitsFile)
+			}
+		}
+	}
+	return findings
+}
+
+// vaultServerHealth returns the server health and the file it was read from,
+// which is empty when no health, seal status or status output was parsed
+func vaultServerHealth(b Bundle) (vaultHealth, string) {
+	h := vaultHealth{}
+	if err := readBundleJSON(b, vaultHealthFile, &h); err == nil && h.Version != "" {
+		return h, vaultHealthFile
+	}
+	var seal struct {
+		Initialized bool   `json:"initialized"`
+		Sealed      bool   `json:"sealed"`
+		Version     string `json:"version"`
+	}
+	if err := readBundleJSON(b, vaultSealStatusFile, &seal); err == nil && seal.Version != "" {
+		return vaultHealth{Initialized: seal.Initialized, Sealed: seal.Sealed, Version: seal.Version}, vaultSealStatusFile
+	}
+	out, err := b.ReadFile(vaultStatusFile)
+	if err != nil {
+		return h, ""
+	}
+	status := parseKeyValues(string(out))
+	if status["Sealed"] == "" {
+		return h, ""
+	}
+	h = vaultHealth{
+		Initialized: status["Initialized"] == "true",
+		Sealed:      status["Sealed"] == "true",
+		Standby:     status["HA Mode"] == "standby",
+		Version:     status["Version"],
+	}
+	if v, ok := status["HA Enabled"]; ok {
+		enabled := v == "true"
+		h.HAEnabled = &enabled
+	}
+	return h, vaultStatusFile
+}
+
+// vaultHANode is a node listed by sys/ha-status
+type vaultHANode struct {
+	Hostname   string `json:"hostname"`
+	ActiveNode bool   `json:"active_node"`
+}
+
+// vaultHANodes reads the HA nodes, which newer versions also nest in data
+func vaultHANodes(b Bundle) ([]vaultHANode, error) {
+	var status struct {
+		Nodes []vaultHANode `json:"nodes"`
+		Data  struct {
+			Nodes []vaultHANode `json:"nodes"`
+		} `json:"data"`
+	}
+	if err := readBundleJSON(b, vaultHAStatusFile, &status); err != nil {
+		return nil, err
+	}
+	if len(status.Nodes) > 0 {
+		return status.Nodes, nil
+	}
+	return status.Data.Nodes, nil
+}
+
+// vaultAuditDevices returns the number of enabled audit devices and the file
+// it was read from; ok is false when neither output could be parsed
+func vaultAuditDevices(b Bundle) (int, string, bool) {
+	var audit map[string]interface{}
+	if err := readBundleJSON(b, vaultAuditFile, &audit); err == nil {
+		if data, ok := audit["data"].(map[string]interface{}); ok {
+			audit = data
+		}
+		devices := 0
+		for _, v := range audit {
+			// Devices are objects with a type; response metadata is not
+			if d, ok := v.(map[string]interface{}); ok && d["type"] != nil {
+				devices++
+			}
+		}
+		return devices, vaultAuditFile, true
+	}
+	out, err := b.ReadFile(vaultAuditListFile)
+	if err != nil {
+		return 0, "", false
+	}
+	if strings.Contains(string(out), "No audit devices are enabled") {
+		return 0, vaultAuditListFile, true
+	}
+	return len(parseTable(string(out), "Path")), vaultAuditListFile, true
+}
+
+// parseKeyValues parses two column "Key Value" CLI output, whose keys may
+// contain single spaces, into a map
+func parseKeyValues(text string) map[string]string {
+	values := map[string]string{}
+	for _, line := range strings.Split(text, "\n") {
+		kv := columnSeparator.Split(strings.TrimSpace(line), 2)
+		if len(kv) == 2 {
+			values[kv[0]] = strings.TrimSpace(kv[1])
+		}
+	}
+	return values
+}
+
+// parseProcLimits returns the soft limit for each resource in the output
+// of /proc/<pid>/limits, keyed by resource name, e.g. "Max open files"
+func parseProcLimits(text string) map[string]string {
+	limits := map[string]string{}
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "Limit") {
+			continue
+		}
+		// Resource names are padded to the soft limit column
+		fields := columnSeparator.Split(strings.TrimSpace(line), -1)
+		if len(fields) >= 2 {
+			limits[fields[0]] = fields[1]
+		}
+	}
+	return limits
+}
@@ -0,0 +1,315 @@
+// Package command for API clients
+// API clients fetch data from the HTTP APIs of HashiCorp runtime tools in
+// process, so tokens never appear on a command line
+package command
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// APIClient fetches a path from a product HTTP API for API tasks
+type APIClient interface {
+	Fetch(ctx context.Context, path string, w io.Writer) error
+}
+
+// APIError is returned for responses with a non-2xx status code
+type APIError struct {
+	Path       string
+	StatusCode int
+	Body       string
+}
+
+// Error formats the status and the start of the response body
+func (e *APIError) Error() string {
+	return fmt.Sprintf("GET %s returned %d: %s", e.Path, e.StatusCode, e.Body)
+}
+
+// isForbidden reports whether err is an API response refusing the request
+// for lack of permission, e.g. an ACL token without the needed policy
+func isForbidden(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusForbidden
+}
+
+// errResponseCapped stops a fetch once a cappedBuffer is full
+var errResponseCapped = errors.New("response exceeds size cap")
+
+// cappedBuffer holds at most max bytes when max is above zero, failing the
+// write which would exceed it so that the rest of a large response is never
+// downloaded. It does not embed bytes.Buffer since io.Copy would then use
+// its ReadFrom and bypass the cap
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int64
+	truncated bool
+}
+
+// Write appends p up to the cap
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.max > 0 && int64(b.buf.Len()+len(p)) > b.max {
+		n, _ := b.buf.Write(p[:b.max-int64(b.buf.Len())])
+		b.truncated = true
+		return n, errResponseCapped
+	}
+	return b.buf.Write(p)
+}
+
+// TLSOptions configures the TLS connection to an API
+type TLSOptions struct {
+	CACert     string
+	CAPath     string
+	ClientCert string
+	ClientKey  string
+	ServerName string
+	Insecure   bool
+}
+
+// HTTPClient is an APIClient for an HTTP API authenticated with a token
+// passed in a request header
+type HTTPClient struct {
+	// Address is the base URL, e.g. https://127.0.0.1:8501
+	Address string
+	// Headers are sent with every request, e.g. the token header
+	Headers http.Header
+	// Query parameters are added to every request which does not set
+	// them itself, e.g. the Nomad region
+	Query  url.Values
+	Client *http.Client
+}
+
+// NewHTTPClient returns a client for addr, which may omit its scheme; the
+// scheme is https when useTLS is set
+func NewHTTPClient(addr string, useTLS bool, opts TLSOptions) (*HTTPClient, error) {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	if i := strings.Index(addr, "://"); i >= 0 {
+		scheme, addr = addr[:i], addr[i+3:]
+	}
+	if scheme != "http" && scheme != "https" {
+		return nil, fmt.Errorf("unsupported address scheme %q", scheme)
+	}
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if scheme == "https" {
+		cfg, err := newTLSConfig(opts)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = cfg
+	}
+	return &HTTPClient{
+		Address: scheme + "://" + strings.TrimSuffix(addr, "/"),
+		Headers: http.Header{},
+		Query:   url.Values{},
+		Client:  &http.Client{Transport: transport},
+	}, nil
+}
+
+// Fetch requests path and copies the response body to w
+func (c *HTTPClient) Fetch(ctx context.Context, path string, w io.Writer) error {
+	u, err := url.Parse(c.Address + path)
+	if err != nil {
+		return err
+	}
+	if len(c.Query) > 0 {
+		q := u.Query()
+		for k, v := range c.Query {
+			if _, ok := q[k]; !ok {
+				q[k] = v
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range c.Headers {
+		req.Header[k] = v
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return &APIError{Path: path, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(b))}
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// newTLSConfig builds the TLS configuration for opts
+func newTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: opts.ServerName, InsecureSkipVerify: opts.Insecure}
+	if opts.CACert != "" || opts.CAPath != "" {
+		pool := x509.NewCertPool()
+		files := []string{}
+		if opts.CACert != "" {
+			files = append(files, opts.CACert)
+		}
+		if opts.CAPath != "" {
+			matches, err := filepath.Glob(filepath.Join(opts.CAPath, "*"))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+		}
+		for _, f := range files {
+			pem, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read CA certificate %s with error %v", f, err)
+			}
+			if !pool.AppendCertsFromPEM(pem) && f == opts.CACert {
+				return nil, fmt.Errorf("no certificates found in %s", f)
+			}
+		}
+		cfg.RootCAs = pool
+	}
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate with error %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// envBool reports whether the environment variable name is set to a true
+// value, returning def when it is unset
+func envBool(name string, def bool) bool {
+	switch strings.ToLower(os.Getenv(name)) {
+	case "":
+		return def
+	case "1", "t", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// envToken returns the token from the environment variable name, or from
+// the file named by fileName when only that is set
+func envToken(name string, fileName string) (string, error) {
+	if t := os.Getenv(name); t != "" {
+		return t, nil
+	}
+	if f := os.Getenv(fileName); f != "" {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return "", fmt.Errorf("cannot read %s with error %v", fileName, err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return "", nil
+}
+
+// NewConsulClient returns a client for the local Consul agent configured
+// from the same environment variables as the consul CLI
+func NewConsulClient() (*HTTPClient, error) {
+	addr := os.Getenv("CONSUL_HTTP_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:8500"
+	}
+	c, err := NewHTTPClient(addr, envBool("CONSUL_HTTP_SSL", false), TLSOptions{
+		CACert:     os.Getenv("CONSUL_CACERT"),
+		CAPath:     os.Getenv("CONSUL_CAPATH"),
+		ClientCert: os.Getenv("CONSUL_CLIENT_CERT"),
+		ClientKey:  os.Getenv("CONSUL_CLIENT_KEY"),
+		ServerName: os.Getenv("CONSUL_TLS_SERVER_NAME"),
+		Insecure:   !envBool("CONSUL_HTTP_SSL_VERIFY", true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	token, err := envToken("CONSUL_HTTP_TOKEN", "CONSUL_HTTP_TOKEN_FILE")
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		c.Headers.Set("X-Consul-Token", token)
+	}
+	return c, nil
+}
+
+// NewVaultClient returns a client for the local Vault server configured
+// from the same environment variables as the vault CLI, falling back to the
+// token helper file ~/.vault-token when VAULT_TOKEN is unset
+func NewVaultClient() (*HTTPClient, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		addr = "https://127.0.0.1:8200"
+	}
+	c, err := NewHTTPClient(addr, false, TLSOptions{
+		CACert:     os.Getenv("VAULT_CACERT"),
+		CAPath:     os.Getenv("VAULT_CAPATH"),
+		ClientCert: os.Getenv("VAULT_CLIENT_CERT"),
+		ClientKey:  os.Getenv("VAULT_CLIENT_KEY"),
+		ServerName: os.Getenv("VAULT_TLS_SERVER_NAME"),
+		Insecure:   envBool("VAULT_SKIP_VERIFY", false),
+	})
+	if err != nil {
+		return nil, err
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if b, err := ioutil.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+				token = strings.TrimSpace(string(b))
+			}
+		}
+	}
+	if token != "" {
+		c.Headers.Set("X-Vault-Token", token)
+	}
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		c.Headers.Set("X-Vault-Namespace", ns)
+	}
+	return c, nil
+}
+
+// NewNomadClient returns a client for the local Nomad agent configured from
+// the same environment variables as the nomad CLI; the region and namespace
+// are passed as query parameters on every request
+func NewNomadClient() (*HTTPClient, error) {
+	addr := os.Getenv("NOMAD_ADDR")
+	if addr == "" {
+		addr = "http://127.0.0.1:4646"
+	}
+	c, err := NewHTTPClient(addr, false, TLSOptions{
+		CACert:     os.Getenv("NOMAD_CACERT"),
+		CAPath:     os.Getenv("NOMAD_CAPATH"),
+		ClientCert: os.Getenv("NOMAD_CLIENT_CERT"),
+		ClientKey:  os.Getenv("NOMAD_CLIENT_KEY"),
+		ServerName: os.Getenv("NOMAD_TLS_SERVER_NAME"),
+		Insecure:   envBool("NOMAD_SKIP_VERIFY", false),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("NOMAD_TOKEN"); token != "" {
+		c.Headers.Set("X-Nomad-Token", token)
+	}
+	if region := os.Getenv("NOMAD_REGION"); region != "" {
+		c.Query.Set("region", region)
+	}
+	if ns := os.Getenv("NOMAD_NAMESPACE"); ns != "" {
+		c.Query.Set("namespace", ns)
+	}
+	return c, nil
+}
@@ -0,0 +1,297 @@
+package command
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// consulStandIn serves canned responses for the Consul API paths rover
+// fetches and requires the ACL token on every request
+func consulStandIn(t *testing.T, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Consul-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Permission denied"))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/agent/self":
+			w.Write([]byte(`{"Config":{"NodeName":"node-1"},"DebugConfig":{"ACLMasterToken":"hidden"}}`))
+		case "/v1/agent/members":
+			w.Write([]byte(`[{"Name":"node-1","Status":1}]`))
+		case "/debug/pprof/goroutine":
+			if r.URL.Query().Get("debug") != "2" {
+				t.Errorf("expected debug=2, got %q", r.URL.RawQuery)
+			}
+			w.Write([]byte("goroutine 1 [running]:\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func TestNewConsulClient(t *testing.T) {
+	srv := httptest.NewTLSServer(consulStandIn(t, "s3cr3t"))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "rover-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, pemBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range map[string]string{
+		"CONSUL_HTTP_ADDR":  strings.TrimPrefix(srv.URL, "https://"),
+		"CONSUL_HTTP_SSL":   "true",
+		"CONSUL_HTTP_TOKEN": "s3cr3t",
+		"CONSUL_CACERT":     ca,
+	} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+	c, err := NewConsulClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := c.Fetch(context.Background(), "/v1/agent/members", &b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "node-1") {
+		t.Fatalf("unexpected response %q", b.String())
+	}
+
+	os.Setenv("CONSUL_HTTP_TOKEN", "wrong")
+	c, err = NewConsulClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Fetch(context.Background(), "/v1/agent/members", &b)
+	if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a 403 APIError, got %v", err)
+	}
+}
+
+func TestExecuteAPITasks(t *testing.T) {
+	srv := httptest.NewServer(consulStandIn(t, "s3cr3t"))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "rover-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	run, err := OpenRun(dir, RunReuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer run.Close()
+	h := run.HostDir()
+	if err := os.MkdirAll(filepath.Join(h, "consul"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewHTTPClient(srv.URL, false, TLSOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Headers.Set("X-Consul-Token", "s3cr3t")
+	set := NewTaskSet("consul",
+		Task{Output: "consul_agent_self", API: "/v1/agent/self", Ext: "json"},
+		Task{Output: "consul_agent_members", API: "/v1/agent/members", Ext: "json"},
+		Task{Output: "consul_goroutine", API: "/debug/pprof/goroutine?debug=2"},
+		Task{Output: "consul_missing", API: "/v1/missing", Ext: "json"},
+	)
+	env := TaskEnv{Run: run, OS: Linux, Parallelism: 2, API: client}
+	results := ExecuteTasks(context.Background(), hclog.NewNullLogger(), set, env)
+	want := []string{
+		"consul/consul_agent_self.json ok",
+		"consul/consul_agent_members.json ok",
+		"consul/consul_goroutine.txt ok",
+		"consul/consul_missing.json failed",
+	}
+	if len(results) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(results))
+	}
+	for i, r := range results {
+		if got := r.OutputFile() + " " + r.Status; got != want[i] {
+			t.Fatalf("expected %q, got %q", want[i], got)
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(h, "consul", "consul_agent_self.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "\n  \"Config\": {\n    \"NodeName\": \"node-1\"") {
+		t.Fatalf("expected indented JSON, got %q", b)
+	}
+	b, err = ioutil.ReadFile(filepath.Join(h, "consul", "consul_missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "returned 404") {
+		t.Fatalf("expected the error in the output file, got %q", b)
+	}
+
+	// API tasks are skipped without a client
+	env.API = nil
+	results = ExecuteTasks(context.Background(), hclog.NewNullLogger(), set, env)
+	for _, r := range results {
+		if r.Status != StatusSkipped {
+			t.Fatalf("expected %s to be skipped without an API client, got %s", r.Output, r.Status)
+		}
+	}
+}
+
+func TestVaultAPITasks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" || r.Header.Get("X-Vault-Namespace") != "team-a" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/sys/health":
+			if r.URL.Query().Get("sealedcode") != "200" {
+				t.Errorf("expected sealed servers to report 200, got %q", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"sealed":true,"version":"1.4.3"}`))
+		case "/v1/sys/mounts":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "rover-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	run, err := OpenRun(dir, RunReuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer run.Close()
+	h := run.HostDir()
+	if err := os.MkdirAll(filepath.Join(h, "vault"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range map[string]string{
+		"VAULT_ADDR":      srv.URL,
+		"VAULT_TOKEN":     "s.token",
+		"VAULT_NAMESPACE": "team-a",
+	} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+	client, err := NewVaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := vaultServerVersion(context.Background(), client)
+	if err != nil || v != "1.4.3" {
+		t.Fatalf("expected server version 1.4.3, got %q with error %v", v, err)
+	}
+
+	set := NewTaskSet("vault",
+		Task{Output: "vault_sys_health", API: vaultHealthPath, Ext: "json"},
+		Task{Output: "vault_sys_mounts", API: "/v1/sys/mounts", Ext: "json"},
+	)
+	results := ExecuteTasks(context.Background(), hclog.NewNullLogger(), set, TaskEnv{Run: run, OS: Linux, API: client})
+	if results[0].Status != StatusOK || results[1].Status != StatusDenied {
+		t.Fatalf("expected ok and denied, got %s and %s", results[0].Status, results[1].Status)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(h, "vault", "vault_sys_mounts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "permission denied") {
+		t.Fatalf("expected the denial in the output file, got %q", b)
+	}
+}
+
+func TestNomadAPITasks(t *testing.T) {
+	allocs := `[` + strings.Repeat(`{"ID":"0123456789abcdef"},`, 100) + `{}]`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.Header.Get("X-Nomad-Token") != "secret-id" || q.Get("region") != "eu" || q.Get("namespace") != "batch" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/agent/pprof/goroutine":
+			if q.Get("debug") != "2" {
+				t.Errorf("expected debug=2 alongside the client parameters, got %q", r.URL.RawQuery)
+			}
+			w.Write([]byte("goroutine 1 [running]:\n"))
+		case "/v1/allocations":
+			w.Write([]byte(allocs))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "rover-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	run, err := OpenRun(dir, RunReuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer run.Close()
+	h := run.HostDir()
+	if err := os.MkdirAll(filepath.Join(h, "nomad"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range map[string]string{
+		"NOMAD_ADDR":      srv.URL,
+		"NOMAD_TOKEN":     "secret-id",
+		"NOMAD_REGION":    "eu",
+		"NOMAD_NAMESPACE": "batch",
+	} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+	client, err := NewNomadClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := NewTaskSet("nomad",
+		Task{Output: "nomad_goroutine", API: "/v1/agent/pprof/goroutine?debug=2"},
+		Task{Output: "nomad_allocations", API: "/v1/allocations", Ext: "json", MaxBytes: 256},
+		Task{Output: "nomad_allocations_full", API: "/v1/allocations", Ext: "json"},
+	)
+	results := ExecuteTasks(context.Background(), hclog.NewNullLogger(), set, TaskEnv{Run: run, OS: Linux, API: client})
+	for _, r := range results {
+		if r.Status != StatusOK {
+			t.Fatalf("expected %s to succeed, got %s", r.Output, r.Status)
+		}
+	}
+	if results[0].Truncated || !results[1].Truncated || results[2].Truncated {
+		t.Fatal("expected only the capped allocations listing to be truncated")
+	}
+	b, err := ioutil.ReadFile(filepath.Join(h, "nomad", "nomad_allocations.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), allocs[:256]+"\n[rover] response truncated after 256 bytes") {
+		t.Fatalf("expected the response to be cut off at 256 bytes, got %q", b)
+	}
+}
@@ -1,36 +1,43 @@
 // Package command for archive
-// Archive compresses command output into a zip file
+// Archive compresses command output into a zip, tar.gz or tar.zst file
 package command
 
 import (
-	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/briandowns/spinner"
-	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
-	"github.com/pierrre/archivefile/zip"
 )
 
 const (
-	// Default to storing archive files in the PWD vs. TMPDIR
-	archivePathDefault = "./"
-	archivePathDescr   = "Archive file path"
+	archivePathDescr = "Archive file path"
 )
 
-// ArchiveCommand describes common zip file fields
+// ArchiveCommand describes common archive file fields
 type ArchiveCommand struct {
-	ArchivePath string
-	HostName    string
-	OS          string
-	KeepData    bool
-	TargetFile  string
-	UI          cli.Ui
+	ArchivePath     string
+	Format          string
+	EncryptTo       stringsFlag
+	Recipients      stringsFlag
+	RecipientsFiles stringsFlag
+	SignKey         string
+	OutputDir       string
+	HostName        string
+	OS              string
+	KeepData        bool
+	OutFile         string
+	RequireManifest bool
+	TargetFile      string
+	RunContext      *RunContext
+	UI              cli.Ui
 }
 
 // Help output
@@ -38,11 +45,39 @@ func (c *ArchiveCommand) Help() string {
 	helpText := `
 Usage: rover archive
 	Archive the command output directory into a zip file with this
-	filename format: rover-<hostname>-<timestamp>.zip
+	filename format: rover-<hostname>-<timestamp>.zip, or into a gzip or
+	zstd compressed tar file ending in .tar.gz or .tar.zst with -format
+
+	The data archived is the <hostname> directory in the output directory
+	given by -output-dir or $ROVER_OUTPUT_DIR, or else in the latest run
+	under ~/.local/state/rover, in which case the archive is written to
+	~/.local/state/rover and the emptied run is removed
+
+	With -encrypt-to, the archive is encrypted with OpenPGP to each key as
+	it is written and named rover-<hostname>-<timestamp>.zip.gpg; with
+	-recipient or -recipients-file it is encrypted with age instead and
+	named rover-<hostname>-<timestamp>.zip.age. The unencrypted archive is
+	never written, and the recipients are listed in an unencrypted
+	<archive>.recipients.json file next to it. Use rover decrypt to read it
+
+	The SHA-256 hash of every file is recorded in the manifest, and the
+	checksum of the archive is written to <archive>.sha256 next to it. With
+	-sign-key, that checksum is also signed with an ed25519 key into
+	<archive>.sig. Use rover verify to check them
 
 General Options:
+  -format=<format>	Archive format: zip, tar.gz or tar.zst [default: zip]
+  -encrypt-to=<keyfile>	OpenPGP public key file of a recipient; repeat for several
+  -recipient=<age1...>	age recipient; repeat for several
+  -recipients-file=<file>	File of age recipients, one per line; repeatable
+  -sign-key=<keyfile>	PEM ed25519 private key to sign the archive checksum with
   -keep-data	Whether to keep the archive source directory [default: false]
-  -path		Path where archive file is written [default: "."]
+  -output-dir=<dir>	Directory holding the <hostname> data directory [default: $ROVER_OUTPUT_DIR or the latest run]
+  -log-level=<level>	Log level: trace, debug, info, warn or error [default: $ROVER_LOG_LEVEL or info]
+  -log-json		Write rover.log as JSON [default: true when $ROVER_LOG_FORMAT is json]
+  -log-mirror		Print logged warnings to the terminal [default: true]
+  -path		Path where archive file is written [default: the output directory]
+  -require-manifest	Refuse to archive data without a manifest.json [default: false]
 `
 
 	return strings.TrimSpace(helpText)
@@ -50,65 +85,88 @@ General Options:
 
 // Run command
 func (c *ArchiveCommand) Run(args []string) int {
-	h, err := GetHostName()
-	if err != nil {
-		out := fmt.Sprintf("Cannot get system hostname with error %v", err)
-		c.UI.Output(out)
-		return 1
+	run := c.RunContext
+	if run == nil {
+		run = NewRunContext(c.UI)
 	}
-	c.HostName = h
-	// Internal logging
-	l := "rover.log"
-	p := filepath.Join(fmt.Sprintf("%s", c.HostName), "log")
-	if err := os.MkdirAll(p, os.ModePerm); err != nil {
-		out := fmt.Sprintf("Cannot create log directory %s.", p)
-		c.UI.Error(out)
+	cmdFlags := flag.NewFlagSet("archive", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.StringVar(&c.ArchivePath, "path", "", archivePathDescr)
+	cmdFlags.BoolVar(&c.KeepData, "keep-data", false, "Remove the zipfile source directory?")
+	cmdFlags.BoolVar(&c.RequireManifest, "require-manifest", false, "Refuse to archive data without a manifest")
+	cmdFlags.StringVar(&c.Format, "format", FormatZip, formatDescr)
+	cmdFlags.Var(&c.EncryptTo, "encrypt-to", encryptToDescr)
+	cmdFlags.Var(&c.Recipients, "recipient", recipientDescr)
+	cmdFlags.Var(&c.RecipientsFiles, "recipients-file", recipientsFileDescr)
+	cmdFlags.StringVar(&c.SignKey, "sign-key", "", signKeyDescr)
+	cmdFlags.StringVar(&c.OutputDir, "output-dir", "", outputDirDescr)
+	run.Flags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
-	f, err := os.OpenFile(filepath.Join(p, l), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		out := fmt.Sprintf("Failed to open log file %s with error: %v", f, err)
-		c.UI.Error(out)
+	if err := run.Open(c.OutputDir, RunExisting); err != nil {
+		c.UI.Error(err.Error())
 		return 1
 	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	logger := hclog.New(&hclog.LoggerOptions{Name: "rover", Level: hclog.LevelFromString("INFO"), Output: w})
+	defer run.Close()
+	c.HostName = run.HostName
+	c.OS = run.OS
+	logger := run.Logger
 
 	logger.Info("archive", "hello from", c.HostName)
 	logger.Info("archive", "detected OS", c.OS)
 
-	cmdFlags := flag.NewFlagSet("archive", flag.ContinueOnError)
-	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
-	cmdFlags.StringVar(&c.ArchivePath, "path", archivePathDefault, archivePathDescr)
-	cmdFlags.BoolVar(&c.KeepData, "keep-data", false, "Remove the zipfile source directory?")
-	if err := cmdFlags.Parse(args); err != nil {
+	// Read the keys before anything is archived so that a bad key file
+	// leaves the data where it is
+	enc, err := newArchiveEncryption(c.EncryptTo, c.Recipients, c.RecipientsFiles)
+	if err != nil {
+		logger.Error("archive", "cannot read encryption keys with error", err.Error())
+		c.UI.Error(err.Error())
 		return 1
 	}
-	c.TargetFile = "rover-%s-%s.zip"
+	var signer ed25519.PrivateKey
+	if c.SignKey != "" {
+		if signer, err = readSigningKey(c.SignKey); err != nil {
+			logger.Error("archive", "cannot read signing key with error", err.Error())
+			c.UI.Error(err.Error())
+			return 1
+		}
+	}
+	if !containsString(archiveFormats, c.Format) {
+		out := fmt.Sprintf("Unknown archive format %q; use one of %s", c.Format, strings.Join(archiveFormats, ", "))
+		c.UI.Error(out)
+		return 1
+	}
+	c.TargetFile = "rover-%s-%s." + c.Format
 	t := time.Now().Format("20060102150405")
 	archiveFileName := fmt.Sprintf(c.TargetFile, c.HostName, t)
+	if enc != nil {
+		archiveFileName += enc.Suffix
+	}
 
-	defer func() {
-		// Remove the source directory after zip file created
-		if !c.KeepData {
-			err := os.RemoveAll(c.HostName)
-			if err != nil {
-				logger.Error("cannot remove source directory with error", err.Error())
-				out := fmt.Sprintf("Cannot remove source directory with error: %v", err)
-				c.UI.Error(out)
-				os.Exit(1)
-			}
-		}
-		logger.Info("archive", "preserved source directory in", c.HostName)
-	}()
-
-	_, err = os.Stat(c.HostName)
+	hostDir := run.HostDir()
+	_, err = os.Stat(hostDir)
 	if os.IsNotExist(err) {
-		out := fmt.Sprintf("Cannot archive nonexistent directory '%s'; please use rover commands to generate data first.", c.HostName)
+		out := fmt.Sprintf("Cannot archive nonexistent directory '%s'; please use rover commands to generate data first.", hostDir)
 		c.UI.Error(out)
 		return 1
 	}
+	// The manifest is what tells readers of the bundle which tasks ran, so
+	// its absence is worth a warning, or an error when it is required
+	if _, err := os.Stat(filepath.Join(hostDir, ManifestFile)); err != nil {
+		if c.RequireManifest {
+			out := fmt.Sprintf("Cannot archive directory '%s' without %s; please use rover commands to generate data first.", hostDir, ManifestFile)
+			logger.Error("archive", "refusing to archive without manifest", ManifestFile)
+			c.UI.Error(out)
+			return 1
+		}
+		logger.Info("archive", "archiving without manifest", ManifestFile)
+		c.UI.Warn(fmt.Sprintf("No %s found in '%s'; the archive will not record which tasks ran.", ManifestFile, hostDir))
+	}
+
+	if c.ArchivePath == "" {
+		c.ArchivePath = run.ArchiveDir()
+	}
 	outPath := filepath.Join(c.ArchivePath, archiveFileName)
 
 	// Shout out to Ye Olde School BSD spinner!
@@ -122,20 +180,91 @@ func (c *ArchiveCommand) Run(args []string) int {
 	s.Suffix = " Archiving data, please wait ..."
 	s.Start()
 
-	err = zip.ArchiveFile(fmt.Sprintf("%s", c.HostName), outPath, nil)
+	if err := run.Flush(); err != nil {
+		logger.Warn("archive", "cannot flush log with error", err.Error())
+	}
+	err = createArchive(hostDir, outPath, c.Format, enc, signer)
+	if err == nil && enc != nil {
+		logger.Info("archive", "encrypted to", enc.String())
+	}
 	if err != nil {
 		logger.Error("cannot archive data with error", err.Error())
 		out := fmt.Sprintf("Cannot archive data with error: %v", err)
 		c.UI.Error(out)
 		return 1
 	}
+	c.OutFile = outPath
 	s.FinalMSG = fmt.Sprintf("Archived data in %s\n", outPath)
+	if enc != nil {
+		s.FinalMSG = fmt.Sprintf("Archived data in %s, encrypted to %s\n", outPath, enc)
+	}
 	s.Stop()
 
+	// Remove the source directory only once the archive is written, so a
+	// failed archive never loses the data
+	if c.KeepData {
+		logger.Info("archive", "preserved source directory in", hostDir)
+		return 0
+	}
+	// rover.log is in the source directory, so it is closed first rather
+	// than written to after it is removed
+	logger.Info("archive", "removing source directory", hostDir)
+	if err := run.CloseLog(); err != nil {
+		logger.Warn("archive", "cannot close log with error", err.Error())
+	}
+	if err := os.RemoveAll(hostDir); err != nil {
+		logger.Error("archive", "cannot remove source directory with error", err.Error())
+		out := fmt.Sprintf("Cannot remove source directory with error: %v", err)
+		c.UI.Error(out)
+		return 1
+	}
 	return 0
 }
 
+// createArchive writes dir to outPath in format, through an encrypting
+// writer when enc is not nil, in which case the recipients are listed
+// beside it. The checksum of what was written goes beside it too, signed
+// with key when it is not nil; what was written is removed if anything
+// fails
+func createArchive(dir, outPath, format string, enc *archiveEncryption, key ed25519.PrivateKey) error {
+	perm := os.FileMode(0644)
+	if enc != nil {
+		perm = 0600
+	}
+	f, err := os.OpenFile(outPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	fw := io.MultiWriter(f, h)
+	if enc == nil {
+		err = writeArchive(dir, fw, format)
+	} else {
+		var w io.WriteCloser
+		if w, err = enc.wrap(fw, strings.TrimSuffix(filepath.Base(outPath), enc.Suffix)); err == nil {
+			if err = writeArchive(dir, w, format); err == nil {
+				err = w.Close()
+			}
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && enc != nil {
+		err = enc.writeRecipients(outPath)
+	}
+	if err == nil {
+		err = writeIntegrity(outPath, h.Sum(nil), key)
+	}
+	if err != nil {
+		for _, suffix := range []string{"", RecipientsSuffix, ChecksumSuffix, SignatureSuffix} {
+			os.Remove(outPath + suffix)
+		}
+	}
+	return err
+}
+
 // Synopsis output
 func (c *ArchiveCommand) Synopsis() string {
-	return "Archive rover data into zip file"
+	return "Archive rover data into a zip, tar.gz or tar.zst file"
 }
@@ -1,16 +1,26 @@
 package command
 
 import (
-	"github.com/mitchellh/cli"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/mitchellh/cli"
 )
 
 func TestArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rover-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
 	ui := new(cli.MockUi)
 	testHost := "rover-test"
 	testFile := "rover-test-%s-%s.zip"
 
-	args := []string{}
+	args := []string{"-output-dir", dir}
 
 	c := &ArchiveCommand{
 		UI:         ui,
@@ -21,4 +31,48 @@ func TestArchive(t *testing.T) {
 	if code := c.Run(args); code != 0 {
 		t.Fatalf("archive failed: %d\n\n%s", code, ui.ErrorWriter.String())
 	}
+	// The archive is written to the output directory rather than the
+	// working directory, and the lock is released
+	if filepath.Dir(c.OutFile) != dir || !strings.HasSuffix(c.OutFile, ".zip") {
+		t.Fatalf("expected an archive in %s, got %s", dir, c.OutFile)
+	}
+	if _, err := os.Stat(filepath.Join(dir, RunLockFile)); !os.IsNotExist(err) {
+		t.Fatalf("expected the lock to be released, got %v", err)
+	}
+}
+
+func TestArchiveClosesLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rover-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ui := new(cli.MockUi)
+	run := NewRunContext(ui)
+	if err := run.Open(dir, RunReuse); err != nil {
+		t.Fatal(err)
+	}
+	logger := run.Logger
+	c := &ArchiveCommand{RunContext: run, UI: ui}
+	if code := c.Run([]string{"-output-dir", dir}); code != 0 {
+		t.Fatalf("archive failed: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	// rover.log went with the data directory, and what is logged after it
+	// is only printed
+	if _, err := os.Stat(run.HostDir()); !os.IsNotExist(err) {
+		t.Fatalf("expected the data directory to be removed, got %v", err)
+	}
+	if run.log != nil {
+		t.Fatal("expected rover.log to be closed before the data directory was removed")
+	}
+	logger.Warn("archive", "after removal", run.HostDir())
+	if err := run.Close(); err != nil {
+		t.Fatalf("expected the run to close cleanly, got %v", err)
+	}
+	if _, err := os.Stat(run.HostDir()); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing written to the data directory, got %v", err)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "after removal") {
+		t.Errorf("expected the warning to be printed, got %q", ui.ErrorWriter.String())
+	}
 }
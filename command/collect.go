@@ -0,0 +1,234 @@
+// Package command for collect
+// Collect executes the task sets for arbitrary modules, including modules
+// which only exist in task configuration files
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/hashicorp/go-version"
+	"github.com/mitchellh/cli"
+)
+
+// CollectCommand describes collect related fields
+type CollectCommand struct {
+	Timeout     time.Duration
+	Parallelism int
+	Profile     string
+	NoRedact    bool
+	Strict      bool
+	ConfigPath  string
+	OutputDir   string
+	HostName    string
+	OS          string
+	RunContext  *RunContext
+	UI          cli.Ui
+}
+
+// Help output
+func (c *CollectCommand) Help() string {
+	helpText := `
+Usage: rover collect [options] <module> [<module> ...]
+	Execute the tasks registered for one or more modules and store output
+	in text files; modules can be built in or defined in task configuration
+	files
+
+General Options:
+  -config=<path>	Task configuration file or directory [default: $ROVER_CONFIG_DIR]
+  -output-dir=<dir>	Directory holding the <hostname> data directory [default: $ROVER_OUTPUT_DIR or the latest run]
+  -log-level=<level>	Log level: trace, debug, info, warn or error [default: $ROVER_LOG_LEVEL or info]
+  -log-json		Write rover.log as JSON [default: true when $ROVER_LOG_FORMAT is json]
+  -log-mirror		Print logged warnings to the terminal [default: true]
+  -timeout=<duration>	Default deadline for each task [default: 2m]
+  -parallelism=<n>	Maximum number of tasks to run at once [default: 4]
+  -profile=<name>	Collection profile: minimal, standard, deep or custom [default: standard]
+  -no-redact		Do not redact secrets from output; internal use only [default: false]
+  -strict		Exit non-zero when any task fails, times out or is cancelled [default: false]
+`
+
+	return strings.TrimSpace(helpText)
+}
+
+// Run command
+func (c *CollectCommand) Run(args []string) int {
+	run := c.RunContext
+	if run == nil {
+		run = NewRunContext(c.UI)
+	}
+	c.OS = run.OS
+	cmdFlags := flag.NewFlagSet("collect", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.StringVar(&c.ConfigPath, "config", "", configPathDescr)
+	cmdFlags.DurationVar(&c.Timeout, "timeout", DefaultTaskTimeout, timeoutDescr)
+	cmdFlags.IntVar(&c.Parallelism, "parallelism", DefaultParallelism, parallelismDescr)
+	cmdFlags.StringVar(&c.Profile, "profile", DefaultProfile, profileDescr)
+	cmdFlags.BoolVar(&c.NoRedact, "no-redact", false, noRedactDescr)
+	cmdFlags.BoolVar(&c.Strict, "strict", false, strictDescr)
+	cmdFlags.StringVar(&c.OutputDir, "output-dir", "", outputDirDescr)
+	run.Flags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+	if err := run.Open(c.OutputDir, RunReuse); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	defer run.Close()
+	c.HostName = run.HostName
+	logger := run.Logger
+	logger.Info("collect", "hello from the Collect module at", c.HostName)
+	logger.Info("collect", "our detected OS", c.OS)
+
+	if err := LoadConfig(ConfigPath(c.ConfigPath), Collectors); err != nil {
+		logger.Error("collect", "cannot load configuration with error", err.Error())
+		c.UI.Error(err.Error())
+		return 1
+	}
+	profile, err := Collectors.Profile(c.Profile)
+	if err != nil {
+		logger.Error("collect", "cannot find profile with error", err.Error())
+		c.UI.Error(err.Error())
+		return 1
+	}
+	redactor := commandRedactor(logger, c.UI, c.NoRedact)
+	modules := cmdFlags.Args()
+	if len(modules) == 0 {
+		out := fmt.Sprintf("%s\n\nAvailable modules: %s", c.Help(), strings.Join(Collectors.Names(), ", "))
+		c.UI.Error(out)
+		return 1
+	}
+
+	// Unknown modules are a mistake on the command line, so they are
+	// refused before anything runs
+	for _, m := range modules {
+		if _, err := Collectors.Lookup(m); err != nil {
+			logger.Error("collect", "cannot find task set with error", err.Error())
+			c.UI.Error(err.Error())
+			return 1
+		}
+	}
+
+	ctx, cancel := InterruptContext()
+	defer cancel()
+	summary := &Summary{}
+	for _, m := range modules {
+		tasks, _ := Collectors.Lookup(m)
+		outPath := filepath.Join(run.HostDir(), m)
+		if err := os.MkdirAll(outPath, os.ModePerm); err != nil {
+			logger.Error("collect", "cannot create directory", outPath, "error", err.Error())
+			out := fmt.Sprintf("Cannot create directory %s with error %v", outPath, err)
+			c.UI.Error(out)
+			summary.Fail(m)
+			continue
+		}
+		// Tasks can refer to the PID of a running process named for the module
+		pid, err := run.CheckProc(m)
+		if err != nil || pid == "" {
+			logger.Info("collect", "no process detected for module", m)
+		}
+
+		// Shout out to Ye Olde School BSD spinner!
+		roverSpinnerSet := []string{"/", "|", "\\", "-", "|", "\\", "-"}
+		s := spinner.New(roverSpinnerSet, 174*time.Millisecond)
+		s.Writer = os.Stderr
+		err = s.Color("fgHiCyan")
+		if err != nil {
+			logger.Warn("collect", "weird-error", err.Error())
+		}
+		s.Suffix = fmt.Sprintf(" Gathering %s data ...", m)
+		s.FinalMSG = fmt.Sprintf("Gathered %s data\n", m)
+		s.Start()
+		api, err := moduleAPI(m)
+		if err != nil {
+			logger.Warn("collect", "cannot configure API client, skipping API tasks", err.Error())
+		}
+		results := ExecuteTasks(ctx, logger, tasks, TaskEnv{
+			Run:         run,
+			OS:          c.OS,
+			Version:     moduleVersion(ctx, run, m, api),
+			Vars:        moduleVars(pid),
+			Timeout:     c.Timeout,
+			Parallelism: c.Parallelism,
+			Profile:     profile,
+			Redactor:    redactor,
+			API:         api,
+		})
+		if err := UpdateManifest(run.HostDir(), c.OS, profile.Name, results); err != nil {
+			logger.Warn("collect", "cannot update manifest with error", err.Error())
+		}
+		s.Stop()
+		summary.Add(m, results)
+	}
+
+	return summary.Report(c.UI, c.Strict)
+}
+
+// Synopsis output
+func (c *CollectCommand) Synopsis() string {
+	return "Execute tasks for any registered module and store output"
+}
+
+// moduleVars returns the task placeholder values for a module, currently
+// the PID of its running process; without a process there is no {pid}, so
+// tasks which need it are skipped
+func moduleVars(pid string) map[string]string {
+	vars := map[string]string{}
+	if pid != "" {
+		vars["pid"] = pid
+	}
+	return vars
+}
+
+// moduleAPI returns the API client for module configured from the
+// environment, or nil when the module has no API
+func moduleAPI(module string) (APIClient, error) {
+	switch module {
+	case Consul:
+		c, err := NewConsulClient()
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	case Nomad:
+		c, err := NewNomadClient()
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	case Vault:
+		c, err := NewVaultClient()
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return nil, nil
+}
+
+// moduleVersion returns the version of the binary for module; when the
+// binary is missing it falls back to the version a Vault server reports
+// through its API, so version specific tasks still resolve on hosts where
+// only the server is installed
+func moduleVersion(ctx context.Context, run *RunContext, module string, api APIClient) string {
+	logger := run.logger()
+	v, err := run.CheckHashiVersion(module)
+	if err != nil {
+		logger.Warn("version", "cannot determine binary version with error", err.Error())
+	}
+	if _, err := version.NewVersion(v); err == nil || module != Vault || api == nil {
+		return v
+	}
+	logger.Info("version", "cannot determine binary version, asking the server", module)
+	v, err = vaultServerVersion(ctx, api)
+	if err != nil {
+		logger.Warn("version", "cannot determine server version with error", err.Error())
+	}
+	return v
+}
@@ -0,0 +1,614 @@
+// Package command for collectors
+// Collectors describe the probes rover runs for a module as data so that
+// individual tasks can be added, removed or overridden without new code
+package command
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-version"
+)
+
+// Task describes a single probe: one command whose stdout + stderr, one
+// file whose contents, or one API response, is stored in
+// <hostname>/<Module>/<Output>.<Ext>
+type Task struct {
+	// Module is the output subdirectory, e.g. "system" or "consul"
+	Module string
+	// Output is the output filename without extension
+	Output string
+	// Command and Args are executed as-is after placeholder expansion;
+	// placeholders take the form {name}, e.g. {pid}
+	Command string
+	Args    []string
+	// File is copied instead of running a command when set
+	File string
+	// API is a request path fetched with the module's API client instead
+	// of running a command when set, e.g. /v1/agent/self
+	API string
+	// Ext is the output file extension for API tasks, e.g. json; empty
+	// means txt as for every other task
+	Ext string
+	// MaxBytes caps the size of an API task's response; zero means no cap
+	MaxBytes int64
+	// Timeout is the maximum run time for the task; zero uses the default
+	Timeout time.Duration
+	// OS limits the task to these runtime.GOOS values; empty means all
+	OS []string
+	// Privileged tasks need root (or sudo) for complete output
+	Privileged bool
+	// Sampling tasks measure the system over an interval, e.g. vmstat 1 10,
+	// and never run at the same time as other sampling tasks
+	Sampling bool
+	// Tags group tasks for profiles, e.g. "slow" or "deep"
+	Tags []string
+	// IfExists and IfMissing take an absolute path or a command name
+	// looked up in PATH and make the task conditional on its presence
+	IfExists  string
+	IfMissing string
+	// Version is a go-version constraint such as "> 0.9.2" checked
+	// against the product version detected for the module
+	Version string
+}
+
+const (
+	// DefaultTaskTimeout is the deadline for tasks which do not set their
+	// own timeout when the command does not override it with -timeout
+	DefaultTaskTimeout = 2 * time.Minute
+
+	// DefaultParallelism is the number of tasks run at once when the
+	// command does not override it with -parallelism
+	DefaultParallelism = 4
+
+	timeoutDescr     = "Default deadline for each task"
+	parallelismDescr = "Maximum number of tasks to run at once"
+)
+
+// TaskEnv carries the runtime facts that tasks are resolved against
+type TaskEnv struct {
+	// Run is the open run whose data directory tasks write to, and whose
+	// runner, file system and logger they use
+	Run     *RunContext
+	OS      string
+	Version string
+	Vars    map[string]string
+	// Timeout is the default deadline for tasks without their own
+	Timeout time.Duration
+	// Parallelism is the number of tasks run at once; values below 1 run
+	// tasks one at a time
+	Parallelism int
+	// Profile selects which tasks run; nil behaves as the standard profile
+	Profile *Profile
+	// Redactor scrubs secrets from every output file; nil disables it
+	Redactor *Redactor
+	// API fetches the responses for API tasks; nil skips them
+	API APIClient
+}
+
+// Argv returns the full command line for a task
+func (t Task) Argv() []string {
+	if t.File != "" {
+		return []string{"copy", t.File}
+	}
+	if t.API != "" {
+		return []string{"GET", t.API}
+	}
+	return append([]string{t.Command}, t.Args...)
+}
+
+// OutputExt returns the output file extension for the task
+func (t Task) OutputExt() string {
+	if t.API == "" || t.Ext == "" {
+		return "txt"
+	}
+	return t.Ext
+}
+
+// OutputFile returns the path of the task's output file relative to the
+// host directory
+func (t Task) OutputFile() string {
+	return filepath.Join(t.Module, t.Output+"."+t.OutputExt())
+}
+
+// Run executes the task with DumpContext, copies its file with DumpFile or
+// fetches its API path with DumpAPI, redacts the output and reports the
+// outcome; the task timeout falls back to the env default, then
+// DefaultTaskTimeout
+func (t Task) Run(ctx context.Context, env TaskEnv) TaskResult {
+	r := TaskResult{Module: t.Module, Output: t.Output, File: t.OutputFile(), Argv: t.Argv(), Start: time.Now()}
+	var err error
+	if t.File != "" {
+		if _, err := env.Run.fs().Stat(t.File); err != nil {
+			r.Status = StatusMissing
+		}
+		r.ExitStatus, err = env.Run.DumpFile(t.Module, t.Output, t.File)
+	} else {
+		timeout := t.Timeout
+		if timeout == 0 {
+			timeout = env.Timeout
+		}
+		if timeout == 0 {
+			timeout = DefaultTaskTimeout
+		}
+		tctx, cancel := context.WithTimeout(ctx, timeout)
+		if t.API != "" {
+			var truncated bool
+			truncated, err = env.Run.DumpAPI(tctx, timeout, env.API, t.Module, t.Output, t.OutputExt(), t.API, t.MaxBytes)
+			r.Truncated = truncated
+			if err != nil {
+				r.ExitStatus = 1
+				if isForbidden(err) {
+					r.Status = StatusDenied
+				}
+			}
+		} else {
+			if path, err := env.Run.runner().LookPath(t.Command); err == nil {
+				r.Binary = path
+			} else {
+				r.Status = StatusMissing
+			}
+			r.ExitStatus, err = env.Run.DumpContext(tctx, timeout, t.Module, t.Output, t.Command, t.Args...)
+		}
+		switch {
+		case ctx.Err() != nil:
+			r.Status = StatusCancelled
+		case tctx.Err() == context.DeadlineExceeded:
+			r.Status = StatusTimeout
+		}
+		cancel()
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	r.End = time.Now()
+	r.DurationMs = int64(r.End.Sub(r.Start) / time.Millisecond)
+	if r.Status == "" {
+		r.Status = StatusOK
+		if r.ExitStatus != 0 {
+			r.Status = StatusFailed
+		}
+	}
+	if h, err := env.Run.dataDir(); err == nil {
+		out := filepath.Join(h, r.OutputFile())
+		if env.Redactor == nil {
+			r.Unredacted = true
+		} else {
+			r.Redactions = redactOutput(env.Run.logger(), env.Redactor, out)
+		}
+		if fi, err := os.Stat(out); err == nil {
+			r.Bytes = fi.Size()
+		}
+	}
+	return r
+}
+
+// redactOutput redacts an output file in place and logs the number of
+// secrets replaced; output which cannot be redacted is discarded rather
+// than risk shipping secrets
+func redactOutput(logger hclog.Logger, redactor *Redactor, out string) int {
+	n, err := redactor.RedactFile(out)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		logger.Error("redact", "cannot redact output, discarding it", out, "error", err.Error())
+		ioutil.WriteFile(out, []byte("[rover] output discarded because it could not be redacted\n"), 0644)
+		return n
+	}
+	logger.Info("redact", "redacted output", out, "count", n)
+	return n
+}
+
+// Applies reports whether the task should run in env, and if not, why
+func (t Task) Applies(env TaskEnv) (bool, string) {
+	if len(t.OS) > 0 && !containsString(t.OS, env.OS) {
+		return false, fmt.Sprintf("not applicable to %s", env.OS)
+	}
+	if t.API != "" && env.API == nil {
+		return false, fmt.Sprintf("no API client for %s", t.Module)
+	}
+	profile := env.Profile
+	if profile == nil {
+		profile = &Profile{Name: DefaultProfile}
+	}
+	if ok, reason := profile.Allows(t); !ok {
+		return false, reason
+	}
+	if t.IfExists != "" && !env.Run.pathOrCommandExists(t.IfExists) {
+		return false, fmt.Sprintf("%s not present", t.IfExists)
+	}
+	if t.IfMissing != "" && env.Run.pathOrCommandExists(t.IfMissing) {
+		return false, fmt.Sprintf("%s present", t.IfMissing)
+	}
+	if name := t.unresolved(env); name != "" {
+		return false, fmt.Sprintf("no value for {%s}", name)
+	}
+	if t.Version != "" {
+		c, err := version.NewConstraint(t.Version)
+		if err != nil {
+			return false, fmt.Sprintf("invalid version constraint %q", t.Version)
+		}
+		v, err := version.NewVersion(env.Version)
+		if err != nil {
+			return false, fmt.Sprintf("cannot compare unknown version %q", env.Version)
+		}
+		if !c.Check(v) {
+			return false, fmt.Sprintf("version %s does not satisfy %s", env.Version, t.Version)
+		}
+	}
+	return true, ""
+}
+
+// placeholder matches a {name} placeholder in task arguments; the {} of
+// find -exec is not one
+var placeholder = regexp.MustCompile(`\{(\w+)\}`)
+
+// unresolved returns the name of the first placeholder in the task
+// arguments without a value in env.Vars, or "" when there is none
+func (t Task) unresolved(env TaskEnv) string {
+	for _, a := range t.Args {
+		for _, m := range placeholder.FindAllStringSubmatch(a, -1) {
+			if env.Vars[m[1]] == "" {
+				return m[1]
+			}
+		}
+	}
+	return ""
+}
+
+// Expand returns a copy of the task with {name} placeholders in its
+// arguments replaced by values from env.Vars
+func (t Task) Expand(env TaskEnv) Task {
+	args := make([]string, len(t.Args))
+	for i, a := range t.Args {
+		for k, v := range env.Vars {
+			a = strings.Replace(a, "{"+k+"}", v, -1)
+		}
+		args[i] = a
+	}
+	t.Args = args
+	return t
+}
+
+// Collector is implemented by anything that can describe the tasks to
+// execute for a module
+type Collector interface {
+	Name() string
+	Tasks() []Task
+}
+
+// TaskSet is a Collector backed by a mutable list of tasks
+type TaskSet struct {
+	mu    sync.RWMutex
+	name  string
+	tasks []Task
+}
+
+// NewTaskSet returns a TaskSet for module name; tasks with an empty
+// Module are assigned to it
+func NewTaskSet(name string, tasks ...Task) *TaskSet {
+	s := &TaskSet{name: name}
+	for _, t := range tasks {
+		if t.Module == "" {
+			t.Module = name
+		}
+		s.tasks = append(s.tasks, t)
+	}
+	return s
+}
+
+// Name of the module
+func (s *TaskSet) Name() string {
+	return s.name
+}
+
+// Tasks returns a copy of the task list in registration order
+func (s *TaskSet) Tasks() []Task {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tasks := make([]Task, len(s.tasks))
+	copy(tasks, s.tasks)
+	return tasks
+}
+
+// Add appends a task, replacing every existing task with the same output
+// name in place so overrides keep their original position
+func (s *TaskSet) Add(t Task) {
+	if t.Module == "" {
+		t.Module = s.name
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	replaced := false
+	tasks := s.tasks[:0]
+	for _, existing := range s.tasks {
+		if existing.Output != t.Output {
+			tasks = append(tasks, existing)
+			continue
+		}
+		if !replaced {
+			tasks = append(tasks, t)
+			replaced = true
+		}
+	}
+	if !replaced {
+		tasks = append(tasks, t)
+	}
+	s.tasks = tasks
+}
+
+// Remove drops every task with the given output name
+func (s *TaskSet) Remove(output string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tasks := s.tasks[:0]
+	for _, t := range s.tasks {
+		if t.Output != output {
+			tasks = append(tasks, t)
+		}
+	}
+	s.tasks = tasks
+}
+
+// Registry maps module names to their collectors and profile names to
+// their profiles, and holds the redaction rules applied to task output and
+// the rules evaluated by the system analyzer
+type Registry struct {
+	mu          sync.RWMutex
+	collectors  map[string]Collector
+	profiles    map[string]*Profile
+	redactRules []RedactRule
+	rules       []Rule
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]Collector), profiles: make(map[string]*Profile)}
+}
+
+// Collectors is the registry used by the rover commands; the built in
+// modules register themselves here
+var Collectors = NewRegistry()
+
+// Register adds a collector, replacing any collector of the same name
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors[c.Name()] = c
+}
+
+// Lookup returns the collector registered for name
+func (r *Registry) Lookup(name string) (Collector, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.collectors[name]
+	if !ok {
+		return nil, fmt.Errorf("no collector registered for %q", name)
+	}
+	return c, nil
+}
+
+// Names returns the sorted names of all registered collectors
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.collectors))
+	for n := range r.collectors {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddTask adds or overrides a single task in its module's task set,
+// creating the module when it does not exist yet
+func (r *Registry) AddTask(t Task) error {
+	if t.Module == "" {
+		return fmt.Errorf("task %q has no module", t.Output)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.collectors[t.Module]
+	if !ok {
+		r.collectors[t.Module] = NewTaskSet(t.Module, t)
+		return nil
+	}
+	s, ok := c.(*TaskSet)
+	if !ok {
+		return fmt.Errorf("collector %q does not accept additional tasks", t.Module)
+	}
+	s.Add(t)
+	return nil
+}
+
+// hasTask reports whether module already has a task writing output
+func (r *Registry) hasTask(module, output string) bool {
+	c, err := r.Lookup(module)
+	if err != nil {
+		return false
+	}
+	for _, t := range c.Tasks() {
+		if t.Output == output {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveTask removes a single task from a module's task set
+func (r *Registry) RemoveTask(module, output string) error {
+	c, err := r.Lookup(module)
+	if err != nil {
+		return err
+	}
+	s, ok := c.(*TaskSet)
+	if !ok {
+		return fmt.Errorf("collector %q does not allow removing tasks", module)
+	}
+	s.Remove(output)
+	return nil
+}
+
+// ExecuteTasks resolves every task from c against env and runs the ones
+// that apply on up to env.Parallelism workers until ctx is cancelled.
+// Sampling tasks run one after another on a single worker so that their
+// measurements do not skew each other, while the remaining workers run the
+// independent tasks. It returns a result for every task: those which ran in
+// registration order, followed by those which were skipped
+func ExecuteTasks(ctx context.Context, logger hclog.Logger, c Collector, env TaskEnv) []TaskResult {
+	tasks, skipped := resolveTasks(logger, c, env)
+	results := make([]TaskResult, len(tasks))
+	started := make([]bool, len(tasks))
+	run := func(i int) {
+		started[i] = true
+		// A task which panics fails on its own rather than taking the
+		// rest of the collection down with it
+		defer func() {
+			if p := recover(); p != nil {
+				logger.Error("tasks", "task panicked", tasks[i].Output, "error", hclog.Fmt("%v", p))
+				t := tasks[i]
+				results[i] = TaskResult{Module: t.Module, Output: t.Output, File: t.OutputFile(), Argv: t.Argv(),
+					Status: StatusFailed, ExitStatus: 1, Error: fmt.Sprintf("task panicked: %v", p)}
+			}
+		}()
+		results[i] = tasks[i].Run(ctx, env)
+	}
+
+	if env.Parallelism <= 1 {
+		for i, t := range tasks {
+			if ctx.Err() != nil {
+				logger.Warn("tasks", "cancelled before running task", t.Output)
+				break
+			}
+			run(i)
+		}
+		return finishResults(results, started, tasks, skipped)
+	}
+
+	sem := make(chan struct{}, env.Parallelism)
+	acquire := func(t Task) bool {
+		select {
+		case sem <- struct{}{}:
+			if ctx.Err() == nil {
+				return true
+			}
+			<-sem
+		case <-ctx.Done():
+		}
+		logger.Warn("tasks", "cancelled before running task", t.Output)
+		return false
+	}
+	sampling := []int{}
+	independent := []int{}
+	for i, t := range tasks {
+		if t.Sampling {
+			sampling = append(sampling, i)
+		} else {
+			independent = append(independent, i)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, i := range sampling {
+			if !acquire(tasks[i]) {
+				return
+			}
+			run(i)
+			<-sem
+		}
+	}()
+	for _, i := range independent {
+		if !acquire(tasks[i]) {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			run(i)
+			<-sem
+		}(i)
+	}
+	wg.Wait()
+	return finishResults(results, started, tasks, skipped)
+}
+
+// finishResults records tasks which were never started because of
+// cancellation and appends the skipped task results
+func finishResults(results []TaskResult, started []bool, tasks []Task, skipped []TaskResult) []TaskResult {
+	for i, t := range tasks {
+		if !started[i] {
+			results[i] = TaskResult{Module: t.Module, Output: t.Output, File: t.OutputFile(), Argv: t.Argv(), Status: StatusCancelled}
+		}
+	}
+	return append(results, skipped...)
+}
+
+// resolveTasks returns the expanded tasks from c which apply in env in
+// registration order, along with results for the tasks which do not. When
+// several tasks apply for the same output only the first is kept so that
+// concurrent tasks never write to the same file
+func resolveTasks(logger hclog.Logger, c Collector, env TaskEnv) ([]Task, []TaskResult) {
+	tasks := []Task{}
+	skipped := []TaskResult{}
+	skip := func(t Task, reason string) {
+		now := time.Now()
+		skipped = append(skipped, TaskResult{Module: t.Module, Output: t.Output, File: t.OutputFile(), Argv: t.Argv(),
+			Start: now, End: now, Status: StatusSkipped, SkipReason: reason})
+	}
+	claimed := map[string]bool{}
+	for _, t := range c.Tasks() {
+		ok, reason := t.Applies(env)
+		if !ok {
+			logger.Debug("tasks", "skipping task", t.Output, "reason", reason)
+			skip(t, reason)
+			continue
+		}
+		id := t.OutputFile()
+		if claimed[id] {
+			logger.Warn("tasks", "skipping task with duplicate output", id)
+			skip(t, "duplicate output")
+			continue
+		}
+		claimed[id] = true
+		t = t.Expand(env)
+		if t.Privileged && os.Geteuid() != 0 {
+			logger.Info("tasks", "output may be incomplete without root privileges", t.Output)
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, skipped
+}
+
+// containsString reports whether s is in list
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// pathOrCommandExists checks an absolute path on the host or a command in
+// PATH with the file system and runner of the run
+func (r *RunContext) pathOrCommandExists(name string) bool {
+	if filepath.IsAbs(name) {
+		_, err := r.fs().Stat(name)
+		return err == nil
+	}
+	_, err := r.runner().LookPath(name)
+	return err == nil
+}
@@ -0,0 +1,167 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+)
+
+func TestExecuteTasks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rover-tasks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	run, err := OpenRun(dir, RunReuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer run.Close()
+	h := run.HostDir()
+	if err := os.MkdirAll(filepath.Join(h, "test"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sampling tasks hold a lock directory while they run and report when
+	// another sampling task already holds it
+	lock := filepath.Join(dir, "sampling.lock")
+	sample := "mkdir " + lock + " || echo overlap; sleep 0.2; rmdir " + lock
+	set := NewTaskSet("test",
+		Task{Output: "sample_a", Command: "sh", Args: []string{"-c", sample}, Sampling: true},
+		Task{Output: "sample_b", Command: "sh", Args: []string{"-c", sample}, Sampling: true},
+		Task{Output: "sample_c", Command: "sh", Args: []string{"-c", sample}, Sampling: true},
+		Task{Output: "echo", Command: "echo", Args: []string{"{greeting}"}},
+		Task{Output: "echo", Command: "echo", Args: []string{"duplicate"}},
+		Task{Output: "skipped", Command: "echo", OS: []string{"plan9"}},
+	)
+	for i := 0; i < 8; i++ {
+		set.Add(Task{Output: "true_" + string(rune('a'+i)), Command: "true"})
+	}
+
+	env := TaskEnv{Run: run, OS: Linux, Vars: map[string]string{"greeting": "hello"}, Parallelism: 4}
+	results := ExecuteTasks(context.Background(), hclog.NewNullLogger(), set, env)
+	statuses := map[string]int{}
+	for _, r := range results {
+		statuses[r.Status]++
+	}
+	if statuses[StatusOK] != 12 || statuses[StatusSkipped] != 2 || len(results) != 14 {
+		t.Fatalf("expected 12 tasks to run and 2 to be skipped, got %v", statuses)
+	}
+	for _, o := range []string{"sample_a", "sample_b", "sample_c"} {
+		b, err := ioutil.ReadFile(filepath.Join(h, "test", o+".txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(b), "overlap") {
+			t.Fatalf("sampling task %s overlapped another sampling task", o)
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(h, "test", "echo.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello\n" {
+		t.Fatalf("expected output from the first echo task, got %q", b)
+	}
+	if _, err := os.Stat(filepath.Join(h, "test", "skipped.txt")); !os.IsNotExist(err) {
+		t.Fatal("expected task for another OS to be skipped")
+	}
+}
+
+func TestTaskPlaceholders(t *testing.T) {
+	limits := Task{Module: "test", Output: "limits", Command: "cat", Args: []string{"/proc/{pid}/limits"}}
+	find := Task{Module: "test", Output: "bonding", Command: "find", Args: []string{"/proc/net/bonding/", "-exec", "cat", "{}", ";"}}
+	for _, tc := range []struct {
+		task   Task
+		pid    string
+		reason string
+	}{
+		{limits, "4242", ""},
+		// Without a process, nothing runs with /proc//limits
+		{limits, "", "no value for {pid}"},
+		{find, "", ""},
+	} {
+		ok, reason := tc.task.Applies(TaskEnv{OS: Linux, Vars: moduleVars(tc.pid)})
+		if ok != (tc.reason == "") || reason != tc.reason {
+			t.Errorf("%s with pid %q: expected %q, got %v %q", tc.task.Output, tc.pid, tc.reason, ok, reason)
+		}
+	}
+	if args := limits.Expand(TaskEnv{Vars: moduleVars("4242")}).Args; args[0] != "/proc/4242/limits" {
+		t.Errorf("unexpected expansion %v", args)
+	}
+}
+
+func TestExecuteTasksFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rover-failures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	run, err := OpenRun(dir, RunReuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer run.Close()
+	if err := os.MkdirAll(filepath.Join(run.HostDir(), "test"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tasks which cannot run fail on their own and the rest carry on
+	set := NewTaskSet("test",
+		Task{Output: "nowhere", Module: "absent", Command: "echo", Args: []string{"lost"}},
+		Task{Output: "missing", Command: "rover-no-such-command"},
+		Task{Output: "copy", File: filepath.Join(dir, "no-such-file")},
+		Task{Output: "exit", Command: "sh", Args: []string{"-c", "exit 3"}},
+		Task{Output: "slow", Command: "sleep", Args: []string{"5"}, Timeout: 100 * time.Millisecond},
+		Task{Output: "ok", Command: "echo", Args: []string{"fine"}},
+	)
+	results := ExecuteTasks(context.Background(), hclog.NewNullLogger(), set, TaskEnv{Run: run, OS: Linux, Parallelism: 2})
+	byOutput := map[string]TaskResult{}
+	for _, r := range results {
+		byOutput[r.Output] = r
+	}
+	for output, want := range map[string]string{"nowhere": StatusFailed, "missing": StatusMissing, "copy": StatusMissing, "exit": StatusFailed, "slow": StatusTimeout, "ok": StatusOK} {
+		r := byOutput[output]
+		if r.Status != want {
+			t.Errorf("%s: expected %s, got %s (%s)", output, want, r.Status, r.Error)
+		}
+	}
+	if byOutput["nowhere"].Error == "" || byOutput["exit"].Error != "" || byOutput["exit"].ExitStatus != 3 {
+		t.Errorf("expected only tasks rover could not run to record an error, got %+v", byOutput)
+	}
+
+	// Failures are reported, and only -strict makes them an error
+	summary := &Summary{}
+	summary.Add("test", results)
+	summary.Fail("other")
+	if summary.Failures() != 4 {
+		t.Errorf("expected 4 failures, got %d", summary.Failures())
+	}
+	ui := new(cli.MockUi)
+	if code := summary.Report(ui, false); code != 0 {
+		t.Errorf("expected failures to be tolerated, got %d", code)
+	}
+	if !strings.Contains(ui.OutputWriter.String(), "Timed out") || !strings.Contains(ui.ErrorWriter.String(), "4 task(s)") {
+		t.Errorf("expected a summary and a warning, got %s\n%s", ui.OutputWriter.String(), ui.ErrorWriter.String())
+	}
+	if code := summary.Report(new(cli.MockUi), true); code != 1 {
+		t.Errorf("expected -strict to fail, got %d", code)
+	}
+	if code := (&Summary{}).Report(new(cli.MockUi), true); code != 0 {
+		t.Errorf("expected nothing to report to succeed, got %d", code)
+	}
+
+	// Tasks cancelled by an interrupt have a column of their own, so that
+	// the counts of a row add up to its total
+	row := summaryRow("test", []TaskResult{{Status: StatusOK}, {Status: StatusCancelled}, {Status: StatusCancelled}, {Status: StatusSkipped}})
+	if row != "test | 3 | 1 | 0 | 0 | 0 | 0 | 2 | 1 | 0" {
+		t.Errorf("unexpected summary row %q", row)
+	}
+}
@@ -0,0 +1,503 @@
+// Package command for configuration
+// Config loads HCL or JSON files which describe additional tasks so that
+// in-house services can be captured without changing rover itself
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+)
+
+const (
+	// ConfigDirEnv names the environment variable which points to the
+	// configuration file or directory when -config is not given
+	ConfigDirEnv = "ROVER_CONFIG_DIR"
+
+	configPathDescr = "Path to a task configuration file or directory"
+
+	// Issue severities reported by configuration validation
+	issueError   = "error"
+	issueWarning = "warning"
+)
+
+// knownOS lists the runtime.GOOS values accepted in task os filters
+var knownOS = []string{Darwin, FreeBSD, Linux, NetBSD, OpenBSD, Solaris, Windows}
+
+// configKeys are the valid top level blocks; taskKeys and profileKeys the
+// valid keys of each block
+var (
+	configKeys  = []string{"task", "profile", "redact", "rule"}
+	taskKeys    = []string{"module", "command", "args", "file", "api", "ext", "max_bytes", "os", "timeout", "privileged", "sampling", "tags", "if_exists", "if_missing", "override"}
+	profileKeys = []string{"description", "include", "exclude", "override"}
+	redactKeys  = []string{"pattern", "replace"}
+	ruleKeys    = []string{"file", "parser", "pattern", "header", "column", "name_column", "op", "critical", "warning", "info", "message", "override"}
+)
+
+// taskConfig is the decoded form of a task block
+type taskConfig struct {
+	Module     string   `hcl:"module"`
+	Command    string   `hcl:"command"`
+	Args       []string `hcl:"args"`
+	File       string   `hcl:"file"`
+	API        string   `hcl:"api"`
+	Ext        string   `hcl:"ext"`
+	MaxBytes   int      `hcl:"max_bytes"`
+	OS         []string `hcl:"os"`
+	Timeout    string   `hcl:"timeout"`
+	Privileged bool     `hcl:"privileged"`
+	Sampling   bool     `hcl:"sampling"`
+	Tags       []string `hcl:"tags"`
+	IfExists   string   `hcl:"if_exists"`
+	IfMissing  string   `hcl:"if_missing"`
+	Override   bool     `hcl:"override"`
+}
+
+// profileConfig is the decoded form of a profile block
+type profileConfig struct {
+	Description string   `hcl:"description"`
+	Include     []string `hcl:"include"`
+	Exclude     []string `hcl:"exclude"`
+	Override    bool     `hcl:"override"`
+}
+
+// redactConfig is the decoded form of a redact block
+type redactConfig struct {
+	Pattern string `hcl:"pattern"`
+	Replace string `hcl:"replace"`
+}
+
+// ConfigTask is a task loaded from a configuration file
+type ConfigTask struct {
+	Task
+	// Source is the file and line the task was defined at
+	Source string
+	// Override allows the task to replace a built in task of the same name
+	Override bool
+}
+
+// ConfigProfile is a profile loaded from a configuration file
+type ConfigProfile struct {
+	Profile
+	// Source is the file and line the profile was defined at
+	Source string
+	// Override allows the profile to replace a built in profile
+	Override bool
+}
+
+// ConfigRedactRule is a redaction rule loaded from a configuration file
+type ConfigRedactRule struct {
+	RedactRule
+	// Source is the file and line the rule was defined at
+	Source string
+}
+
+// ConfigRule is an analyzer rule loaded from a configuration file
+type ConfigRule struct {
+	Rule
+	// Source is the file and line the rule was defined at
+	Source string
+	// Override allows the rule to replace a built in rule of the same name
+	Override bool
+}
+
+// Config is the result of loading one or more configuration files
+type Config struct {
+	Files       []string
+	Tasks       []ConfigTask
+	Profiles    []ConfigProfile
+	RedactRules []ConfigRedactRule
+	Rules       []ConfigRule
+}
+
+// ConfigIssue is a problem found while loading or validating configuration
+type ConfigIssue struct {
+	Source   string
+	Severity string
+	Message  string
+}
+
+// String formats the issue for output
+func (i ConfigIssue) String() string {
+	return fmt.Sprintf("%s: %s: %s", i.Source, i.Severity, i.Message)
+}
+
+// ConfigPath returns the configuration path from the flag value, falling
+// back to ROVER_CONFIG_DIR
+func ConfigPath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return os.Getenv(ConfigDirEnv)
+}
+
+// configFiles returns the HCL and JSON files at path in lexical order;
+// path can be a single file or a directory
+func configFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read configuration at %s with error %v", path, err)
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read configuration directory %s with error %v", path, err)
+	}
+	files := []string{}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".hcl" && ext != ".json") {
+			continue
+		}
+		files = append(files, filepath.Join(path, e.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ParseConfig reads every configuration file at path and returns the
+// tasks they define along with any structural issues found
+func ParseConfig(path string) (*Config, []ConfigIssue) {
+	cfg := &Config{}
+	files, err := configFiles(path)
+	if err != nil {
+		return cfg, []ConfigIssue{{Source: path, Severity: issueError, Message: err.Error()}}
+	}
+	issues := []ConfigIssue{}
+	for _, file := range files {
+		cfg.Files = append(cfg.Files, file)
+		issues = append(issues, parseConfigFile(cfg, file)...)
+	}
+	return cfg, issues
+}
+
+// parseConfigFile decodes a single file into cfg
+func parseConfigFile(cfg *Config, file string) []ConfigIssue {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return []ConfigIssue{{Source: file, Severity: issueError, Message: fmt.Sprintf("cannot read file with error %v", err)}}
+	}
+	return parseConfigBytes(cfg, file, b)
+}
+
+// parseConfigBytes decodes the configuration b read from file into cfg
+func parseConfigBytes(cfg *Config, file string, b []byte) []ConfigIssue {
+	issues := []ConfigIssue{}
+	errorf := func(source string, format string, args ...interface{}) {
+		issues = append(issues, ConfigIssue{Source: source, Severity: issueError, Message: fmt.Sprintf(format, args...)})
+	}
+	root, err := hcl.ParseBytes(b)
+	if err != nil {
+		errorf(file, "cannot parse file with error %v", err)
+		return issues
+	}
+	list, ok := root.Node.(*ast.ObjectList)
+	if !ok {
+		errorf(file, "file does not contain an object")
+		return issues
+	}
+	for _, item := range list.Items {
+		key := objectKey(item)
+		if !containsString(configKeys, key) {
+			errorf(itemSource(file, item), "unknown block %q", key)
+		}
+	}
+
+	for _, item := range list.Filter("task").Items {
+		source := itemSource(file, item)
+		if len(item.Keys) != 1 {
+			errorf(source, "task block must have exactly one name")
+			continue
+		}
+		name := objectKey(item)
+		for _, k := range unknownKeys(item, taskKeys) {
+			errorf(source, "unknown key %q in task %q", k, name)
+		}
+		var tc taskConfig
+		if err := hcl.DecodeObject(&tc, item.Val); err != nil {
+			errorf(source, "cannot decode task %q with error %v", name, err)
+			continue
+		}
+		t := Task{
+			Module:     tc.Module,
+			Output:     name,
+			Command:    tc.Command,
+			Args:       tc.Args,
+			File:       tc.File,
+			API:        tc.API,
+			Ext:        tc.Ext,
+			MaxBytes:   int64(tc.MaxBytes),
+			OS:         tc.OS,
+			Privileged: tc.Privileged,
+			Sampling:   tc.Sampling,
+			Tags:       tc.Tags,
+			IfExists:   tc.IfExists,
+			IfMissing:  tc.IfMissing,
+		}
+		if tc.Module == "" {
+			errorf(source, "task %q has no module", name)
+		}
+		set := 0
+		for _, v := range []string{tc.Command, tc.File, tc.API} {
+			if v != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			errorf(source, "task %q must set exactly one of command, file or api", name)
+		}
+		if tc.Ext != "" && tc.API == "" {
+			errorf(source, "task %q sets ext without api", name)
+		}
+		if tc.MaxBytes != 0 && tc.API == "" {
+			errorf(source, "task %q sets max_bytes without api", name)
+		}
+		if tc.MaxBytes < 0 {
+			errorf(source, "task %q has negative max_bytes", name)
+		}
+		for _, o := range tc.OS {
+			if !containsString(knownOS, o) {
+				errorf(source, "task %q has unknown os %q", name, o)
+			}
+		}
+		if tc.Timeout != "" {
+			d, err := time.ParseDuration(tc.Timeout)
+			if err != nil || d <= 0 {
+				errorf(source, "task %q has invalid timeout %q", name, tc.Timeout)
+			}
+			t.Timeout = d
+		}
+		cfg.Tasks = append(cfg.Tasks, ConfigTask{Task: t, Source: source, Override: tc.Override})
+	}
+
+	for _, item := range list.Filter("profile").Items {
+		source := itemSource(file, item)
+		if len(item.Keys) != 1 {
+			errorf(source, "profile block must have exactly one name")
+			continue
+		}
+		name := objectKey(item)
+		for _, k := range unknownKeys(item, profileKeys) {
+			errorf(source, "unknown key %q in profile %q", k, name)
+		}
+		var pc profileConfig
+		if err := hcl.DecodeObject(&pc, item.Val); err != nil {
+			errorf(source, "cannot decode profile %q with error %v", name, err)
+			continue
+		}
+		p := Profile{Name: name, Description: pc.Description, Include: pc.Include, Exclude: pc.Exclude}
+		cfg.Profiles = append(cfg.Profiles, ConfigProfile{Profile: p, Source: source, Override: pc.Override})
+	}
+
+	for _, item := range list.Filter("redact").Items {
+		source := itemSource(file, item)
+		if len(item.Keys) != 1 {
+			errorf(source, "redact block must have exactly one name")
+			continue
+		}
+		name := objectKey(item)
+		for _, k := range unknownKeys(item, redactKeys) {
+			errorf(source, "unknown key %q in redact rule %q", k, name)
+		}
+		var rc redactConfig
+		if err := hcl.DecodeObject(&rc, item.Val); err != nil {
+			errorf(source, "cannot decode redact rule %q with error %v", name, err)
+			continue
+		}
+		if rc.Pattern == "" {
+			errorf(source, "redact rule %q has no pattern", name)
+			continue
+		}
+		re, err := regexp.Compile(rc.Pattern)
+		if err != nil {
+			errorf(source, "redact rule %q has invalid pattern with error %v", name, err)
+			continue
+		}
+		if rc.Replace == "" {
+			rc.Replace = RedactedText
+		}
+		rule := RedactRule{Name: name, Pattern: re, Replace: rc.Replace}
+		cfg.RedactRules = append(cfg.RedactRules, ConfigRedactRule{RedactRule: rule, Source: source})
+	}
+
+	for _, item := range list.Filter("rule").Items {
+		source := itemSource(file, item)
+		if len(item.Keys) != 1 {
+			errorf(source, "rule block must have exactly one name")
+			continue
+		}
+		name := objectKey(item)
+		for _, k := range unknownKeys(item, ruleKeys) {
+			errorf(source, "unknown key %q in rule %q", k, name)
+		}
+		var rc ruleConfig
+		if err := hcl.DecodeObject(&rc, item.Val); err != nil {
+			errorf(source, "cannot decode rule %q with error %v", name, err)
+			continue
+		}
+		rule, problems := rc.rule(name)
+		for _, p := range problems {
+			errorf(source, "%s", p)
+		}
+		if len(problems) == 0 {
+			cfg.Rules = append(cfg.Rules, ConfigRule{Rule: rule, Source: source, Override: rc.Override})
+		}
+	}
+	return issues
+}
+
+// ValidateConfig parses the configuration at path and checks it against
+// the tasks already in r
+func ValidateConfig(path string, r *Registry) []ConfigIssue {
+	cfg, issues := ParseConfig(path)
+	return append(issues, cfg.Validate(r)...)
+}
+
+// Validate checks loaded tasks, profiles, redact and analyzer rules against
+// those already in r: duplicate names are errors, while binaries or files
+// missing on this host are warnings
+func (cfg *Config) Validate(r *Registry) []ConfigIssue {
+	issues := []ConfigIssue{}
+	seen := map[string]string{}
+	for _, ct := range cfg.Tasks {
+		id := filepath.Join(ct.Module, ct.Output)
+		if prev, ok := seen[id]; ok {
+			issues = append(issues, ConfigIssue{Source: ct.Source, Severity: issueError,
+				Message: fmt.Sprintf("duplicate output %q, already defined at %s", id, prev)})
+		}
+		seen[id] = ct.Source
+		if !ct.Override && r.hasTask(ct.Module, ct.Output) {
+			issues = append(issues, ConfigIssue{Source: ct.Source, Severity: issueError,
+				Message: fmt.Sprintf("output %q duplicates a built in task; set override = true to replace it", id)})
+		}
+		if ct.Command != "" {
+			if _, err := exec.LookPath(ct.Command); err != nil {
+				issues = append(issues, ConfigIssue{Source: ct.Source, Severity: issueWarning,
+					Message: fmt.Sprintf("command %q for task %q not found in PATH", ct.Command, ct.Output)})
+			}
+		}
+		if ct.File != "" {
+			if _, err := os.Stat(ct.File); err != nil {
+				issues = append(issues, ConfigIssue{Source: ct.Source, Severity: issueWarning,
+					Message: fmt.Sprintf("file %q for task %q does not exist", ct.File, ct.Output)})
+			}
+		}
+	}
+	profiles := map[string]string{}
+	for _, cp := range cfg.Profiles {
+		if prev, ok := profiles[cp.Name]; ok {
+			issues = append(issues, ConfigIssue{Source: cp.Source, Severity: issueError,
+				Message: fmt.Sprintf("duplicate profile %q, already defined at %s", cp.Name, prev)})
+		}
+		profiles[cp.Name] = cp.Source
+		if !cp.Override && r.hasProfile(cp.Name) {
+			issues = append(issues, ConfigIssue{Source: cp.Source, Severity: issueError,
+				Message: fmt.Sprintf("profile %q duplicates a built in profile; set override = true to replace it", cp.Name)})
+		}
+	}
+	rules := map[string]string{}
+	for _, cr := range cfg.RedactRules {
+		if prev, ok := rules[cr.Name]; ok {
+			issues = append(issues, ConfigIssue{Source: cr.Source, Severity: issueError,
+				Message: fmt.Sprintf("duplicate redact rule %q, already defined at %s", cr.Name, prev)})
+		}
+		rules[cr.Name] = cr.Source
+		if r.hasRedactRule(cr.Name) {
+			issues = append(issues, ConfigIssue{Source: cr.Source, Severity: issueError,
+				Message: fmt.Sprintf("redact rule %q duplicates a built in rule; built in rules cannot be replaced", cr.Name)})
+		}
+	}
+	analyzerRules := map[string]string{}
+	for _, cr := range cfg.Rules {
+		if prev, ok := analyzerRules[cr.Name]; ok {
+			issues = append(issues, ConfigIssue{Source: cr.Source, Severity: issueError,
+				Message: fmt.Sprintf("duplicate rule %q, already defined at %s", cr.Name, prev)})
+		}
+		analyzerRules[cr.Name] = cr.Source
+		if !cr.Override && r.hasRule(cr.Name) {
+			issues = append(issues, ConfigIssue{Source: cr.Source, Severity: issueError,
+				Message: fmt.Sprintf("rule %q duplicates a built in rule; set override = true to replace it", cr.Name)})
+		}
+	}
+	return issues
+}
+
+// LoadConfig validates the configuration at path and registers its tasks
+// with r; an empty path is not an error and loads nothing
+func LoadConfig(path string, r *Registry) error {
+	if path == "" {
+		return nil
+	}
+	cfg, issues := ParseConfig(path)
+	issues = append(issues, cfg.Validate(r)...)
+	errs := []string{}
+	for _, i := range issues {
+		if i.Severity == issueError {
+			errs = append(errs, i.String())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%s", strings.Join(errs, "\n"))
+	}
+	for _, ct := range cfg.Tasks {
+		if err := r.AddTask(ct.Task); err != nil {
+			return fmt.Errorf("%s: %v", ct.Source, err)
+		}
+	}
+	for _, cp := range cfg.Profiles {
+		r.AddProfile(cp.Profile)
+	}
+	for _, cr := range cfg.RedactRules {
+		r.AddRedactRule(cr.RedactRule)
+	}
+	for _, cr := range cfg.Rules {
+		r.AddRule(cr.Rule)
+	}
+	return nil
+}
+
+// objectKey returns the first key of an item as a plain string
+func objectKey(item *ast.ObjectItem) string {
+	if len(item.Keys) == 0 {
+		return ""
+	}
+	if s, ok := item.Keys[0].Token.Value().(string); ok {
+		return s
+	}
+	return item.Keys[0].Token.Text
+}
+
+// unknownKeys returns the keys of a block which are not in valid
+func unknownKeys(item *ast.ObjectItem, valid []string) []string {
+	unknown := []string{}
+	obj, ok := item.Val.(*ast.ObjectType)
+	if !ok {
+		return unknown
+	}
+	for _, i := range obj.List.Items {
+		if k := objectKey(i); !containsString(valid, k) {
+			unknown = append(unknown, k)
+		}
+	}
+	return unknown
+}
+
+// itemSource formats the file and line of an item; JSON input carries no
+// line information so only the file is given
+func itemSource(file string, item *ast.ObjectItem) string {
+	if line := item.Pos().Line; line > 0 {
+		return fmt.Sprintf("%s:%d", file, line)
+	}
+	return file
+}
@@ -0,0 +1,153 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rover-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hclConfig := `
+task "myapp_status" {
+  module  = "myapp"
+  command = "myappctl"
+  args    = ["status"]
+  timeout = "30s"
+}
+
+task "df" {
+  module  = "system"
+  command = "df"
+  argz    = ["-x"]
+}
+
+task "myapp_health" {
+  module    = "myapp"
+  command   = "myappctl"
+  api       = "/v1/health"
+  max_bytes = 1024
+}
+`
+	jsonConfig := `{"task": {"myapp_status": {"module": "myapp", "file": "/etc/myapp.conf"}}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.hcl"), []byte(hclConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "b.json"), []byte(jsonConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRegistry()
+	r.Register(NewTaskSet("system", Task{Output: "df", Command: "df"}))
+	issues := ValidateConfig(dir, r)
+
+	want := []string{
+		`unknown key "argz" in task "df"`,
+		`output "system/df" duplicates a built in task`,
+		`duplicate output "myapp/myapp_status"`,
+		`command "myappctl" for task "myapp_status" not found in PATH`,
+		`task "myapp_health" must set exactly one of command, file or api`,
+	}
+	for _, w := range want {
+		found := false
+		for _, i := range issues {
+			if strings.Contains(i.Message, w) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected issue containing %q in %v", w, issues)
+		}
+	}
+
+	if err := LoadConfig(dir, r); err == nil {
+		t.Fatal("expected invalid configuration to fail loading")
+	}
+}
+
+func TestLoadConfigRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rover-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	invalid := `
+rule "swappiness" {
+  file    = "system/proc_sys_vm_swappiness.txt"
+  parser  = "regex"
+  pattern = "(?P<value>\\d+)"
+  warning = 1
+  message = "vm.swappiness is {value}"
+}
+
+rule "steal" {
+  file    = "system/top.txt"
+  parser  = "regex"
+  pattern = "(\\d+\\.\\d+) st"
+  warning = 10
+  message = "CPU steal is {value}%"
+}
+
+rule "load" {
+  file    = "system/proc_loadavg.txt"
+  parser  = "lines"
+  op      = "~"
+  message = "load is {value}"
+}
+`
+	p := filepath.Join(dir, "rules.hcl")
+	if err := ioutil.WriteFile(p, []byte(invalid), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry()
+	r.AddRule(Rule{Name: "swappiness"})
+	issues := ValidateConfig(p, r)
+	want := []string{
+		`rule "swappiness" duplicates a built in rule`,
+		`rule "steal" pattern has no value group`,
+		`rule "load" has unknown op "~"`,
+		`rule "load" has unknown parser "lines"`,
+		`rule "load" must set at least one of critical, warning or info`,
+	}
+	for _, w := range want {
+		found := false
+		for _, i := range issues {
+			if strings.Contains(i.Message, w) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected issue containing %q in %v", w, issues)
+		}
+	}
+
+	valid := `
+rule "swappiness" {
+  file     = "system/proc_sys_vm_swappiness.txt"
+  parser   = "regex"
+  pattern  = "(?P<value>\\d+)"
+  warning  = 1
+  critical = 30.5
+  message  = "vm.swappiness is {value}"
+  override = true
+}
+`
+	if err := ioutil.WriteFile(p, []byte(valid), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfig(p, r); err != nil {
+		t.Fatal(err)
+	}
+	rules := r.Rules()
+	if len(rules) != 1 || rules[0].Thresholds[SeverityCritical] != "30.5" {
+		t.Fatalf("expected the built in rule to be overridden, got %v", rules)
+	}
+}
@@ -0,0 +1,98 @@
+// Package command for config validate
+// ConfigValidate checks task configuration files before anything runs
+package command
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// ConfigCommand is the parent of the config subcommands
+type ConfigCommand struct {
+	UI cli.Ui
+}
+
+// Help output
+func (c *ConfigCommand) Help() string {
+	helpText := `
+Usage: rover config <subcommand> [options]
+	Work with HCL or JSON task configuration files
+`
+
+	return strings.TrimSpace(helpText)
+}
+
+// Run command
+func (c *ConfigCommand) Run(_ []string) int {
+	return cli.RunResultHelp
+}
+
+// Synopsis output
+func (c *ConfigCommand) Synopsis() string {
+	return "Work with task configuration files"
+}
+
+// ConfigValidateCommand describes config validate related fields
+type ConfigValidateCommand struct {
+	ConfigPath string
+	UI         cli.Ui
+}
+
+// Help output
+func (c *ConfigValidateCommand) Help() string {
+	helpText := `
+Usage: rover config validate [options] [<path>]
+	Validate HCL or JSON task configuration files, reporting unknown keys,
+	duplicate output names and commands or files missing on this host
+
+General Options:
+  -config=<path>	Task configuration file or directory [default: $ROVER_CONFIG_DIR]
+`
+
+	return strings.TrimSpace(helpText)
+}
+
+// Run command
+func (c *ConfigValidateCommand) Run(args []string) int {
+	cmdFlags := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.StringVar(&c.ConfigPath, "config", "", configPathDescr)
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+	if cmdFlags.NArg() > 0 {
+		c.ConfigPath = cmdFlags.Arg(0)
+	}
+	path := ConfigPath(c.ConfigPath)
+	if path == "" {
+		c.UI.Error(fmt.Sprintf("No configuration path given; use -config or set %s.", ConfigDirEnv))
+		return 1
+	}
+
+	cfg, issues := ParseConfig(path)
+	issues = append(issues, cfg.Validate(Collectors)...)
+	errors := 0
+	for _, i := range issues {
+		if i.Severity == issueError {
+			errors++
+			c.UI.Error(i.String())
+		} else {
+			c.UI.Warn(i.String())
+		}
+	}
+	if errors > 0 {
+		c.UI.Error(fmt.Sprintf("Configuration is invalid: %d error(s), %d warning(s).", errors, len(issues)-errors))
+		return 1
+	}
+	c.UI.Output(fmt.Sprintf("Configuration is valid: %d task(s) and %d rule(s) in %d file(s).", len(cfg.Tasks), len(cfg.Rules), len(cfg.Files)))
+
+	return 0
+}
+
+// Synopsis output
+func (c *ConfigValidateCommand) Synopsis() string {
+	return "Validate task configuration files"
+}
@@ -4,11 +4,10 @@
 package command
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"time"
 
@@ -17,59 +16,130 @@ import (
 	"github.com/mitchellh/cli"
 )
 
+// consulTasks are the Consul probes; unauthenticated first, then commands
+// which fail most of the time unless running without ACL or with a token,
+// followed by Consul-specific operating system tasks based on host OS ID
+var consulTasks = []Task{
+	{Output: "consul_version", Command: "consul", Args: []string{"version"}},
+	{Output: "consul_info", Command: "consul", Args: []string{"info"}},
+	{Output: "consul_members", Command: "consul", Args: []string{"members"}},
+	{Output: "consul_operator_raft_list_peers", Command: "consul", Args: []string{"operator", "raft", "list-peers"}},
+	{Output: "consul_catalog_datacenters", Command: "consul", Args: []string{"catalog", "datacenters"}},
+	{Output: "consul_catalog_services", Command: "consul", Args: []string{"catalog", "services"}},
+
+	// Consul log messages from system logs (sudo required)
+	{Output: "consul_syslog", Command: "grep", Args: []string{"-w", "consul", "/var/log/system.log"}, OS: []string{Darwin}, Privileged: true, Tags: []string{TagSlow}},
+	{Output: "consul_syslog", Command: "grep", Args: []string{"-w", "consul", "/var/log/syslog"}, OS: []string{FreeBSD, Linux}, IfExists: "/var/log/syslog", Privileged: true, Tags: []string{TagSlow}},
+	{Output: "consul_syslog", Command: "grep", Args: []string{"-w", "consul", "/var/log/messages"}, OS: []string{FreeBSD, Linux}, IfMissing: "/var/log/syslog", Privileged: true, Tags: []string{TagSlow}},
+
+	// Select process table information when Linux and PID determined
+	{Output: "proc_consul_limits", Command: "cat", Args: []string{"/proc/{pid}/limits"}, OS: []string{Linux}},
+	{Output: "proc_consul_status", Command: "cat", Args: []string{"/proc/{pid}/status"}, OS: []string{Linux}},
+	{Output: "proc_consul_open_file_count", Command: "sh", Args: []string{"-c", "ls /proc/{pid}/fd | wc -l"}, OS: []string{Linux}, Privileged: true},
+	{Output: "systemctl_status_consul", Command: "systemctl", Args: []string{"status", "consul"}, OS: []string{Linux}, IfExists: "/run/systemd/system"},
+	{Output: "consul_journald", Command: "journalctl", Args: []string{"-b", "--no-pager", "-u", "consul"}, OS: []string{Linux}, IfExists: "/run/systemd/system", Privileged: true, Tags: []string{TagSlow}},
+
+	// Agent, cluster and telemetry details from the HTTP API
+	{Output: "consul_agent_self", API: "/v1/agent/self", Ext: "json"},
+	{Output: "consul_agent_members", API: "/v1/agent/members", Ext: "json"},
+	{Output: "consul_operator_raft_configuration", API: "/v1/operator/raft/configuration", Ext: "json"},
+	{Output: "consul_agent_metrics", API: "/v1/agent/metrics", Ext: "json"},
+	{Output: "consul_operator_autopilot_health", API: "/v1/operator/autopilot/health", Ext: "json"},
+
+	// Full Consul goroutine stack dump and heap dump
+	{Output: "consul_goroutine", API: "/debug/pprof/goroutine?debug=2", Tags: []string{TagDeep}},
+	{Output: "consul_heap", API: "/debug/pprof/heap?debug=1", Tags: []string{TagDeep}},
+}
+
+func init() {
+	Collectors.Register(NewTaskSet("consul", consulTasks...))
+}
+
 // ConsulCommand describes Consul related fields
 type ConsulCommand struct {
+	Timeout        time.Duration
+	Parallelism    int
+	Profile        string
+	NoRedact       bool
+	Strict         bool
+	ConfigPath     string
 	ConsulPID      string
+	OutputDir      string
 	HostName       string
-	HTTPCommand    string
 	HTTPTokenValue string
 	OS             string
 	OutputPath     string
+	RunContext     *RunContext
 	UI             cli.Ui
 }
 
 // Help output
 func (c *ConsulCommand) Help() string {
 	helpText := `
-Usage: rover consul
+Usage: rover consul [options]
 	Execute a series of Consul related commands and store output in text files
+
+General Options:
+  -config=<path>	Task configuration file or directory [default: $ROVER_CONFIG_DIR]
+  -output-dir=<dir>	Directory holding the <hostname> data directory [default: $ROVER_OUTPUT_DIR or the latest run]
+  -log-level=<level>	Log level: trace, debug, info, warn or error [default: $ROVER_LOG_LEVEL or info]
+  -log-json		Write rover.log as JSON [default: true when $ROVER_LOG_FORMAT is json]
+  -log-mirror		Print logged warnings to the terminal [default: true]
+  -timeout=<duration>	Default deadline for each task [default: 2m]
+  -parallelism=<n>	Maximum number of tasks to run at once [default: 4]
+  -profile=<name>	Collection profile: minimal, standard, deep or custom [default: standard]
+  -no-redact		Do not redact secrets from output; internal use only [default: false]
+  -strict		Exit non-zero when any task fails, times out or is cancelled [default: false]
 `
 
 	return strings.TrimSpace(helpText)
 }
 
 // Run consul commands
-func (c *ConsulCommand) Run(_ []string) int {
-	c.OS = runtime.GOOS
-	h, err := GetHostName()
-	if err != nil {
-		out := fmt.Sprintf("Cannot get system hostname with error %v", err)
-		c.UI.Output(out)
-		return 1
+func (c *ConsulCommand) Run(args []string) int {
+	run := c.RunContext
+	if run == nil {
+		run = NewRunContext(c.UI)
 	}
-	c.HostName = h
-	// Internal logging
-	l := "rover.log"
-	p := filepath.Join(fmt.Sprintf("%s", c.HostName), "log")
-	if err := os.MkdirAll(p, os.ModePerm); err != nil {
-		out := fmt.Sprintf("Cannot create log directory %s.", p)
-		c.UI.Error(out)
+	c.OS = run.OS
+	cmdFlags := flag.NewFlagSet("consul", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.StringVar(&c.ConfigPath, "config", "", configPathDescr)
+	cmdFlags.DurationVar(&c.Timeout, "timeout", DefaultTaskTimeout, timeoutDescr)
+	cmdFlags.IntVar(&c.Parallelism, "parallelism", DefaultParallelism, parallelismDescr)
+	cmdFlags.StringVar(&c.Profile, "profile", DefaultProfile, profileDescr)
+	cmdFlags.BoolVar(&c.NoRedact, "no-redact", false, noRedactDescr)
+	cmdFlags.BoolVar(&c.Strict, "strict", false, strictDescr)
+	cmdFlags.StringVar(&c.OutputDir, "output-dir", "", outputDirDescr)
+	run.Flags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
-	f, err := os.OpenFile(filepath.Join(p, l), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		out := fmt.Sprintf("Failed to open log file %s with error: %v", f, err)
-		c.UI.Error(out)
+	if err := run.Open(c.OutputDir, RunReuse); err != nil {
+		c.UI.Error(err.Error())
 		return 1
 	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	logger := hclog.New(&hclog.LoggerOptions{Name: "rover", Level: hclog.LevelFromString("INFO"), Output: w})
+	defer run.Close()
+	c.HostName = run.HostName
+	logger := run.Logger
 
 	logger.Info("consul", "hello from the Consul module at", c.HostName)
 	logger.Info("consul", "our detected OS", c.OS)
 
-	p, err = CheckProc("consul")
+	if err := LoadConfig(ConfigPath(c.ConfigPath), Collectors); err != nil {
+		logger.Error("consul", "cannot load configuration with error", err.Error())
+		c.UI.Error(err.Error())
+		return 1
+	}
+	profile, err := Collectors.Profile(c.Profile)
+	if err != nil {
+		logger.Error("consul", "cannot find profile with error", err.Error())
+		c.UI.Error(err.Error())
+		return 1
+	}
+	redactor := commandRedactor(logger, c.UI, c.NoRedact)
+
+	p, err := run.CheckProc("consul")
 	if err != nil {
 		logger.Info("consul", "consul process not detected:", err.Error())
 		out := "Consul process not detected in this environment."
@@ -77,10 +147,9 @@ func (c *ConsulCommand) Run(_ []string) int {
 		return 1
 	}
 	c.ConsulPID = p
-	c.HTTPCommand = HTTPCmdCheck()
 	c.HTTPTokenValue = os.Getenv("CONSUL_HTTP_TOKEN")
 	// Command output directory
-	outPath := filepath.Join(".", fmt.Sprintf("%s/consul", c.HostName))
+	outPath := filepath.Join(run.HostDir(), "consul")
 	if err := os.MkdirAll(outPath, os.ModePerm); err != nil {
 		out := fmt.Sprintf("cannot create directory %s", outPath)
 		logger.Info("consul", out)
@@ -88,7 +157,7 @@ func (c *ConsulCommand) Run(_ []string) int {
 		return 1
 	}
 	// Drop a note about CONSUL_HTTP_TOKEN (zero length == unset)
-	logger.Warn("vault", "CONSUL_HTTP_TOKEN length", hclog.Fmt("%v", len(c.HTTPTokenValue)))
+	logger.Info("consul", "CONSUL_HTTP_TOKEN length", hclog.Fmt("%v", len(c.HTTPTokenValue)))
 	// Dump commands only if a running Consul process is detected
 	if c.ConsulPID != "" {
 		logger.Info("consul", "agent process identified", c.ConsulPID)
@@ -105,73 +174,36 @@ func (c *ConsulCommand) Run(_ []string) int {
 		s.FinalMSG = "Gathered Consul data\n"
 		s.Start()
 
-		// Unauthenticated first...
-		Dump("consul", "consul_version", "consul", "version")
-		// These will fail most of the time unless running without ACL
-		Dump("consul", "consul_info", "consul", "info")
-		Dump("consul", "consul_members", "consul", "members")
-		Dump("consul",
-			"consul_operator_raft_list_peers",
-			"consul",
-			"operator",
-			"raft",
-			"list-peers")
-
-        Dump("consul", "consul_catalog_datacenters", "consul", "catalog", "datacenters")
-        Dump("consul", "consul_catalog_services", "consul", "catalog", "services")
-
-		// Consul-specific operating system tasks based on host OS ID
-		switch c.OS {
-		case Darwin:
-			logger.Info("attempt to extract consul log messages from system log (sudo required) ...")
-			Dump("consul", "consul_syslog", "grep", "-w", "consul", "/var/log/system.log")
-		case FreeBSD:
-			// Grep for "consul" in /var/log/messages or /var/log/syslog (sudo required)
-			logger.Info("attempt to extact consul log messages from system logs (sudo required) ...")
-			if FileExist("/var/log/syslog") {
-				logger.Info("checking /var/log/syslog for consul entries (sudo required) ...")
-				Dump("consul", "consul_syslog", "grep", "-w", "consul", "/var/log/syslog")
-			} else {
-				logger.Info("no /var/log/syslog found, checking /var/log/messages for consul entries (sudo required) ...")
-				Dump("consul", "consul_syslog", "grep", "-w", "consul", "/var/log/messages")
-			}
-		case Linux:
-			// Select process table information when Linux and PID determined
-			Dump("consul", "proc_consul_limits", "cat", fmt.Sprintf("/proc/%s/limits", c.ConsulPID))
-			Dump("consul", "proc_consul_status", "cat", fmt.Sprintf("/proc/%s/status", c.ConsulPID))
-			Dump("consul", "proc_consul_open_file_count", "sh", "-c", fmt.Sprintf("ls /proc/%s/fd | wc -l", c.ConsulPID))
-			// Grep for "consul" in /var/log/messages or /var/log/syslog (sudo required)
-			logger.Info("attempting to extract consul log messages from system logs (sudo required) ...")
-			if FileExist("/var/log/syslog") {
-				logger.Info("checking /var/log/syslog for consul entries (sudo required) ...")
-				Dump("consul", "consul_syslog", "grep", "-w", "consul", "/var/log/syslog")
-			} else {
-				logger.Info("no /var/log/syslog found, checking /var/log/messages for consul entries (sudo required) ...")
-				Dump("consul", "consul_syslog", "grep", "-w", "consul", "/var/log/messages")
-			}
-			if FileExist("/run/systemd/system") {
-				logger.Info("consul", "attempting to gather Consul systemd unit status")
-				Dump("consul", "systemctl_status_consul", "systemctl", "status", "consul")
-				logger.Info("consul", "attempting to gather Consul operational logging from systemd journal")
-				Dump("consul", "consul_journald", "journalctl", "-b", "--no-pager", "-u", "consul")
-			}
+		tasks, err := Collectors.Lookup("consul")
+		if err != nil {
+			logger.Error("consul", "cannot find task set with error", err.Error())
+			s.Stop()
+			c.UI.Error(err.Error())
+			return 1
+		}
+		api, err := moduleAPI(Consul)
+		if err != nil {
+			logger.Warn("consul", "cannot configure API client, skipping API tasks", err.Error())
 		}
-		// Attempt to grab full Consul gorountine stack dump and heap dump
-		tokenHeader := fmt.Sprintf("X-Consul-Token: %s", c.HTTPTokenValue)
-		if c.HTTPCommand == "curl" {
-			logger.Info("attempting goroutine dump with curl (requires ACL token)")
-			Dump("consul", "consul_goroutine", "curl", "-s", "--header", tokenHeader, "localhost:8500/debug/pprof/goroutine?debug=2")
-			logger.Info("attempting heap dump with curl (requires ACL token)")
-			Dump("consul", "consul_heap", "curl", "-s", "--header", tokenHeader, "localhost:8500/debug/pprof/heap?debug=1")
-		} else if c.HTTPCommand == "wget" {
-			logger.Info("attempting goroutine dump with wget (requires ACL token)")
-			Dump("consul", "consul_goroutine", "wget", "--header", tokenHeader, "-qO-", "localhost:8500/debug/pprof/gorpoutine?debug=2")
-			logger.Info("attempting heap dump with wget (requires ACL token)")
-			Dump("consul", "consul_heap", "wget", "--header", tokenHeader, "-qO-", "localhost:8500/debug/pprof/heap?debug=1")
-		} else {
-			logger.Warn("cannot detect curl or wget in this environment")
+		ctx, cancel := InterruptContext()
+		defer cancel()
+		results := ExecuteTasks(ctx, logger, tasks, TaskEnv{
+			Run:         run,
+			OS:          c.OS,
+			Vars:        moduleVars(c.ConsulPID),
+			Timeout:     c.Timeout,
+			Parallelism: c.Parallelism,
+			Profile:     profile,
+			Redactor:    redactor,
+			API:         api,
+		})
+		if err := UpdateManifest(run.HostDir(), c.OS, profile.Name, results); err != nil {
+			logger.Warn("consul", "cannot update manifest with error", err.Error())
 		}
 		s.Stop()
+		summary := &Summary{}
+		summary.Add(Consul, results)
+		return summary.Report(c.UI, c.Strict)
 	} else {
 		logger.Info("no consul details learned from this environment.")
 	}
@@ -0,0 +1,154 @@
+// Package command for decrypt
+// Decrypt recovers the archive from one encrypted by rover archive
+// with OpenPGP or age, on the side which received it
+package command
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/mitchellh/cli"
+)
+
+// DecryptCommand describes decrypt related fields
+type DecryptCommand struct {
+	Key        string
+	Identities stringsFlag
+	OutFile    string
+	UI         cli.Ui
+}
+
+// Help output
+func (c *DecryptCommand) Help() string {
+	helpText := `
+Usage: rover decrypt -key=<keyfile> [options] <archive>.gpg
+       rover decrypt -identity=<file> [options] <archive>.age
+	Decrypt an archive made with rover archive -encrypt-to using an
+	OpenPGP private key, or one made with -recipient or -recipients-file
+	using an age identity, writing the archive next to it without the
+	.gpg or .age suffix. When the OpenPGP key is protected by a
+	passphrase, it is read from $ROVER_PGP_PASSPHRASE or asked for
+
+General Options:
+  -key=<keyfile>	OpenPGP private key file, armored or binary
+  -identity=<file>	age identity file, as written by age-keygen; repeatable
+  -out=<file>		Where to write the archive [default: archive without .gpg or .age]
+`
+
+	return strings.TrimSpace(helpText)
+}
+
+// Run command
+func (c *DecryptCommand) Run(args []string) int {
+	cmdFlags := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.StringVar(&c.Key, "key", "", "OpenPGP private key file")
+	cmdFlags.Var(&c.Identities, "identity", "age identity file")
+	cmdFlags.StringVar(&c.OutFile, "out", "", "Decrypted archive file")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+	if cmdFlags.NArg() != 1 || (c.Key == "" && len(c.Identities) == 0) {
+		c.UI.Error(c.Help())
+		return 1
+	}
+	in := cmdFlags.Arg(0)
+
+	f, err := os.Open(in)
+	if err != nil {
+		out := fmt.Sprintf("Cannot open archive %s with error %v", in, err)
+		c.UI.Error(out)
+		return 1
+	}
+	defer f.Close()
+	// The format is told by the contents rather than the name, which may
+	// have been changed in transit
+	br := bufio.NewReader(f)
+	var r io.Reader
+	if head, _ := br.Peek(len(ageHeader)); string(head) == ageHeader {
+		r, err = c.decryptAge(br)
+	} else {
+		r, err = c.decryptPGP(br)
+	}
+	if err != nil {
+		out := fmt.Sprintf("Cannot decrypt %s with error %v", in, err)
+		c.UI.Error(out)
+		return 1
+	}
+	if c.OutFile == "" {
+		c.OutFile = strings.TrimSuffix(strings.TrimSuffix(in, PGPSuffix), AgeSuffix)
+		if c.OutFile == in {
+			// Without a suffix to remove, the name is that of the archive
+			// format found in the decrypted data
+			dr := bufio.NewReader(r)
+			head, _ := dr.Peek(4)
+			format, err := headFormat(head)
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Cannot name the output of %s: the decrypted data %v; use -out", in, err))
+				return 1
+			}
+			r, c.OutFile = dr, in+"."+format
+		}
+	}
+
+	out, err := os.OpenFile(c.OutFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Cannot create %s with error %v", c.OutFile, err))
+		return 1
+	}
+	// The integrity check happens at the end of the message, so nothing
+	// is kept unless all of it was read
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(c.OutFile)
+		c.UI.Error(fmt.Sprintf("Cannot decrypt %s with error %v", in, err))
+		return 1
+	}
+	c.UI.Output(fmt.Sprintf("Decrypted %s to %s", in, c.OutFile))
+	return 0
+}
+
+// decryptAge decrypts an age file with the identities given
+func (c *DecryptCommand) decryptAge(r io.Reader) (io.Reader, error) {
+	if len(c.Identities) == 0 {
+		return nil, fmt.Errorf("the archive is encrypted with age; use -identity")
+	}
+	identities, err := readAgeIdentities(c.Identities)
+	if err != nil {
+		return nil, err
+	}
+	return age.Decrypt(r, identities...)
+}
+
+// decryptPGP decrypts an OpenPGP message with the private key given
+func (c *DecryptCommand) decryptPGP(r io.Reader) (io.Reader, error) {
+	if c.Key == "" {
+		return nil, fmt.Errorf("the archive is encrypted with OpenPGP; use -key")
+	}
+	keys, err := readPGPKeys([]string{c.Key})
+	if err != nil {
+		return nil, err
+	}
+	if len(keys.DecryptionKeys()) == 0 {
+		return nil, fmt.Errorf("no private keys found in %s; decrypting needs the private key of a recipient", c.Key)
+	}
+	return pgpDecrypt(r, keys, func() (string, error) {
+		if p := os.Getenv(PGPPassphraseEnv); p != "" {
+			return p, nil
+		}
+		return c.UI.AskSecret(fmt.Sprintf("Passphrase for %s:", c.Key))
+	})
+}
+
+// Synopsis output
+func (c *DecryptCommand) Synopsis() string {
+	return "Decrypt an encrypted rover archive"
+}
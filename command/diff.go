@@ -0,0 +1,400 @@
+// Package command for diff
+// Diff compares two rover archives, such as one collected before and one
+// after an upgrade or one from a failing node and one from a healthy peer,
+// reporting what changed in terms of packages, settings and versions
+package command
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// volatileFiles match outputs which differ on every collection, such as
+// clocks, counters, samples and logs; diff skips them unless asked
+var volatileFiles = []string{
+	ManifestFile, FindingsFile, "log/*",
+	"*/date.txt", "*/top.txt", "*/vmstat.txt", "*/vm_stat.txt", "*/iostat_*.txt", "*/w.txt", "*/last.txt", "*/ps.txt",
+	"*/free.txt", "*/swapinfo.txt", "*/df.txt", "*/df_h.txt", "*/df_i.txt", "*/dmesg.txt", "*/netstat_*.txt",
+	"*/proc_stat.txt", "*/proc_interrupts.txt", "*/proc_diskstats.txt", "*/proc_uptime.txt", "*/proc_vmstat.txt", "*/proc_meminfo.txt",
+	"*/proc_*_status.txt", "*/proc_*_open_file_count.txt", "*/systemctl_all.txt", "*/systemctl_status_*.txt",
+	"*/journalctl_*.txt", "*/*_journald.txt", "*/*_syslog.txt", "*/file_var_log_*.txt", "*/file_var_run_dmesg_boot.txt",
+	"*/chronyc_tracking.txt", "*/timedatectl.txt", "*/consul_info.txt",
+	"*/*_metrics.json", "*/*_goroutine.txt", "*/*_heap.txt", "*/nomad_allocations.json", "*/nomad_evaluations.json",
+}
+
+// volatileJSONKeys are top level keys of API responses which change between
+// requests, e.g. request IDs and runtime statistics
+var volatileJSONKeys = []string{"request_id", "lease_id", "lease_duration", "renewable", "wrap_info", "warnings", "auth", "Stats", "stats", "Coord"}
+
+// differ compares two versions of an output and returns one line per change:
+// "+ " for added, "- " for removed and "~ " for changed entries
+type differ func(a, b string) []string
+
+// outputDiffers pick the comparison for outputs with known structure; the
+// first matching pattern wins and other outputs are compared line by line
+var outputDiffers = []struct {
+	pattern string
+	kind    string
+	diff    differ
+}{
+	{"system/dpkg.txt", "packages", keyedDiffer(parseDpkg)},
+	{"system/rpm.txt", "packages", keyedDiffer(parseRPM)},
+	{"system/pkg_info.txt", "packages", keyedDiffer(parsePkgInfo)},
+	{"system/sysctl.txt", "settings", keyedDiffer(parseSysctl)},
+	{"system/file_etc_sysctl_conf.txt", "settings", keyedDiffer(parseSysctl)},
+	{"system/proc_mounts.txt", "mounts", keyedDiffer(parseMounts)},
+	{"*/proc_*_limits.txt", "limits", keyedDiffer(parseProcLimits)},
+	{"*/*.json", "configuration", keyedDiffer(flattenJSON)},
+}
+
+// keyedDiffer compares outputs parsed into maps by key
+func keyedDiffer(parse func(string) map[string]string) differ {
+	return func(a, b string) []string {
+		return diffMaps(parse(a), parse(b))
+	}
+}
+
+// diffMaps reports added, removed and changed keys in key order
+func diffMaps(a, b map[string]string) []string {
+	keys := []string{}
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	changes := []string{}
+	for _, k := range keys {
+		va, inA := a[k]
+		vb, inB := b[k]
+		switch {
+		case !inB:
+			changes = append(changes, fmt.Sprintf("- %s %s", k, va))
+		case !inA:
+			changes = append(changes, fmt.Sprintf("+ %s %s", k, vb))
+		case va != vb:
+			changes = append(changes, fmt.Sprintf("~ %s: %s -> %s", k, va, vb))
+		}
+	}
+	return changes
+}
+
+// diffLines reports the lines only in a, then the lines only in b, ignoring
+// order and blank lines
+func diffLines(a, b string) []string {
+	count := func(text string) map[string]int {
+		lines := map[string]int{}
+		for _, l := range strings.Split(text, "\n") {
+			if l = strings.TrimRight(l, " \t\r"); l != "" {
+				lines[l]++
+			}
+		}
+		return lines
+	}
+	inA, inB := count(a), count(b)
+	changes := []string{}
+	for _, l := range strings.Split(a, "\n") {
+		l = strings.TrimRight(l, " \t\r")
+		if inA[l] > inB[l] {
+			inA[l]--
+			changes = append(changes, "- "+l)
+		}
+	}
+	inA = count(a)
+	for _, l := range strings.Split(b, "\n") {
+		l = strings.TrimRight(l, " \t\r")
+		if inB[l] > inA[l] {
+			inB[l]--
+			changes = append(changes, "+ "+l)
+		}
+	}
+	return changes
+}
+
+// parseDpkg maps installed packages in dpkg -l output to their versions
+func parseDpkg(text string) map[string]string {
+	packages := map[string]string{}
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && len(fields[0]) <= 3 && strings.HasPrefix(fields[0], "i") {
+			packages[fields[1]] = fields[2]
+		}
+	}
+	return packages
+}
+
+// parseRPM maps packages in rpm -qa output, e.g.
+// openssl-libs-1.1.1k-6.el8.x86_64, to their version and release
+func parseRPM(text string) map[string]string {
+	packages := map[string]string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		release := strings.LastIndex(line, "-")
+		if release <= 0 {
+			continue
+		}
+		version := strings.LastIndex(line[:release], "-")
+		if version <= 0 {
+			continue
+		}
+		packages[line[:version]] = line[version+1:]
+	}
+	return packages
+}
+
+// parsePkgInfo maps packages in FreeBSD pkg info output, e.g.
+// curl-7.64.0 <description>, to their versions
+func parsePkgInfo(text string) map[string]string {
+	packages := map[string]string{}
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if i := strings.LastIndex(fields[0], "-"); i > 0 {
+			packages[fields[0][:i]] = fields[0][i+1:]
+		}
+	}
+	return packages
+}
+
+// parseSysctl maps the settings in sysctl -a output or sysctl.conf, which
+// use "key = value" on Linux and "key: value" on the BSDs
+func parseSysctl(text string) map[string]string {
+	settings := map[string]string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexAny(line, "=:")
+		if i <= 0 {
+			continue
+		}
+		settings[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+	}
+	return settings
+}
+
+// parseMounts maps each mount point in /proc/mounts to its device, type and
+// options
+func parseMounts(text string) map[string]string {
+	mounts := map[string]string{}
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 4 {
+			mounts[fields[1]] = strings.Join([]string{fields[0], fields[2], fields[3]}, " ")
+		}
+	}
+	return mounts
+}
+
+// flattenJSON maps every value in a JSON document to its path, e.g.
+// Config.Datacenter; volatile top level keys are left out and a document
+// which does not parse, such as a rover note, is kept whole
+func flattenJSON(text string) map[string]string {
+	values := map[string]string{}
+	var doc interface{}
+	if err := json.Unmarshal([]byte(text), &doc); err != nil {
+		values["(text)"] = strings.TrimSpace(text)
+		return values
+	}
+	if m, ok := doc.(map[string]interface{}); ok {
+		for _, k := range volatileJSONKeys {
+			delete(m, k)
+		}
+	}
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			for k, x := range t {
+				if prefix != "" {
+					k = prefix + "." + k
+				}
+				walk(k, x)
+			}
+		case []interface{}:
+			for i, x := range t {
+				walk(prefix+"["+strconv.Itoa(i)+"]", x)
+			}
+		default:
+			b, _ := json.Marshal(t)
+			values[prefix] = string(b)
+		}
+	}
+	walk("", doc)
+	return values
+}
+
+// DiffCommand describes diff related fields
+type DiffCommand struct {
+	All      bool
+	MaxLines int
+	UI       cli.Ui
+}
+
+// Help output
+func (c *DiffCommand) Help() string {
+	helpText := `
+Usage: rover diff [options] <archive> <archive> [<file> ...]
+	Compare two rover archives file by file: product versions, packages,
+	sysctl settings, mounts, limits and API configuration are compared by
+	key and other outputs line by line. File arguments, which may be
+	patterns like system/*, limit the comparison to those files
+
+General Options:
+  -all			Include volatile outputs such as date, top and vmstat [default: false]
+  -max-lines=<n>	Maximum changes shown per file, 0 for all [default: 20]
+`
+
+	return strings.TrimSpace(helpText)
+}
+
+// Run command
+func (c *DiffCommand) Run(args []string) int {
+	cmdFlags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.BoolVar(&c.All, "all", false, "Include volatile outputs")
+	cmdFlags.IntVar(&c.MaxLines, "max-lines", 20, "Maximum changes shown per file")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+	if cmdFlags.NArg() < 2 {
+		c.UI.Error(c.Help())
+		return 1
+	}
+	names := cmdFlags.Args()[:2]
+	patterns := cmdFlags.Args()[2:]
+	bundles := make([]*ArchiveBundle, 2)
+	for i, n := range names {
+		z, err := OpenArchiveBundle(n)
+		if err != nil {
+			c.UI.Error(err.Error())
+			return 1
+		}
+		defer z.Close()
+		bundles[i] = z
+	}
+	a, b := bundles[0], bundles[1]
+	c.UI.Output(fmt.Sprintf("Comparing %s (%s) with %s (%s)", names[0], a.Root, names[1], b.Root))
+
+	// Product versions
+	modules := []string{}
+	for _, z := range bundles {
+		for _, n := range z.Names() {
+			if i := strings.Index(n, "/"); i > 0 && !containsString(modules, n[:i]) {
+				modules = append(modules, n[:i])
+			}
+		}
+	}
+	sort.Strings(modules)
+	va, vb := bundleVersions(a, modules), bundleVersions(b, modules)
+	if changes := diffMaps(va, vb); len(changes) > 0 {
+		c.UI.Output("\nVersions:")
+		c.outputChanges(changes)
+	}
+
+	// Files present in only one archive, then changed files
+	inA, inB := map[string]bool{}, map[string]bool{}
+	all := []string{}
+	for _, n := range a.Names() {
+		inA[n] = true
+		all = append(all, n)
+	}
+	for _, n := range b.Names() {
+		inB[n] = true
+		if !inA[n] {
+			all = append(all, n)
+		}
+	}
+	sort.Strings(all)
+	onlyA, onlyB, both := []string{}, []string{}, []string{}
+	ignored := 0
+	for _, n := range all {
+		if !matchAny(patterns, n) {
+			continue
+		}
+		// Volatile files are compared when asked for with -all or by name
+		if !c.All && len(patterns) == 0 && matchAny(volatileFiles, n) {
+			ignored++
+			continue
+		}
+		switch {
+		case inA[n] && inB[n]:
+			both = append(both, n)
+		case inA[n]:
+			onlyA = append(onlyA, n)
+		default:
+			onlyB = append(onlyB, n)
+		}
+	}
+	for i, files := range [][]string{onlyA, onlyB} {
+		if len(files) > 0 {
+			c.UI.Output(fmt.Sprintf("\nOnly in %s:", names[i]))
+			for _, n := range files {
+				c.UI.Output("  " + n)
+			}
+		}
+	}
+
+	changed := 0
+	for _, n := range both {
+		da, errA := a.ReadFile(n)
+		db, errB := b.ReadFile(n)
+		if errA != nil || errB != nil {
+			c.UI.Warn(fmt.Sprintf("Cannot read %s from both archives", n))
+			continue
+		}
+		if string(da) == string(db) {
+			continue
+		}
+		kind, diff := "", differ(diffLines)
+		for _, d := range outputDiffers {
+			if ok, _ := path.Match(d.pattern, n); ok {
+				kind, diff = " ("+d.kind+")", d.diff
+				break
+			}
+		}
+		changes := diff(string(da), string(db))
+		if len(changes) == 0 {
+			continue
+		}
+		changed++
+		c.UI.Output(fmt.Sprintf("\n%s%s:", n, kind))
+		c.outputChanges(changes)
+	}
+
+	summary := fmt.Sprintf("\n%d file(s) differ, %d only in %s, %d only in %s.", changed, len(onlyA), names[0], len(onlyB), names[1])
+	if ignored > 0 {
+		summary += fmt.Sprintf(" %d volatile file(s) were skipped; use -all to compare them.", ignored)
+	}
+	c.UI.Output(summary)
+	return 0
+}
+
+// outputChanges prints changes up to the -max-lines limit
+func (c *DiffCommand) outputChanges(changes []string) {
+	for i, change := range changes {
+		if c.MaxLines > 0 && i == c.MaxLines {
+			c.UI.Output(fmt.Sprintf("  ... %d more change(s)", len(changes)-i))
+			return
+		}
+		c.UI.Output("  " + change)
+	}
+}
+
+// Synopsis output
+func (c *DiffCommand) Synopsis() string {
+	return "Compare two rover archives"
+}
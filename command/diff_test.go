@@ -0,0 +1,83 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/pierrre/archivefile/zip"
+)
+
+func TestDiff(t *testing.T) {
+	dir := writeBundle(t, map[string]string{
+		"before/system/date.txt": "Mon Mar 25 10:00:00 UTC 2019\n",
+		"before/system/dpkg.txt": `Desired=Unknown/Install/Remove/Purge/Hold
+||/ Name           Version        Architecture Description
++++-==============-==============-============-=================================
+ii  libssl1.1      1.1.1b-1       amd64        Secure Sockets Layer toolkit
+ii  ntp            1:4.2.8p12     amd64        Network Time Protocol daemon
+rc  oldpkg         1.0            amd64        Removed with config left behind
+`,
+		"before/system/sysctl.txt":             "vm.swappiness = 60\nnet.core.somaxconn = 128\n",
+		"before/system/proc_mounts.txt":        "/dev/sda1 / ext4 rw,relatime 0 0\n/dev/sdb1 /opt/consul ext4 rw 0 0\n",
+		"before/system/file_etc_hosts.txt":     "127.0.0.1 localhost\n10.0.0.5 consul-1\n",
+		"before/consul/consul_version.txt":     "Consul v1.4.2\n",
+		"before/consul/consul_agent_self.json": `{"Config": {"Datacenter": "dc1", "Version": "1.4.2"}, "Stats": {"runtime": {"goroutines": "71"}}}`,
+		"after/system/date.txt":                "Tue Mar 26 11:00:00 UTC 2019\n",
+		"after/system/dpkg.txt": `ii  libssl1.1      1.1.1c-1       amd64        Secure Sockets Layer toolkit
+ii  chrony         3.4-4          amd64        Versatile implementation of NTP
+`,
+		"after/system/sysctl.txt":             "net.core.somaxconn = 128\nvm.swappiness = 1\n",
+		"after/system/proc_mounts.txt":        "/dev/sdb1 /opt/consul ext4 rw,noatime 0 0\n/dev/sda1 / ext4 rw,relatime 0 0\n",
+		"after/system/file_etc_hosts.txt":     "10.0.0.5 consul-1\n127.0.0.1 localhost\n",
+		"after/system/proc_consul_limits.txt": "Limit                     Soft Limit           Hard Limit           Units\nMax open files            65536                65536                files\n",
+		"after/consul/consul_version.txt":     "Consul v1.4.3\n",
+		"after/consul/consul_agent_self.json": `{"Config": {"Datacenter": "dc1", "Version": "1.4.3"}, "Stats": {"runtime": {"goroutines": "93"}}}`,
+	})
+	defer os.RemoveAll(dir)
+	archives := []string{}
+	for _, host := range []string{"before", "after"} {
+		archive := filepath.Join(dir, "rover-"+host+".zip")
+		if err := zip.ArchiveFile(filepath.Join(dir, host), archive, nil); err != nil {
+			t.Fatal(err)
+		}
+		archives = append(archives, archive)
+	}
+
+	ui := new(cli.MockUi)
+	c := &DiffCommand{UI: ui}
+	if code := c.Run(archives); code != 0 {
+		t.Fatalf("diff failed: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	out := ui.OutputWriter.String()
+	for _, want := range []string{
+		"~ consul: 1.4.2 -> 1.4.3",
+		"system/dpkg.txt (packages):\n  + chrony 3.4-4\n  ~ libssl1.1: 1.1.1b-1 -> 1.1.1c-1\n  - ntp 1:4.2.8p12\n",
+		"system/sysctl.txt (settings):\n  ~ vm.swappiness: 60 -> 1\n",
+		"~ /opt/consul: /dev/sdb1 ext4 rw -> /dev/sdb1 ext4 rw,noatime",
+		"consul/consul_agent_self.json (configuration):\n  ~ Config.Version: \"1.4.2\" -> \"1.4.3\"\n\n",
+		"Only in " + archives[1] + ":\n  system/proc_consul_limits.txt\n",
+		"1 volatile file(s) were skipped",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in diff:\n%s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"oldpkg", "file_etc_hosts", "date.txt", "goroutines"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("unexpected %q in diff:\n%s", unwanted, out)
+		}
+	}
+
+	// Naming a volatile file compares it
+	ui = new(cli.MockUi)
+	c = &DiffCommand{UI: ui}
+	if code := c.Run(append(archives, "system/date.txt")); code != 0 {
+		t.Fatalf("diff failed: %d", code)
+	}
+	if out := ui.OutputWriter.String(); !strings.Contains(out, "+ Tue Mar 26 11:00:00 UTC 2019") || strings.Contains(out, "dpkg") {
+		t.Fatalf("expected only the date to be compared, got:\n%s", out)
+	}
+}
@@ -0,0 +1,78 @@
+//go:build !windows
+// +build !windows
+
+package command
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestTimeoutKillsProcessGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rover-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	run, err := OpenRun(dir, RunReuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer run.Close()
+	if err := os.MkdirAll(filepath.Join(run.HostDir(), "test"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// The shell starts a child of its own which outlives the deadline
+	// unless the whole group is killed
+	marker := filepath.Join(dir, "marker")
+	set := NewTaskSet("test", Task{Output: "group", Command: "sh",
+		Args: []string{"-c", "(sleep 1; touch " + marker + ") & sleep 5"}, Timeout: 200 * time.Millisecond})
+	results := ExecuteTasks(context.Background(), hclog.NewNullLogger(), set, TaskEnv{Run: run, OS: Linux})
+	if len(results) != 1 || results[0].Status != StatusTimeout {
+		t.Fatalf("expected the task to time out, got %+v", results)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("expected the background child to be killed, got %v", err)
+	}
+}
+
+func TestTimeoutWaitDelay(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skipf("setsid is needed to leave the process group: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "rover-setsid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A grandchild in a session of its own escapes the group kill and
+	// keeps the output open; Wait still returns shortly after the deadline
+	pidFile := filepath.Join(dir, "pid")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	var out bytes.Buffer
+	execRunner{}.Run(ctx, &out, "sh", "-c", "setsid sh -c 'echo $$ > "+pidFile+"; exec sleep 30' & sleep 10")
+	if elapsed := time.Since(start); elapsed > runnerWaitDelay+2*time.Second {
+		t.Errorf("expected the run to end soon after the deadline, took %s", elapsed)
+	}
+	if b, err := ioutil.ReadFile(pidFile); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package command
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on Windows where context cancellation kills
+// the process itself
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// processAlive tells whether the process pid is running; on Windows the
+// process can only be found while it exists
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,319 @@
+// Package command for encrypt
+// Encrypt streams an archive to the OpenPGP or age public keys of its
+// recipients as it is written, so that a bundle is only ever readable by
+// them once it leaves the host and its plaintext never reaches the disk
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+	// Keys which state no hash preferences default to RIPEMD-160, which
+	// OpenPGP requires to be available even when nothing is signed
+	_ "golang.org/x/crypto/ripemd160"
+)
+
+const (
+	// PGPSuffix is appended to the name of an archive encrypted with OpenPGP
+	PGPSuffix = ".gpg"
+	// AgeSuffix is appended to the name of an archive encrypted with age
+	AgeSuffix = ".age"
+	// RecipientsSuffix is appended to the name of an encrypted archive to
+	// name the unencrypted file listing who can decrypt it
+	RecipientsSuffix = ".recipients.json"
+	// PGPPassphraseEnv holds the passphrase of the private key used by
+	// rover decrypt, which otherwise asks for it
+	PGPPassphraseEnv    = "ROVER_PGP_PASSPHRASE"
+	encryptToDescr      = "OpenPGP public key file to encrypt the archive to; repeat for each recipient"
+	recipientDescr      = "age recipient to encrypt the archive to, e.g. age1...; repeat for each recipient"
+	recipientsFileDescr = "File of age recipients, one per line, to encrypt the archive to"
+	// ageHeader starts every binary age file
+	ageHeader = "age-encryption.org/"
+)
+
+// Encryption types
+const (
+	EncryptionPGP = "openpgp"
+	EncryptionAge = "age"
+)
+
+// Recipient is someone an archive is encrypted to
+type Recipient struct {
+	// Fingerprint is the OpenPGP key fingerprint or the age recipient
+	Fingerprint string `json:"fingerprint"`
+	// Name is the primary user ID of an OpenPGP key or the comment above
+	// an age recipient in its recipients file
+	Name string `json:"name,omitempty"`
+}
+
+// Recipients is the unencrypted sidecar written next to an encrypted
+// archive, so that whoever receives it knows who can open it
+type Recipients struct {
+	Archive    string      `json:"archive"`
+	Encryption string      `json:"encryption"`
+	Created    time.Time   `json:"created"`
+	Recipients []Recipient `json:"recipients"`
+}
+
+// archiveEncryption encrypts an archive as it is written
+type archiveEncryption struct {
+	Type       string
+	Suffix     string
+	Recipients []Recipient
+	// wrap returns a writer which encrypts to w; closing it completes the
+	// encrypted file but does not close w
+	wrap func(w io.Writer, name string) (io.WriteCloser, error)
+}
+
+// newArchiveEncryption reads the OpenPGP key files or the age recipients
+// and recipients files given to rover archive; it returns nil when none
+// were given
+func newArchiveEncryption(keyFiles, ageRecipients, ageRecipientsFiles []string) (*archiveEncryption, error) {
+	if len(keyFiles) > 0 && len(ageRecipients)+len(ageRecipientsFiles) > 0 {
+		return nil, fmt.Errorf("cannot encrypt to both OpenPGP keys and age recipients; choose one")
+	}
+	if len(keyFiles) > 0 {
+		keys, err := readPGPKeys(keyFiles)
+		if err != nil {
+			return nil, err
+		}
+		return &archiveEncryption{
+			Type:       EncryptionPGP,
+			Suffix:     PGPSuffix,
+			Recipients: pgpRecipients(keys),
+			wrap: func(w io.Writer, name string) (io.WriteCloser, error) {
+				return pgpEncrypt(w, keys, name)
+			},
+		}, nil
+	}
+	if len(ageRecipients)+len(ageRecipientsFiles) > 0 {
+		recipients, described, err := readAgeRecipients(ageRecipients, ageRecipientsFiles)
+		if err != nil {
+			return nil, err
+		}
+		return &archiveEncryption{
+			Type:       EncryptionAge,
+			Suffix:     AgeSuffix,
+			Recipients: described,
+			wrap: func(w io.Writer, name string) (io.WriteCloser, error) {
+				return age.Encrypt(w, recipients...)
+			},
+		}, nil
+	}
+	return nil, nil
+}
+
+// String lists the recipients
+func (e *archiveEncryption) String() string {
+	names := []string{}
+	for _, r := range e.Recipients {
+		names = append(names, strings.TrimSpace(r.Fingerprint+" "+r.Name))
+	}
+	return strings.Join(names, ", ")
+}
+
+// writeRecipients writes the recipients sidecar of the archive at p
+func (e *archiveEncryption) writeRecipients(p string) error {
+	r := Recipients{
+		Archive:    filepath.Base(p),
+		Encryption: e.Type,
+		Created:    time.Now().UTC(),
+		Recipients: e.Recipients,
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p+RecipientsSuffix, append(b, '\n'), 0644)
+}
+
+// stringsFlag is a flag which may be given more than once
+type stringsFlag []string
+
+// String joins the values
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set appends a value
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// readPGPKeys reads the keys in each file, armored or binary
+func readPGPKeys(paths []string) (openpgp.EntityList, error) {
+	keys := openpgp.EntityList{}
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read key file %s with error %v", p, err)
+		}
+		var entities openpgp.EntityList
+		if bytes.HasPrefix(bytes.TrimSpace(b), []byte("-----BEGIN")) {
+			entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(b))
+		} else {
+			entities, err = openpgp.ReadKeyRing(bytes.NewReader(b))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse key file %s with error %v", p, err)
+		}
+		if len(entities) == 0 {
+			return nil, fmt.Errorf("no keys found in key file %s", p)
+		}
+		keys = append(keys, entities...)
+	}
+	return keys, nil
+}
+
+// pgpEncrypt returns a writer which encrypts what is written to it to
+// each of the recipients and writes the result to w; closing it completes
+// the message but does not close w
+func pgpEncrypt(w io.Writer, recipients openpgp.EntityList, name string) (io.WriteCloser, error) {
+	hints := &openpgp.FileHints{IsBinary: true, FileName: name, ModTime: time.Now()}
+	config := &packet.Config{DefaultCipher: packet.CipherAES256}
+	return openpgp.Encrypt(w, recipients, nil, hints, config)
+}
+
+// pgpRecipients describes each recipient by its fingerprint and primary
+// user ID
+func pgpRecipients(recipients openpgp.EntityList) []Recipient {
+	described := []Recipient{}
+	for _, e := range recipients {
+		primary := ""
+		for name, id := range e.Identities {
+			if id.SelfSignature != nil && id.SelfSignature.IsPrimaryId != nil && *id.SelfSignature.IsPrimaryId {
+				primary = name
+				break
+			}
+			if primary == "" || name < primary {
+				primary = name
+			}
+		}
+		described = append(described, Recipient{Fingerprint: fmt.Sprintf("%X", e.PrimaryKey.Fingerprint), Name: primary})
+	}
+	return described
+}
+
+// readAgeRecipients parses the age recipients given and those in each
+// recipients file, in which blank lines are ignored and a # comment names
+// the recipient which follows it
+func readAgeRecipients(given, files []string) ([]age.Recipient, []Recipient, error) {
+	recipients := []age.Recipient{}
+	described := []Recipient{}
+	add := func(s, name, source string) error {
+		r, err := age.ParseX25519Recipient(s)
+		if err != nil {
+			return fmt.Errorf("invalid age recipient %q%s with error %v", s, source, err)
+		}
+		recipients = append(recipients, r)
+		described = append(described, Recipient{Fingerprint: r.String(), Name: name})
+		return nil
+	}
+	for _, s := range given {
+		if err := add(s, "", ""); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, p := range files {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot read recipients file %s with error %v", p, err)
+		}
+		comment := ""
+		count := 0
+		for n, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			switch {
+			case line == "":
+				comment = ""
+			case strings.HasPrefix(line, "#"):
+				comment = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+			default:
+				if err := add(line, comment, fmt.Sprintf(" at %s:%d", p, n+1)); err != nil {
+					return nil, nil, err
+				}
+				comment = ""
+				count++
+			}
+		}
+		if count == 0 {
+			return nil, nil, fmt.Errorf("no recipients found in recipients file %s", p)
+		}
+	}
+	return recipients, described, nil
+}
+
+// readAgeIdentities reads the age identities in each file, such as those
+// written by age-keygen
+func readAgeIdentities(paths []string) ([]age.Identity, error) {
+	identities := []age.Identity{}
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read identity file %s with error %v", p, err)
+		}
+		ids, err := age.ParseIdentities(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse identity file %s with error %v", p, err)
+		}
+		identities = append(identities, ids...)
+	}
+	return identities, nil
+}
+
+// pgpDecrypt returns a reader of the message in r, decrypted with one of
+// keys; prompt supplies the passphrase when the private key is protected.
+// The integrity of the message is checked when the reader reaches EOF
+func pgpDecrypt(r io.Reader, keys openpgp.EntityList, prompt func() (string, error)) (io.Reader, error) {
+	// Accept armored messages as well, which is what gpg --armor produces
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(10); bytes.HasPrefix(head, []byte("-----BEGIN")) {
+		block, err := armor.Decode(br)
+		if err != nil {
+			return nil, err
+		}
+		r = block.Body
+	} else {
+		r = br
+	}
+	asked := false
+	md, err := openpgp.ReadMessage(r, keys, func(candidates []openpgp.Key, symmetric bool) ([]byte, error) {
+		if symmetric {
+			return nil, fmt.Errorf("the archive is encrypted with a passphrase rather than to a key")
+		}
+		if asked {
+			return nil, fmt.Errorf("incorrect passphrase for the private key")
+		}
+		asked = true
+		passphrase, err := prompt()
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range candidates {
+			if k.PrivateKey != nil && k.PrivateKey.Encrypted {
+				// A wrong passphrase leaves the key encrypted, which is
+				// reported when ReadMessage asks again
+				k.PrivateKey.Decrypt([]byte(passphrase))
+			}
+		}
+		return nil, nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return md.UnverifiedBody, nil
+}
@@ -0,0 +1,229 @@
+package command
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/mitchellh/cli"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// writePGPKey writes a new key pair as an armored public key and a binary
+// private key, returning their paths
+func writePGPKey(t *testing.T, dir, name string) (string, string) {
+	e, err := openpgp.NewEntity(name, "", name+"@example.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := filepath.Join(dir, name+".asc")
+	f, err := os.Create(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := armor.Encode(f, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	f.Close()
+	private := filepath.Join(dir, name+".key")
+	f, err = os.Create(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SerializePrivate(f, nil); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	return public, private
+}
+
+func TestEncryptArchive(t *testing.T) {
+	dir := writeBundle(t, map[string]string{})
+	defer os.RemoveAll(dir)
+	alicePublic, alicePrivate := writePGPKey(t, dir, "alice")
+	bobPublic, bobPrivate := writePGPKey(t, dir, "bob")
+	_, evePrivate := writePGPKey(t, dir, "eve")
+
+	ui := new(cli.MockUi)
+	a := &ArchiveCommand{UI: ui}
+	if code := a.Run([]string{"-output-dir", dir, "-path", dir, "-encrypt-to", alicePublic, "-encrypt-to", bobPublic}); code != 0 {
+		t.Fatalf("archive failed: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if !strings.HasSuffix(a.OutFile, ".zip.gpg") {
+		t.Fatalf("expected an encrypted archive, got %s", a.OutFile)
+	}
+	if _, err := os.Stat(strings.TrimSuffix(a.OutFile, PGPSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("expected no unencrypted archive, got %v", err)
+	}
+	r := readRecipients(t, a.OutFile)
+	if r.Encryption != EncryptionPGP || len(r.Recipients) != 2 || r.Recipients[0].Name != "alice <alice@example.com>" || len(r.Recipients[1].Fingerprint) != 40 {
+		t.Fatalf("unexpected recipients %+v", r)
+	}
+
+	// Either recipient can decrypt it
+	for _, key := range []string{alicePrivate, bobPrivate} {
+		out := filepath.Join(dir, filepath.Base(key)+".zip")
+		ui = new(cli.MockUi)
+		d := &DecryptCommand{UI: ui}
+		if code := d.Run([]string{"-key", key, "-out", out, a.OutFile}); code != 0 {
+			t.Fatalf("decrypt with %s failed: %d\n\n%s", key, code, ui.ErrorWriter.String())
+		}
+		z, err := OpenArchiveBundle(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := z.ReadFile("log/rover.log"); err != nil {
+			t.Errorf("expected the archived log in %s: %v", out, err)
+		}
+		z.Close()
+	}
+
+	// Anyone else cannot, and nothing is written
+	out := filepath.Join(dir, "eve.zip")
+	d := &DecryptCommand{UI: new(cli.MockUi)}
+	if code := d.Run([]string{"-key", evePrivate, "-out", out, a.OutFile}); code != 1 {
+		t.Fatalf("expected decrypt with another key to fail, got %d", code)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("expected no output from a failed decrypt, got %v", err)
+	}
+
+	// A public key cannot decrypt
+	d = &DecryptCommand{UI: new(cli.MockUi)}
+	if code := d.Run([]string{"-key", alicePublic, "-out", out, a.OutFile}); code != 1 {
+		t.Fatalf("expected decrypt with a public key to fail, got %d", code)
+	}
+}
+
+// readRecipients reads the recipients sidecar of an encrypted archive
+func readRecipients(t *testing.T, archive string) Recipients {
+	r := Recipients{}
+	b, err := ioutil.ReadFile(archive + RecipientsSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Archive != filepath.Base(archive) {
+		t.Fatalf("expected recipients of %s, got %s", filepath.Base(archive), r.Archive)
+	}
+	return r
+}
+
+func TestEncryptArchiveAge(t *testing.T) {
+	dir := writeBundle(t, map[string]string{})
+	defer os.RemoveAll(dir)
+	identities := []*age.X25519Identity{}
+	for i := 0; i < 3; i++ {
+		id, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		identities = append(identities, id)
+	}
+	identityFile := filepath.Join(dir, "key.txt")
+	if err := ioutil.WriteFile(identityFile, []byte("# created: 2019-03-22\n"+identities[1].String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	recipientsFile := filepath.Join(dir, "support.txt")
+	if err := ioutil.WriteFile(recipientsFile, []byte("# Support on call\n"+identities[1].Recipient().String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ui := new(cli.MockUi)
+	a := &ArchiveCommand{UI: ui}
+	if code := a.Run([]string{"-output-dir", dir, "-path", dir, "-recipient", identities[0].Recipient().String(), "-recipients-file", recipientsFile}); code != 0 {
+		t.Fatalf("archive failed: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if !strings.HasSuffix(a.OutFile, ".zip.age") {
+		t.Fatalf("expected an age encrypted archive, got %s", a.OutFile)
+	}
+	r := readRecipients(t, a.OutFile)
+	if r.Encryption != EncryptionAge || len(r.Recipients) != 2 ||
+		r.Recipients[0].Fingerprint != identities[0].Recipient().String() ||
+		r.Recipients[1].Name != "Support on call" {
+		t.Fatalf("unexpected recipients %+v", r)
+	}
+
+	out := filepath.Join(dir, "decrypted.zip")
+	ui = new(cli.MockUi)
+	d := &DecryptCommand{UI: ui}
+	if code := d.Run([]string{"-identity", identityFile, "-out", out, a.OutFile}); code != 0 {
+		t.Fatalf("decrypt failed: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	z, err := OpenArchiveBundle(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	if _, err := z.ReadFile("log/rover.log"); err != nil {
+		t.Errorf("expected the archived log: %v", err)
+	}
+
+	// Someone who is not a recipient cannot decrypt it
+	if err := ioutil.WriteFile(identityFile, []byte(identities[2].String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d = &DecryptCommand{UI: new(cli.MockUi)}
+	if code := d.Run([]string{"-identity", identityFile, "-out", filepath.Join(dir, "other.zip"), a.OutFile}); code != 1 {
+		t.Fatalf("expected decrypt with another identity to fail, got %d", code)
+	}
+
+	// Encrypting to both kinds of key at once is refused
+	a = &ArchiveCommand{UI: new(cli.MockUi)}
+	if code := a.Run([]string{"-output-dir", dir, "-path", dir, "-keep-data", "-recipient", identities[0].Recipient().String(), "-encrypt-to", recipientsFile}); code != 1 {
+		t.Fatalf("expected mixed recipients to be refused, got %d", code)
+	}
+}
+
+func TestDecryptOutputName(t *testing.T) {
+	dir := writeBundle(t, map[string]string{})
+	defer os.RemoveAll(dir)
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(dir, "key.txt")
+	if err := ioutil.WriteFile(identityFile, []byte(id.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ui := new(cli.MockUi)
+	a := &ArchiveCommand{UI: ui}
+	if code := a.Run([]string{"-output-dir", dir, "-path", dir, "-format", FormatTarZst, "-recipient", id.Recipient().String()}); code != 0 {
+		t.Fatalf("archive failed: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	// Without a suffix to remove, the output is named for the format of
+	// the decrypted archive
+	renamed := filepath.Join(dir, "bundle")
+	if err := os.Rename(a.OutFile, renamed); err != nil {
+		t.Fatal(err)
+	}
+	ui = new(cli.MockUi)
+	d := &DecryptCommand{UI: ui}
+	if code := d.Run([]string{"-identity", identityFile, renamed}); code != 0 {
+		t.Fatalf("decrypt failed: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if d.OutFile != renamed+"."+FormatTarZst {
+		t.Fatalf("expected %s.%s, got %s", renamed, FormatTarZst, d.OutFile)
+	}
+	z, err := OpenArchiveBundle(d.OutFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	if z.Format != FormatTarZst {
+		t.Errorf("expected a %s archive, got %s", FormatTarZst, z.Format)
+	}
+}
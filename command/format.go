@@ -0,0 +1,318 @@
+// Package command for archive formats
+// Formats writes a host directory as a zip, gzip compressed tar or zstd
+// compressed tar archive, streaming each file in turn and keeping file
+// modes and modification times, and extracts any of them again
+package command
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Archive formats
+const (
+	FormatZip    = "zip"
+	FormatTarGz  = "tar.gz"
+	FormatTarZst = "tar.zst"
+	formatDescr  = "Archive format: zip, tar.gz or tar.zst"
+)
+
+var archiveFormats = []string{FormatZip, FormatTarGz, FormatTarZst}
+
+// Leading bytes of each format, by which archives are recognized whatever
+// they are called
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte("\x1f\x8b")
+	zstdMagic = []byte("\x28\xb5\x2f\xfd")
+)
+
+// archiveEntry is a file in an archive
+type archiveEntry struct {
+	Name string
+	Size int64
+}
+
+// writeArchive writes the files under dir to w in format, each named by
+// its path relative to the parent of dir, so that the archive holds dir
+// itself as rover archive always has. The manifest is written last, with
+// the SHA-256 hash of every other file as it was written; when dir has no
+// manifest, one holding only the hashes is written
+func writeArchive(dir string, w io.Writer, format string) error {
+	var add func(name string, mode os.FileMode, mtime time.Time, size int64, r io.Reader) error
+	var finish func() error
+	switch format {
+	case FormatZip:
+		zw := zip.NewWriter(w)
+		add = func(name string, mode os.FileMode, mtime time.Time, size int64, r io.Reader) error {
+			hdr := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: mtime}
+			hdr.SetMode(mode)
+			fw, err := zw.CreateHeader(hdr)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(fw, r)
+			return err
+		}
+		finish = zw.Close
+	case FormatTarGz, FormatTarZst:
+		var cw io.WriteCloser
+		if format == FormatTarGz {
+			cw = gzip.NewWriter(w)
+		} else {
+			zw, err := zstd.NewWriter(w)
+			if err != nil {
+				return err
+			}
+			cw = zw
+		}
+		tw := tar.NewWriter(cw)
+		add = func(name string, mode os.FileMode, mtime time.Time, size int64, r io.Reader) error {
+			hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: int64(mode.Perm()), Size: size, ModTime: mtime}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			_, err := io.Copy(tw, r)
+			return err
+		}
+		finish = func() error {
+			if err := tw.Close(); err != nil {
+				return err
+			}
+			return cw.Close()
+		}
+	default:
+		return fmt.Errorf("unknown archive format %q; use one of %s", format, strings.Join(archiveFormats, ", "))
+	}
+
+	dir = filepath.Clean(dir)
+	root := filepath.Base(dir)
+	manifest := filepath.Join(dir, ManifestFile)
+	files := []FileHash{}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() || p == manifest {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// Hash what is written rather than what is on disk, in case the
+		// file grows while it is archived
+		h := sha256.New()
+		lr := &io.LimitedReader{R: f, N: info.Size()}
+		if err := add(path.Join(root, filepath.ToSlash(rel)), info.Mode(), info.ModTime(), info.Size(), io.TeeReader(lr, h)); err != nil {
+			return err
+		}
+		files = append(files, FileHash{Name: filepath.ToSlash(rel), SHA256: hex.EncodeToString(h.Sum(nil)), Bytes: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m, err := ReadManifest(dir)
+	mode, mtime := os.FileMode(0644), time.Now()
+	if os.IsNotExist(err) {
+		m = &Manifest{HostName: root, Updated: mtime.UTC()}
+	} else if err != nil {
+		return err
+	} else if info, err := os.Stat(manifest); err == nil {
+		mode, mtime = info.Mode(), info.ModTime()
+	}
+	m.Files = files
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode %s with error %v", ManifestFile, err)
+	}
+	b = append(b, '\n')
+	if err := add(path.Join(root, ManifestFile), mode, mtime, int64(len(b)), bytes.NewReader(b)); err != nil {
+		return err
+	}
+	return finish()
+}
+
+// archiveFormat tells the format of the archive at p from its contents
+func archiveFormat(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 4)
+	n, _ := io.ReadFull(f, head)
+	format, err := headFormat(head[:n])
+	if err != nil {
+		return "", fmt.Errorf("%s %v", p, err)
+	}
+	return format, nil
+}
+
+// headFormat tells the format of an archive from its leading bytes
+func headFormat(head []byte) (string, error) {
+	n := len(head)
+	switch {
+	case bytes.HasPrefix(head, zipMagic):
+		return FormatZip, nil
+	case bytes.HasPrefix(head, gzipMagic):
+		return FormatTarGz, nil
+	case bytes.HasPrefix(head, zstdMagic):
+		return FormatTarZst, nil
+	case bytes.HasPrefix(head, []byte(ageHeader[:4])), n > 0 && head[0]&0x80 != 0:
+		// OpenPGP packets always have the high bit of their tag set
+		return "", fmt.Errorf("is encrypted; use rover decrypt first")
+	}
+	return "", fmt.Errorf("is not a zip, tar.gz or tar.zst archive")
+}
+
+// entryPath returns where the archive entry name is extracted under dest,
+// refusing names which would land outside of it
+func entryPath(dest, name string) (string, error) {
+	clean := path.Clean(strings.Replace(name, "\\", "/", -1))
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("archive entry %q is outside of the archive", name)
+	}
+	return filepath.Join(dest, filepath.FromSlash(clean)), nil
+}
+
+// extractFile writes one archive entry under dest with its mode and
+// modification time; existing files are never overwritten
+func extractFile(dest, name string, info os.FileInfo, r io.Reader) error {
+	p, err := entryPath(dest, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(p, info.ModTime(), info.ModTime())
+}
+
+// extractArchive extracts the regular files of the archive at p under
+// dest, returning them in archive order
+func extractArchive(p, dest string) ([]archiveEntry, error) {
+	format, err := archiveFormat(p)
+	if err != nil {
+		return nil, err
+	}
+	entries := []archiveEntry{}
+	if format == FormatZip {
+		r, err := zip.OpenReader(p)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			err = extractFile(dest, f.Name, f.FileInfo(), rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, archiveEntry{Name: f.Name, Size: int64(f.UncompressedSize64)})
+		}
+		return entries, nil
+	}
+
+	tr, closer, err := openTar(p, format)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := extractFile(dest, hdr.Name, hdr.FileInfo(), tr); err != nil {
+			return nil, err
+		}
+		entries = append(entries, archiveEntry{Name: path.Clean(hdr.Name), Size: hdr.Size})
+	}
+}
+
+// tarCloser closes the decompressor and the file under a tar reader
+type tarCloser struct {
+	f  *os.File
+	dc func()
+}
+
+// Close closes the decompressor, then the file
+func (c tarCloser) Close() error {
+	c.dc()
+	return c.f.Close()
+}
+
+// openTar opens the tar archive at p, compressed as format, for reading
+// from start to end; the closer releases the decompressor and the file
+func openTar(p, format string) (*tar.Reader, io.Closer, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	var r io.Reader
+	var dc func()
+	switch format {
+	case FormatTarGz:
+		gr, err := gzip.NewReader(bufio.NewReader(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		r, dc = gr, func() { gr.Close() }
+	case FormatTarZst:
+		zr, err := zstd.NewReader(bufio.NewReader(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		r, dc = zr, zr.Close
+	default:
+		f.Close()
+		return nil, nil, fmt.Errorf("%s is not a tar archive", p)
+	}
+	return tar.NewReader(r), tarCloser{f: f, dc: dc}, nil
+}
@@ -0,0 +1,150 @@
+package command
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/cli"
+)
+
+func TestArchiveFormats(t *testing.T) {
+	dir := writeBundle(t, map[string]string{
+		"penguin/system/df.txt":             "Filesystem Size Used Avail Use% Mounted on\n",
+		"penguin/consul/consul_version.txt": "Consul v1.4.3\n",
+	})
+	defer os.RemoveAll(dir)
+	private := filepath.Join(dir, "penguin", "consul", "consul_version.txt")
+	if err := os.Chmod(private, 0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2019, 3, 22, 20, 22, 32, 0, time.UTC)
+	if err := os.Chtimes(private, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range archiveFormats {
+		archive := filepath.Join(dir, "rover-penguin."+format)
+		if err := createArchive(filepath.Join(dir, "penguin"), archive, format, nil, nil); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if got, err := archiveFormat(archive); err != nil || got != format {
+			t.Fatalf("expected %s to be recognized, got %q: %v", format, got, err)
+		}
+
+		a, err := OpenArchiveBundle(archive)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if a.Root != "penguin" || strings.Join(a.Names(), ",") != "consul/consul_version.txt,manifest.json,system/df.txt" {
+			t.Errorf("%s: unexpected root %q and names %v", format, a.Root, a.Names())
+		}
+		if b, err := a.ReadFile("consul/consul_version.txt"); err != nil || string(b) != "Consul v1.4.3\n" {
+			t.Errorf("%s: unexpected contents %q: %v", format, b, err)
+		}
+		if a.Size("system/df.txt") != 43 {
+			t.Errorf("%s: unexpected size %d", format, a.Size("system/df.txt"))
+		}
+		a.Close()
+
+		out := filepath.Join(dir, "extract-"+format)
+		ui := new(cli.MockUi)
+		c := &InspectCommand{UI: ui}
+		if code := c.Run([]string{"-extract", out, archive}); code != 0 {
+			t.Fatalf("%s: extract failed: %d\n\n%s", format, code, ui.ErrorWriter.String())
+		}
+		info, err := os.Stat(filepath.Join(out, "penguin", "consul", "consul_version.txt"))
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if info.Mode().Perm() != 0600 || !info.ModTime().Equal(mtime) {
+			t.Errorf("%s: expected mode 0600 and time %s, got %s and %s", format, mtime, info.Mode(), info.ModTime())
+		}
+		// Extracting again never overwrites what is there
+		if code := c.Run([]string{"-extract", out, archive}); code != 1 {
+			t.Errorf("%s: expected extracting over existing files to fail, got %d", format, code)
+		}
+	}
+}
+
+func TestArchiveBundleStreaming(t *testing.T) {
+	journal := strings.Repeat("Mar 22 20:22:32 penguin kernel: eth0: link up\n", bundleCacheFile/40)
+	dir := writeBundle(t, map[string]string{
+		"penguin/system/journalctl_system.txt": journal,
+		"penguin/system/df.txt":                "Filesystem Size Used Avail Use% Mounted on\n",
+	})
+	defer os.RemoveAll(dir)
+	// Tar archives are read where they are, with nothing extracted
+	tmp := filepath.Join(dir, "tmp")
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tmp)
+
+	for _, format := range []string{FormatTarGz, FormatTarZst} {
+		archive := filepath.Join(dir, "rover-penguin."+format)
+		if err := createArchive(filepath.Join(dir, "penguin"), archive, format, nil, nil); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		a, err := OpenArchiveBundle(archive)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if _, ok := a.cache["penguin/system/journalctl_system.txt"]; ok {
+			t.Errorf("%s: expected the journal to be streamed rather than kept in memory", format)
+		}
+		if b, err := a.ReadFile("system/journalctl_system.txt"); err != nil || string(b) != journal {
+			t.Errorf("%s: unexpected journal of %d bytes: %v", format, len(b), err)
+		}
+		if _, err := a.ReadFile("system/missing.txt"); !os.IsNotExist(err) {
+			t.Errorf("%s: expected a missing file not to exist, got %v", format, err)
+		}
+		walked := []string{}
+		err = a.Walk(func(name string, r io.Reader) error {
+			walked = append(walked, name)
+			_, err := io.Copy(ioutil.Discard, r)
+			return err
+		})
+		if err != nil || strings.Join(walked, ",") != "system/df.txt,system/journalctl_system.txt,manifest.json" {
+			t.Errorf("%s: unexpected walk %v: %v", format, walked, err)
+		}
+		a.Close()
+	}
+	if left, _ := ioutil.ReadDir(tmp); len(left) > 0 {
+		t.Errorf("expected nothing in the temporary directory, got %d file(s)", len(left))
+	}
+}
+
+func TestExtractArchiveOutside(t *testing.T) {
+	dir := writeBundle(t, map[string]string{})
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "evil.tar.gz")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	body := "owned\n"
+	if err := tw.WriteHeader(&tar.Header{Name: "penguin/../../evil.txt", Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte(body))
+	tw.Close()
+	gw.Close()
+	f.Close()
+
+	if _, err := extractArchive(archive, filepath.Join(dir, "out")); err == nil || !strings.Contains(err.Error(), "outside") {
+		t.Fatalf("expected an entry outside of the archive to be refused, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "evil.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing written outside of the destination, got %v", err)
+	}
+}
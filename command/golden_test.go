@@ -0,0 +1,303 @@
+package command
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+)
+
+// updateGolden rewrites the golden listings from the bundle the test makes:
+// go test ./command -run TestGoldenBundle -update
+var updateGolden = flag.Bool("update", false, "Update the golden bundle listings in testdata/golden")
+
+// goldenPIDs are the PIDs the fake pgrep reports for each module
+var goldenPIDs = map[string]string{Consul: "101", Nomad: "102", Vault: "103"}
+
+// goldenVersions are the versions the fake binaries report
+var goldenVersions = map[string]string{Consul: "Consul v1.4.3", Nomad: "Nomad v0.8.7 (21a2d93eecf018ad2209a5eab6aae6c359267933+CHANGES)", Vault: "Vault v1.0.3 ('85909e3373aa743c34a6a0ab59131f61fd9e8e43')"}
+
+// rootFS reads host files from below a fake root directory
+type rootFS string
+
+// Stat describes a file below the root
+func (fs rootFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(filepath.Join(string(fs), name))
+}
+
+// Open opens a file below the root
+func (fs rootFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(fs), name))
+}
+
+// writeFakeHost writes a fake root holding /proc entries for the module
+// processes and a few system files, and a bin directory with a fake for
+// every command the built in tasks run. The fakes print their command line,
+// except for pgrep, the version commands, and cat and ls, which run the real
+// commands on the paths below the fake root
+func writeFakeHost(t *testing.T, dir string) (root string, bin string) {
+	root = filepath.Join(dir, "root")
+	bin = filepath.Join(dir, "bin")
+	files := map[string]string{
+		"etc/os-release":      "NAME=\"Debian GNU/Linux\"\nVERSION_ID=\"9\"\n",
+		"var/log/syslog":      "Mar 22 20:22:32 penguin consul[101]: agent: Synced node info\n",
+		"run/systemd/system/": "",
+	}
+	for module, pid := range goldenPIDs {
+		files["proc/"+pid+"/limits"] = "Limit                     Soft Limit           Hard Limit           Units\nMax open files            65536                65536                files\n"
+		files["proc/"+pid+"/status"] = fmt.Sprintf("Name:\t%s\nState:\tS (sleeping)\nPid:\t%s\n", module, pid)
+		for _, fd := range []string{"0", "1", "2"} {
+			files["proc/"+pid+"/fd/"+fd] = ""
+		}
+	}
+	// Every path the tasks read with cat or list with ls exists
+	for _, module := range []string{"system", Consul, Nomad, Vault} {
+		c, err := Collectors.Lookup(module)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, task := range c.Tasks() {
+			for _, a := range task.Args {
+				if !strings.HasPrefix(a, "/") || strings.Contains(a, "{") {
+					continue
+				}
+				switch task.Command {
+				case "cat":
+					files[strings.TrimPrefix(a, "/")] = a + "\n"
+				case "ls":
+					files[strings.TrimPrefix(a, "/")+"/"] = ""
+				}
+			}
+		}
+	}
+	for name, data := range files {
+		p := filepath.Join(root, name)
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(p, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scripts := map[string]string{}
+	for _, module := range []string{"system", Consul, Nomad, Vault} {
+		c, err := Collectors.Lookup(module)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, task := range c.Tasks() {
+			if task.Command != "" {
+				scripts[task.Command] = "echo \"$(basename \"$0\") $*\"\n"
+			}
+		}
+	}
+	pgrep := "case \"$1\" in\n"
+	for module, pid := range goldenPIDs {
+		pgrep += fmt.Sprintf("%s) echo %s ;;\n", module, pid)
+		scripts[module] = fmt.Sprintf("if [ \"$1\" = version ]; then echo \"%s\"; else echo \"%s $*\"; fi\n", goldenVersions[module], module)
+	}
+	scripts["pgrep"] = pgrep + "*) exit 1 ;;\nesac\n"
+	// Pipelines in tasks run with the real shell and wc
+	delete(scripts, "sh")
+	for name, real := range map[string]string{"cat": "cat", "ls": "ls"} {
+		p, err := exec.LookPath(real)
+		if err != nil {
+			t.Skipf("%s is needed for the fake host: %v", real, err)
+		}
+		scripts[name] = fmt.Sprintf("n=$#\nfor a in \"$@\"; do\n  case \"$a\" in /*) a=\"%s$a\" ;; esac\n  set -- \"$@\" \"$a\"\ndone\nshift $n\nexec %s \"$@\"\n", root, p)
+	}
+	if err := os.MkdirAll(bin, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"sh", "wc"} {
+		p, err := exec.LookPath(name)
+		if err != nil {
+			t.Skipf("%s is needed for the fake host: %v", name, err)
+		}
+		if err := os.Symlink(p, filepath.Join(bin, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, body := range scripts {
+		if err := ioutil.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+body), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, bin
+}
+
+// goldenAPI serves a small JSON document naming the path of every request,
+// and a health check with the version of the fake Vault
+func goldenAPI() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/sys/health" {
+			w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false,"version":"1.0.3"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"path": r.URL.Path})
+	})
+}
+
+// bundleListings lists the files of the archive at p and the task results
+// of its manifest, without anything which changes from run to run
+func bundleListings(t *testing.T, p string) (string, string) {
+	z, err := OpenArchiveBundle(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	names := z.Names()
+	sort.Strings(names)
+
+	b, err := z.ReadFile(ManifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		t.Fatal(err)
+	}
+	tasks := []string{}
+	for _, r := range m.Tasks {
+		line := fmt.Sprintf("%s %s exit=%d", r.OutputFile(), r.Status, r.ExitStatus)
+		if r.SkipReason != "" {
+			line += " (" + r.SkipReason + ")"
+		}
+		tasks = append(tasks, line)
+	}
+	sort.Strings(tasks)
+	return strings.Join(names, "\n") + "\n", strings.Join(tasks, "\n") + "\n"
+}
+
+// TestGoldenBundle runs the collector commands and rover archive against a
+// fake host and compares the bundle with the golden listings for this OS,
+// so that files support depends on are not dropped unnoticed
+func TestGoldenBundle(t *testing.T) {
+	if runtime.GOOS == Windows {
+		t.Skip("the fake host needs a POSIX shell")
+	}
+	dir, err := ioutil.TempDir("", "rover-golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root, bin := writeFakeHost(t, dir)
+	srv := httptest.NewServer(goldenAPI())
+	defer srv.Close()
+
+	// Nothing from the environment of the test leaks into the bundle
+	env := map[string]string{
+		"PATH":             bin,
+		"CONSUL_HTTP_ADDR": strings.TrimPrefix(srv.URL, "http://"),
+		"VAULT_ADDR":       srv.URL,
+		"VAULT_TOKEN":      "golden-token",
+		"NOMAD_ADDR":       srv.URL,
+	}
+	for _, kv := range os.Environ() {
+		k := strings.SplitN(kv, "=", 2)[0]
+		for _, prefix := range []string{"CONSUL_", "NOMAD_", "VAULT_", "ROVER_"} {
+			if _, ok := env[k]; !ok && strings.HasPrefix(k, prefix) {
+				env[k] = ""
+			}
+		}
+	}
+	for k, v := range env {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	out := filepath.Join(dir, "out")
+	ui := new(cli.MockUi)
+	run := NewRunContext(ui)
+	run.HostName = "penguin"
+	run.FS = rootFS(root)
+	commands := []struct {
+		name string
+		cmd  cli.Command
+	}{
+		{"system", &SystemCommand{RunContext: run, UI: ui}},
+		{Consul, &ConsulCommand{RunContext: run, UI: ui}},
+		{Nomad, &NomadCommand{RunContext: run, UI: ui}},
+		{Vault, &VaultCommand{RunContext: run, UI: ui}},
+		{"archive", &ArchiveCommand{RunContext: run, UI: ui}},
+	}
+	for _, c := range commands {
+		args := []string{"-output-dir", out}
+		if c.name == "archive" {
+			args = append(args, "-path", dir)
+		}
+		if code := c.cmd.Run(args); code != 0 {
+			t.Fatalf("%s failed: %d\n\n%s", c.name, code, ui.ErrorWriter.String())
+		}
+	}
+	archives, _ := filepath.Glob(filepath.Join(dir, "rover-penguin-*.zip"))
+	if len(archives) != 1 {
+		t.Fatalf("expected one archive, got %v", archives)
+	}
+	bundle, manifest := bundleListings(t, archives[0])
+
+	golden := filepath.Join("testdata", "golden", runtime.GOOS)
+	for _, g := range []struct{ name, got string }{{"bundle.txt", bundle}, {"manifest.txt", manifest}} {
+		p := filepath.Join(golden, g.name)
+		if *updateGolden {
+			if err := os.MkdirAll(golden, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(p, []byte(g.got), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(p)
+		if os.IsNotExist(err) {
+			t.Skipf("no golden listings for %s; record them with -update", runtime.GOOS)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g.got != string(want) {
+			t.Errorf("%s differs from %s; if the change is intended, run the test with -update\n\n%s", g.name, p, lineDiff(string(want), g.got))
+		}
+	}
+}
+
+// lineDiff lists the lines only in want with - and those only in got with +
+func lineDiff(want, got string) string {
+	count := map[string]int{}
+	for _, l := range strings.Split(got, "\n") {
+		count[l]++
+	}
+	for _, l := range strings.Split(want, "\n") {
+		count[l]--
+	}
+	lines := []string{}
+	for l, n := range count {
+		for ; n < 0; n++ {
+			lines = append(lines, "- "+l)
+		}
+		for ; n > 0; n-- {
+			lines = append(lines, "+ "+l)
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
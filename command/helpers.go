@@ -4,14 +4,17 @@
 package command
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/pierrre/archivefile/zip"
@@ -68,267 +71,295 @@ type Internal struct {
 
 // ActiveLocalVersion tries to locate binary tools in the system path and get their version using OS calls
 // 'consul version' has a slightly different output style from the others, and must be handled differently
-func ActiveLocalVersion(binary string) (string, error) {
-	i := Internal{}
-	// Internal logging
-	l := "rover.log"
-	h, err := GetHostName()
-	if err != nil {
-		return "", fmt.Errorf("Cannot get system hostname with error %v", err)
-	}
-	i.HostName = h
-	p := filepath.Join(fmt.Sprintf("%s", i.HostName), "log")
-	pid := ""
-	if err := os.MkdirAll(p, os.ModePerm); err != nil {
-		fmt.Println(fmt.Sprintf("Cannot create log directory %s.", p))
-		return pid, err
-	}
-	f, err := os.OpenFile(filepath.Join(p, l), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Println(fmt.Sprintf("Failed to open log file %s with error: %v", f, err))
-		return pid, err
-	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	logger := hclog.New(&hclog.LoggerOptions{Name: "rover", Level: hclog.LevelFromString("INFO"), Output: w})
-	binPath, err := exec.LookPath(binary)
+func (r *RunContext) ActiveLocalVersion(binary string) (string, error) {
+	logger := r.logger()
+	binPath, err := r.runner().LookPath(binary)
 	if err != nil {
 		logger.Error("helper", "cannot detect binary on PATH", binary, "error", err.Error())
 		return "", fmt.Errorf("Cannot detect binary on PATH with error: %v", err)
 	}
-	var v []byte
-	if binary == Consul {
-		v, err = exec.Command("/bin/sh", "-c", fmt.Sprintf("%s version | head -n 1 | awk '{print $2}' | cut -d 'v' -f2", binPath)).Output()
-		if err != nil {
-			logger.Error("helper", "cannot execute binary", binary, "error", err.Error())
-			return "", fmt.Errorf("Cannot execute binary with error: %v", err)
-		}
-		return string(v), nil
-	} else {
-		v, err = exec.Command("/bin/sh", "-c", fmt.Sprintf("%s version | awk '{print $2}' | cut -d 'v' -f2", binPath)).Output()
-		if err != nil {
-			logger.Error("helper", "cannot execute binary", binary, "error", err.Error())
-			return "", fmt.Errorf("Cannot execute binary with error: %v", err)
-		}
-		return string(v), nil
+	out, err := r.commandOutput(binPath, "version")
+	if err != nil {
+		logger.Error("helper", "cannot execute binary", binary, "error", err.Error())
+		return "", fmt.Errorf("Cannot execute binary with error: %v", err)
 	}
+	return parseHashiVersion(out), nil
+}
+
+// parseHashiVersion returns the version from the output of a version
+// command, whose first line reads like "Consul v1.4.0" or "Vault v0.9.1
+// ('...')"; Consul goes on to list protocol versions on further lines
+func parseHashiVersion(out string) string {
+	line := strings.SplitN(out, "\n", 2)[0]
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return ""
+	}
+	return strings.TrimPrefix(fields[1], "v")
 }
 
 // CheckProc checks for a running process by name with pgrep or ps and returns its PID
-func CheckProc(name string) (string, error) {
-	i := Internal{}
-	// Internal logging
-	l := "rover.log"
-	h, err := GetHostName()
-	if err != nil {
-		return "", fmt.Errorf("Cannot get system hostname with error %v", err)
-	}
-	i.HostName = h
-	p := filepath.Join(fmt.Sprintf("%s", i.HostName), "log")
-	pid := ""
-	if err := os.MkdirAll(p, os.ModePerm); err != nil {
-		fmt.Println(fmt.Sprintf("Cannot create log directory %s.", p))
-		return pid, err
-	}
-	f, err := os.OpenFile(filepath.Join(p, l), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Println(fmt.Sprintf("Failed to open log file %s with error: %v", f, err))
-		return pid, err
-	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	logger := hclog.New(&hclog.LoggerOptions{Name: "rover", Level: hclog.LevelFromString("INFO"), Output: w})
+func (r *RunContext) CheckProc(name string) (string, error) {
+	logger := r.logger()
+	runner := r.runner()
 
 	// If pgrep is around, use that...
-	path, err := exec.LookPath("pgrep")
+	path, err := runner.LookPath("pgrep")
 	if err != nil {
 		logger.Info("check-proc", "pgrep not found in system PATH", path)
 		// If no `pgrep`, check for running process with a POSIX-y `ps`
-		out, err := exec.Command("sh", "-c", fmt.Sprintf("ps -A | grep -i %s | head -1 | awk '{print $1}'", name)).Output()
+		out, err := r.commandOutput("ps", "-A")
 		if err != nil {
 			logger.Error("check-proc", "cannot determine PID", name)
-			return pid, err
+			return "", err
 		}
-		if len(out) > 0 {
-			logger.Info("check-proc", "process detected", name, "pid", pid)
+		for _, line := range strings.Split(out, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) > 0 && strings.Contains(strings.ToLower(line), strings.ToLower(name)) {
+				logger.Info("check-proc", "process detected", name, "pid", fields[0])
+				return fields[0], nil
+			}
 		}
-		pid := strings.TrimSpace(string(out))
-		return pid, nil
+		return "", nil
 	}
 	logger.Debug("check-proc", "pgrep found in system PATH")
-	// Check for running process with pgrep
-	out, err := exec.Command("pgrep", name).Output()
+	// Check for running process with pgrep, which exits with status 1
+	// when nothing matches
+	out, err := r.commandOutput("pgrep", name)
 	if err != nil {
 		logger.Error("check-proc", "cannot determine PID", name)
-		return pid, err
+		return "", err
 	}
-	pid = strings.TrimSpace(string(out))
+	// Tasks take a single PID, so the oldest match is used
+	pid := strings.TrimSpace(strings.SplitN(strings.TrimSpace(out), "\n", 2)[0])
 	if len(pid) > 0 {
 		logger.Info("check-proc", "process detected", name, "pid", pid)
 	}
-	// pid = strings.TrimSpace(string(out))
 	return pid, nil
 }
 
 // CheckHashiVersion attempts to locate HashiCorp runtime tools in and get
 // their versions - Consul has slightly different version output style so
-// it must be handled differently
-func CheckHashiVersion(name string) string {
-	i := Internal{}
-	pid := ""
-	v := ""
-	// Internal logging
-	l := "rover.log"
-	h, err := GetHostName()
+// it must be handled differently. The version is empty when the process is
+// not running, and an error is returned when it cannot be determined
+func (r *RunContext) CheckHashiVersion(name string) (string, error) {
+	logger := r.logger()
+
+	pid, err := r.CheckProc(name)
 	if err != nil {
-		return fmt.Sprintf("Cannot get system hostname with error %v", err)
+		logger.Error("check-hashi-version", "cannot check for process", name)
 	}
-	i.HostName = h
-	p := filepath.Join(fmt.Sprintf("%s", i.HostName), "log")
-	if err := os.MkdirAll(p, os.ModePerm); err != nil {
-		fmt.Println(fmt.Sprintf("Cannot create log directory %s.", p))
-		os.Exit(1)
+	if pid == "" {
+		return "", nil
 	}
-	f, err := os.OpenFile(filepath.Join(p, l), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Println(fmt.Sprintf("Failed to open log file %s with error: %v", f, err))
-		os.Exit(1)
+	logger.Info("check-hashi-version", "process identified", name, "pid", pid)
+	switch name {
+	case Consul, Nomad, Vault:
+	default:
+		return "", nil
 	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	logger := hclog.New(&hclog.LoggerOptions{Name: "rover", Level: hclog.LevelFromString("INFO"), Output: w})
-
-	pid, err = CheckProc(name)
+	path, err := r.runner().LookPath(name)
 	if err != nil {
-		logger.Error("check-hashi-version", "cannot check for process", name)
+		logger.Info("check-hashi-version", "cannot find binary in PATH", name)
+		return "", fmt.Errorf("cannot find %s in PATH", name)
 	}
-	if pid != "" {
-		logger.Info("check-hashi-version", "process identified", name, "pid", pid)
-		path, err := exec.LookPath(name)
-		if err != nil {
-			logger.Info("check-hashi-version", "cannot find binary in PATH", name)
-		}
-		if name == "consul" {
-			v, err := exec.Command("/bin/sh", "-c", fmt.Sprintf("%s version | head -n 1 | awk '{print $2}' | cut -d 'v' -f2", path)).Output()
-			if err != nil {
-				logger.Error("check-hashi-version", "cannot execute binary", name, "error", err.Error())
-			}
-			return strings.TrimSpace(string(v))
-		} else if name == "nomad" || name == "vault" {
-			v, err := exec.Command("/bin/sh", "-c", fmt.Sprintf("%s version | awk '{print $2}' | cut -d 'v' -f2", path)).Output()
-			if err != nil {
-				logger.Error("check-hashi-version", "cannot execute binary", name, "error", err.Error())
-			}
-			return strings.TrimSpace(string(v))
-		}
+	out, err := r.commandOutput(path, "version")
+	if err != nil {
+		logger.Error("check-hashi-version", "cannot execute binary", name, "error", err.Error())
+		return "", fmt.Errorf("cannot execute %s version with error %v", name, err)
 	}
-	return v
+	return parseHashiVersion(out), nil
 }
 
 // Dump takes a type, output filename and command, which it then executes
-// while also writing stdout + stderr to a file named for the command
+// while also writing stdout + stderr to a file named for the command in
+// the data directory of the run
 // Inspired by debug-ninja! (https://github.com/fprimex/debug-ninja)
-func Dump(dumpType string, outfile string, cmdName string, args ...string) int {
-	i := Internal{}
-	t := "%s/%s/%s.txt"
-	h, err := GetHostName()
+func (r *RunContext) Dump(dumpType string, outfile string, cmdName string, args ...string) (int, error) {
+	return r.DumpContext(context.Background(), DefaultTaskTimeout, dumpType, outfile, cmdName, args...)
+}
+
+// DumpContext is Dump with a deadline; when the timeout expires or ctx is
+// cancelled the whole process group is killed, and the event is noted both
+// in rover.log and at the end of the output file since its output is
+// truncated. It returns the exit status of the command, along with an
+// error when the command could not be run to completion
+func (r *RunContext) DumpContext(ctx context.Context, timeout time.Duration, dumpType string, outfile string, cmdName string, args ...string) (status int, err error) {
+	h, err := r.dataDir()
 	if err != nil {
-		fmt.Println("Cannot get system hostname")
-		os.Exit(1)
+		return 1, err
 	}
-	i.HostName = h
 	// Internal logging
-	l := "rover.log"
-	p := filepath.Join(fmt.Sprintf("%s", i.HostName), "log")
-	if err := os.MkdirAll(p, os.ModePerm); err != nil {
-		fmt.Println(fmt.Sprintf("Cannot create log directory %s.", p))
-		os.Exit(1)
-	}
-	f, err := os.OpenFile(filepath.Join(p, l), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Println(fmt.Sprintf("Failed to open log file %s with error: %v", f, err))
-		os.Exit(1)
-	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	logger := hclog.New(&hclog.LoggerOptions{Name: "rover", Level: hclog.LevelFromString("INFO"), Output: w})
+	logger := r.logger()
 
-	path, err := exec.LookPath(cmdName)
+	runner := r.runner()
+	path, err := runner.LookPath(cmdName)
 	if err != nil {
 		logger.Info("dump", "cannot find command in system PATH", cmdName)
-	} else {
-		logger.Debug("dump", "found command", cmdName, "location", path)
-		// We audit all command parameters by specifying them explicitly as
-		// Dump() invocations and not allowing any form of user input to
-		// be passed in for most command parameter values, so subprocess
-		// launching with strict parameters maybe not too terribly sad here
-		cmd := exec.Command(cmdName, args...)
-		f, err := os.Create(fmt.Sprintf(filepath.Join(".", t),
-			i.HostName,
-			dumpType,
-			outfile))
-		if err != nil {
-			panic(err)
-		}
-		defer func() {
-			err = f.Close()
-			if err != nil {
-				log.Fatal(err)
-			}
-		}()
-		cmd.Stdout = f
-		cmd.Stderr = f
-		err = cmd.Start()
-		if err != nil {
-			logger.Error("dump", "cannot execute command with error", err.Error())
-			panic(err)
-		}
-		// Not as cool as the dots and the Es, but it lets us know something
-		if err := cmd.Wait(); err != nil {
-			if exiterr, ok := err.(*exec.ExitError); ok {
-				if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-					cli := fmt.Sprintf("%s %s", cmdName, strings.Join(args[:], " "))
-					logger.Error("dump", "command exited with non-zero status", cli, "exit-status", hclog.Fmt("%d", status.ExitStatus()))
-				}
-			} else {
-				logger.Error("dump", "command wait error", err.Error())
-			}
+		return 1, fmt.Errorf("cannot find command %s in PATH", cmdName)
+	}
+	logger.Debug("dump", "found command", cmdName, "location", path)
+	cli := strings.TrimSpace(fmt.Sprintf("%s %s", cmdName, strings.Join(args, " ")))
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	p := filepath.Join(h, dumpType, outfile+".txt")
+	out, err := os.Create(p)
+	if err != nil {
+		logger.Error("dump", "cannot create output file", p, "error", err.Error())
+		return 1, fmt.Errorf("cannot create output file %s with error %v", p, err)
+	}
+	defer func() {
+		if cerr := out.Close(); cerr != nil && err == nil {
+			logger.Error("dump", "cannot close output file", p, "error", cerr.Error())
+			status, err = 1, fmt.Errorf("cannot write output file %s with error %v", p, cerr)
 		}
+	}()
+	// Not as cool as the dots and the Es, but it lets us know something
+	status, err = runner.Run(ctx, out, cmdName, args...)
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		logger.Error("dump", "command timed out and was killed", cli, "timeout", timeout.String())
+		fmt.Fprintf(out, "\n[rover] command timed out after %s and was killed; output is truncated\n", timeout)
+		return 1, fmt.Errorf("%s timed out after %s", cli, timeout)
+	case context.Canceled:
+		logger.Warn("dump", "command cancelled and was killed", cli)
+		fmt.Fprintf(out, "\n[rover] command cancelled and was killed; output is truncated\n")
+		return 1, fmt.Errorf("%s was cancelled", cli)
+	}
+	if err != nil {
+		logger.Error("dump", "cannot execute command with error", err.Error())
+		fmt.Fprintf(out, "[rover] cannot execute %s: %v\n", cli, err)
+		return 1, fmt.Errorf("cannot execute %s with error %v", cli, err)
+	}
+	if status != 0 {
+		// The command ran, so its exit status is the outcome
+		logger.Error("dump", "command exited with non-zero status", cli, "exit-status", hclog.Fmt("%d", status))
 	}
-	return 0
+	return status, nil
 }
 
-// FileExist checks for a file's existence
-func FileExist(fileName string) bool {
-	i := Internal{}
+// DumpFile copies the contents of a file into the output file for a task
+// so that copied files land next to command output in the same layout
+func (r *RunContext) DumpFile(dumpType string, outfile string, src string) (int, error) {
+	h, err := r.dataDir()
+	if err != nil {
+		return 1, err
+	}
 	// Internal logging
-	l := "rover.log"
-	h, err := GetHostName()
+	logger := r.logger()
+
+	in, err := r.fs().Open(src)
+	if err != nil {
+		logger.Info("dump-file", "cannot open file", src, "error", err.Error())
+		return 1, fmt.Errorf("cannot open %s with error %v", src, err)
+	}
+	defer in.Close()
+	out, err := os.Create(filepath.Join(h, dumpType, outfile+".txt"))
 	if err != nil {
-		fmt.Println("Cannot get system hostname")
-		os.Exit(1)
+		logger.Error("dump-file", "cannot create output file", outfile, "error", err.Error())
+		return 1, fmt.Errorf("cannot create output file %s with error %v", outfile, err)
 	}
-	i.HostName = h
-	p := filepath.Join(fmt.Sprintf("%s", i.HostName), "log")
-	if err := os.MkdirAll(p, os.ModePerm); err != nil {
-		fmt.Println(fmt.Sprintf("Cannot create log directory %s.", p))
-		os.Exit(1)
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
 	}
-	f, err := os.OpenFile(filepath.Join(p, l), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		fmt.Println(fmt.Sprintf("Failed to open log file %s with error: %v", f, err))
-		os.Exit(1)
+		logger.Error("dump-file", "cannot copy file", src, "error", err.Error())
+		return 1, fmt.Errorf("cannot copy %s with error %v", src, err)
+	}
+	return 0, nil
+}
+
+// DumpAPI fetches path with client and writes the response body to the
+// output file for a task; JSON responses are indented so they read and
+// redact line by line like command output. Responses are cut off after
+// maxBytes when it is above zero, reporting truncated. Errors are noted in
+// rover.log and in the output file in place of the response, and returned
+// so that callers can tell a refused request from a failed one
+func (r *RunContext) DumpAPI(ctx context.Context, timeout time.Duration, client APIClient, dumpType string, outfile string, ext string, path string, maxBytes int64) (truncated bool, err error) {
+	h, err := r.dataDir()
+	if err != nil {
+		return false, err
+	}
+	// Internal logging
+	logger := r.logger()
+
+	out, err := os.Create(filepath.Join(h, dumpType, outfile+"."+ext))
+	if err != nil {
+		logger.Error("dump-api", "cannot create output file", outfile, "error", err.Error())
+		return false, err
+	}
+	defer out.Close()
+	body := &cappedBuffer{max: maxBytes}
+	err = client.Fetch(ctx, path, body)
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		logger.Error("dump-api", "request timed out", path, "timeout", timeout.String())
+		fmt.Fprintf(out, "[rover] request timed out after %s\n", timeout)
+		return false, ctx.Err()
+	case context.Canceled:
+		logger.Warn("dump-api", "request cancelled", path)
+		fmt.Fprintf(out, "[rover] request cancelled\n")
+		return false, ctx.Err()
+	}
+	if isForbidden(err) {
+		// Tokens often lack a policy for every endpoint, so carry on
+		logger.Warn("dump-api", "permission denied", path)
+		fmt.Fprintf(out, "[rover] permission denied: %v\n", err)
+		return false, err
+	}
+	if err != nil && !body.truncated {
+		logger.Error("dump-api", "request failed", path, "error", err.Error())
+		fmt.Fprintf(out, "[rover] %v\n", err)
+		return false, err
+	}
+	if body.truncated {
+		// A truncated response is kept as is since it is no longer valid JSON
+		logger.Warn("dump-api", "response truncated", path, "max-bytes", maxBytes)
+	} else if ext == "json" {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, body.buf.Bytes(), "", "  "); err == nil {
+			indented.WriteByte('\n')
+			body.buf = indented
+		}
+	}
+	if _, err := body.buf.WriteTo(out); err != nil {
+		logger.Error("dump-api", "cannot write output file", outfile, "error", err.Error())
+		return body.truncated, err
+	}
+	if body.truncated {
+		fmt.Fprintf(out, "\n[rover] response truncated after %d bytes\n", maxBytes)
 	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	logger := hclog.New(&hclog.LoggerOptions{Name: "rover", Level: hclog.LevelFromString("INFO"), Output: w})
-	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+	return body.truncated, nil
+}
+
+// FileExist checks for a file's existence
+func (r *RunContext) FileExist(fileName string) bool {
+	logger := r.logger()
+	if _, err := r.fs().Stat(fileName); !os.IsNotExist(err) {
 		logger.Info("file-exist", "file exists", fileName)
 		return true
 	}
 	return false
 }
 
+// InterruptContext returns a context which is cancelled when rover receives
+// an interrupt or termination signal so running tasks can be killed
+func InterruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-ch:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(ch)
+	}()
+	return ctx, cancel
+}
+
 // GetHostName gets the current system's network hostname
 func GetHostName() (string, error) {
 	h, err := os.Hostname()
@@ -338,81 +369,23 @@ func GetHostName() (string, error) {
 	return h, nil
 }
 
-// HTTPCmdCheck checks for curl or wget and use what is present
-func HTTPCmdCheck() string {
-	i := Internal{}
-	// Internal logging
-	l := "rover.log"
-	h, err := GetHostName()
-	if err != nil {
-		fmt.Println("Cannot get system hostname")
-		os.Exit(1)
-	}
-	i.HostName = h
-	p := filepath.Join(fmt.Sprintf("%s", i.HostName), "log")
-	if err := os.MkdirAll(p, os.ModePerm); err != nil {
-		fmt.Println(fmt.Sprintf("Cannot create log directory %s.", p))
-		os.Exit(1)
-	}
-	f, err := os.OpenFile(filepath.Join(p, l), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Println(fmt.Sprintf("Failed to open log file %s with error: %v", f, err))
-		os.Exit(1)
-	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	logger := hclog.New(&hclog.LoggerOptions{Name: "rover", Level: hclog.LevelFromString("INFO"), Output: w})
-	path, err := exec.LookPath("curl")
-	if err != nil {
-		logger.Info("http-cmd-check", "curl was not found in system PATH")
-		path, err = exec.LookPath("wget")
-		if err != nil {
-			logger.Info("http-cmd-check", "wget was not found in system PATH")
-		} else {
-			logger.Info("http-cmd-check", "wget is available at", path)
-			httpCommand := "wget"
-			return httpCommand
-		}
-	}
-	logger.Info("http-cmd-check", "curl is available at", path)
-	httpCommand := "curl"
-	return httpCommand
-}
-
 // ZipIt archives rover results into a zip file suitable for tubing
-func ZipIt(target string) {
-	i := Internal{}
-	// Internal logging
-	l := "rover.log"
-	h, err := GetHostName()
+func (r *RunContext) ZipIt(target string) error {
+	h, err := r.dataDir()
 	if err != nil {
-		fmt.Println("Cannot get system hostname")
-		os.Exit(1)
+		return err
 	}
-	i.HostName = h
-	p := filepath.Join(fmt.Sprintf("%s", i.HostName), "log")
-	if err := os.MkdirAll(p, os.ModePerm); err != nil {
-		fmt.Println(fmt.Sprintf("Cannot create log directory %s.", p))
-		os.Exit(1)
-	}
-	f, err := os.OpenFile(filepath.Join(p, l), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Println(fmt.Sprintf("Failed to open log file %s with error: %v", f, err))
-		os.Exit(1)
-	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	logger := hclog.New(&hclog.LoggerOptions{Name: "rover", Level: hclog.LevelFromString("INFO"), Output: w})
-	outpath := filepath.Join(".", i.HostName)
-	err = zip.ArchiveFile("output", outpath, nil)
+	logger := r.logger()
+	err = zip.ArchiveFile(h, target, nil)
 	if err != nil {
 		logger.Error("zipit", "cannot archive with error", err.Error())
-		os.Exit(1)
+		return fmt.Errorf("cannot archive %s with error %v", h, err)
 	}
 	// Remove the source directory after zip successfully created
-	err = os.RemoveAll(i.HostName)
+	err = os.RemoveAll(h)
 	if err != nil {
 		logger.Error("zipit", "cannot clean up with error", err.Error())
-		os.Exit(1)
+		return fmt.Errorf("cannot remove %s with error %v", h, err)
 	}
+	return nil
 }
@@ -3,15 +3,11 @@
 package command
 
 import (
-	"bufio"
 	"fmt"
-	"os"
-	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
 
-	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
 	"github.com/ryanuber/columnize"
 )
@@ -23,6 +19,7 @@ type InfoCommand struct {
 	NomadVersion  string
 	OS            string
 	Uptime        string
+	RunContext    *RunContext
 	UI            cli.Ui
 	VaultVersion  string
 }
@@ -49,37 +46,35 @@ Vault version:   v0.8.3
 
 // Run command
 func (c *InfoCommand) Run(_ []string) int {
-
-	// Internal logging
-	l := "rover.log"
-	p := filepath.Join(fmt.Sprintf("%s", c.HostName), "log")
-	if err := os.MkdirAll(p, os.ModePerm); err != nil {
-		fmt.Println(fmt.Sprintf("Cannot create log directory %s.", p))
-		os.Exit(1)
+	run := c.RunContext
+	if run == nil {
+		run = NewRunContext(c.UI)
 	}
-	f, err := os.OpenFile(filepath.Join(p, l), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Println(fmt.Sprintf("Failed to open log file %s with error: %v", f, err))
-		os.Exit(1)
+	if err := run.Open("", RunReuse); err != nil {
+		c.UI.Error(err.Error())
+		return 1
 	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	logger := hclog.New(&hclog.LoggerOptions{Name: "rover", Level: hclog.LevelFromString("INFO"), Output: w})
+	defer run.Close()
+	c.HostName = run.HostName
+	c.OS = run.OS
+	logger := run.Logger
 
 	logger.Info("system", "hello from", c.HostName)
 	logger.Info("system", "detected OS", c.OS)
 
-	h, err := GetHostName()
-	if err != nil {
-		out := fmt.Sprintf("Cannot get system hostname with error %v", err)
-		c.UI.Output(out)
-
-		return 1
+	// A version which cannot be determined is shown as unknown rather
+	// than failing the whole dashboard
+	checkVersion := func(name string) string {
+		v, err := run.CheckHashiVersion(name)
+		if err != nil {
+			logger.Info("info", "cannot determine version", name, "error", err.Error())
+			return "unknown"
+		}
+		return v
 	}
-	c.HostName = h
-	c.ConsulVersion = CheckHashiVersion("consul")
-	c.NomadVersion = CheckHashiVersion("nomad")
-	c.VaultVersion = CheckHashiVersion("vault")
+	c.ConsulVersion = checkVersion(Consul)
+	c.NomadVersion = checkVersion(Nomad)
+	c.VaultVersion = checkVersion(Vault)
 
 	// System data / random system factoids
 	systemData := map[string]string{"OS": runtime.GOOS,
@@ -0,0 +1,503 @@
+// Package command for inspect
+// Inspect summarizes a rover archive and prints or searches the files in it
+// without extracting it, so a bundle can be triaged where it was received
+package command
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
+)
+
+// Files of tar archives up to bundleCacheFile bytes are kept in memory
+// while the archive is listed, up to bundleCacheTotal bytes in all
+const (
+	bundleCacheFile  = 1 << 20
+	bundleCacheTotal = 32 << 20
+)
+
+// ArchiveBundle is a Bundle for a rover archive read where it is; names
+// are relative to the host directory at the root of the archive. Zip
+// archives are read in place. Tar archives can only be read from start to
+// end, so their small files are kept in memory when the archive is opened
+// and other files are streamed from the archive each time they are opened
+type ArchiveBundle struct {
+	// Root is the host directory the archive was made from
+	Root string
+	// Format is the format of the archive
+	Format string
+	path   string
+	reader *zip.ReadCloser
+	files  map[string]*zip.File
+	cache  map[string][]byte
+	// entries maps names to the names of the entries in the archive
+	entries map[string]string
+	sizes   map[string]int64
+	names   []string
+	// order holds the names in archive order
+	order []string
+}
+
+// OpenArchiveBundle opens the archive at p
+func OpenArchiveBundle(p string) (*ArchiveBundle, error) {
+	format, err := archiveFormat(p)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open archive %s with error %v", p, err)
+	}
+	a := &ArchiveBundle{Format: format, path: p, files: map[string]*zip.File{}, cache: map[string][]byte{}, entries: map[string]string{}, sizes: map[string]int64{}}
+	entries := []archiveEntry{}
+	if format == FormatZip {
+		r, err := zip.OpenReader(p)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open archive %s with error %v", p, err)
+		}
+		a.reader = r
+		for _, f := range r.File {
+			if strings.HasSuffix(f.Name, "/") {
+				continue
+			}
+			a.files[f.Name] = f
+			entries = append(entries, archiveEntry{Name: f.Name, Size: int64(f.UncompressedSize64)})
+		}
+	} else if entries, err = a.listTar(); err != nil {
+		return nil, fmt.Errorf("cannot read archive %s with error %v", p, err)
+	}
+	// ArchiveCommand stores every file under the host directory; archives
+	// made some other way may have their files at the root
+	for i, e := range entries {
+		root := strings.SplitN(e.Name, "/", 2)[0]
+		if !strings.Contains(e.Name, "/") || (i > 0 && root != a.Root) {
+			a.Root = ""
+			break
+		}
+		a.Root = root
+	}
+	for _, e := range entries {
+		name := e.Name
+		if a.Root != "" {
+			name = strings.TrimPrefix(name, a.Root+"/")
+		}
+		a.entries[name] = e.Name
+		a.sizes[name] = e.Size
+		a.order = append(a.order, name)
+	}
+	a.names = append([]string{}, a.order...)
+	sort.Strings(a.names)
+	return a, nil
+}
+
+// listTar lists the regular files of a tar archive, keeping the small ones
+// in memory by their entry names
+func (a *ArchiveBundle) listTar() ([]archiveEntry, error) {
+	tr, closer, err := openTar(a.path, a.Format)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	entries := []archiveEntry{}
+	var cached int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if _, err := entryPath("", name); err != nil {
+			return nil, err
+		}
+		if hdr.Size <= bundleCacheFile && cached+hdr.Size <= bundleCacheTotal {
+			b, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			a.cache[name] = b
+			cached += hdr.Size
+		}
+		entries = append(entries, archiveEntry{Name: name, Size: hdr.Size})
+	}
+}
+
+// Close closes the archive
+func (a *ArchiveBundle) Close() error {
+	if a.reader != nil {
+		return a.reader.Close()
+	}
+	return nil
+}
+
+// Names returns the sorted names of the files in the archive
+func (a *ArchiveBundle) Names() []string {
+	return a.names
+}
+
+// Size returns the uncompressed size of the file name
+func (a *ArchiveBundle) Size(name string) int64 {
+	return a.sizes[name]
+}
+
+// tarFile is a file streamed from a tar archive
+type tarFile struct {
+	io.Reader
+	io.Closer
+}
+
+// Open opens the file name for reading
+func (a *ArchiveBundle) Open(name string) (io.ReadCloser, error) {
+	entry, ok := a.entries[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if a.reader != nil {
+		return a.files[entry].Open()
+	}
+	if b, ok := a.cache[entry]; ok {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+	tr, closer, err := openTar(a.path, a.Format)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			err = &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		if err != nil {
+			closer.Close()
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && path.Clean(hdr.Name) == entry {
+			return tarFile{Reader: tr, Closer: closer}, nil
+		}
+	}
+}
+
+// ReadFile reads the file name from the archive
+func (a *ArchiveBundle) ReadFile(name string) ([]byte, error) {
+	rc, err := a.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// Walk calls fn with every file of the archive in archive order, reading
+// a tar archive once from start to end
+func (a *ArchiveBundle) Walk(fn func(name string, r io.Reader) error) error {
+	if a.reader != nil {
+		for _, name := range a.order {
+			rc, err := a.Open(name)
+			if err != nil {
+				return err
+			}
+			err = fn(name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tr, closer, err := openTar(a.path, a.Format)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if a.Root != "" {
+			name = strings.TrimPrefix(name, a.Root+"/")
+		}
+		if err := fn(name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// bundleVersions returns the product version found for each module, from
+// its version command output or, failing that, its API
+func bundleVersions(b Bundle, modules []string) map[string]string {
+	versions := map[string]string{}
+	for _, m := range modules {
+		if out, err := b.ReadFile(path.Join(m, m+"_version.txt")); err == nil {
+			if v := productVersion.FindStringSubmatch(string(out)); v != nil {
+				versions[m] = v[1]
+				continue
+			}
+		}
+		switch m {
+		case Consul:
+			if self, err := consulAgentSelf(b); err == nil && self.Config.Version != "" {
+				versions[m] = self.Config.Version
+			}
+		case Vault:
+			if h, source := vaultServerHealth(b); source != "" && h.Version != "" {
+				versions[m] = h.Version
+			}
+		}
+	}
+	return versions
+}
+
+// InspectCommand describes inspect related fields
+type InspectCommand struct {
+	Cat     string
+	Extract string
+	Grep    string
+	UI      cli.Ui
+}
+
+// Help output
+func (c *InspectCommand) Help() string {
+	helpText := `
+Usage: rover inspect [options] <archive> [<file> ...]
+	Summarize a rover archive without extracting it: host, OS, modules,
+	failed tasks, product versions and file sizes. With -cat or -grep,
+	print or search files inside the archive instead; file arguments,
+	which may be patterns like consul/*, limit the files searched. Zip,
+	tar.gz and tar.zst archives are supported
+
+General Options:
+  -cat=<file>		Print a file from the archive, e.g. system/df.txt
+  -extract=<dir>	Extract the archive into a directory, keeping file modes and times
+  -grep=<regexp>	Print the lines of each file which match a regular expression
+`
+
+	return strings.TrimSpace(helpText)
+}
+
+// Run command
+func (c *InspectCommand) Run(args []string) int {
+	cmdFlags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.StringVar(&c.Cat, "cat", "", "File to print from the archive")
+	cmdFlags.StringVar(&c.Extract, "extract", "", "Directory to extract the archive into")
+	cmdFlags.StringVar(&c.Grep, "grep", "", "Regular expression to search the archive for")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+	if cmdFlags.NArg() == 0 {
+		c.UI.Error(c.Help())
+		return 1
+	}
+	if c.Extract != "" {
+		entries, err := extractArchive(cmdFlags.Arg(0), c.Extract)
+		if err != nil {
+			out := fmt.Sprintf("Cannot extract %s with error %v", cmdFlags.Arg(0), err)
+			c.UI.Error(out)
+			return 1
+		}
+		c.UI.Output(fmt.Sprintf("Extracted %d file(s) from %s into %s", len(entries), cmdFlags.Arg(0), c.Extract))
+		return 0
+	}
+	z, err := OpenArchiveBundle(cmdFlags.Arg(0))
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	defer z.Close()
+
+	switch {
+	case c.Cat != "":
+		return c.cat(z)
+	case c.Grep != "":
+		return c.grep(z, cmdFlags.Args()[1:])
+	}
+	return c.summary(cmdFlags.Arg(0), z)
+}
+
+// cat prints a file from the archive
+func (c *InspectCommand) cat(z *ArchiveBundle) int {
+	b, err := z.ReadFile(c.Cat)
+	if err != nil {
+		out := fmt.Sprintf("Cannot read %s from the archive with error %v", c.Cat, err)
+		c.UI.Error(out)
+		return 1
+	}
+	c.UI.Output(strings.TrimRight(string(b), "\n"))
+	return 0
+}
+
+// grep prints each matching line as file:line: text, reading the files in
+// archive order, and fails when nothing matched, like grep
+func (c *InspectCommand) grep(z *ArchiveBundle, patterns []string) int {
+	re, err := regexp.Compile(c.Grep)
+	if err != nil {
+		out := fmt.Sprintf("Invalid -grep expression with error %v", err)
+		c.UI.Error(out)
+		return 1
+	}
+	matches := 0
+	err = z.Walk(func(name string, r io.Reader) error {
+		if !matchAny(patterns, name) {
+			return nil
+		}
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for n := 1; scanner.Scan(); n++ {
+			if re.MatchString(scanner.Text()) {
+				c.UI.Output(fmt.Sprintf("%s:%d: %s", name, n, scanner.Text()))
+				matches++
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			c.UI.Warn(fmt.Sprintf("Cannot read %s from the archive with error %v", name, err))
+		}
+		return nil
+	})
+	if err != nil {
+		out := fmt.Sprintf("Cannot read the archive with error %v", err)
+		c.UI.Error(out)
+		return 1
+	}
+	if matches == 0 {
+		return 1
+	}
+	return 0
+}
+
+// matchAny reports whether name matches one of patterns, or whether there
+// are no patterns
+func matchAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok || p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// summary prints what the archive holds
+func (c *InspectCommand) summary(archive string, z *ArchiveBundle) int {
+	info := []string{fmt.Sprintf("Archive: | %s", archive), fmt.Sprintf("Format: | %s", z.Format)}
+	modules := []string{}
+	m := &Manifest{}
+	if b, err := z.ReadFile(ManifestFile); err != nil {
+		c.UI.Warn(fmt.Sprintf("No %s in the archive; task results are unknown.", ManifestFile))
+		info = append(info, fmt.Sprintf("Host: | %s", z.Root))
+	} else if err := json.Unmarshal(b, m); err != nil {
+		out := fmt.Sprintf("Cannot parse %s with error %v", ManifestFile, err)
+		c.UI.Error(out)
+		return 1
+	} else {
+		info = append(info,
+			fmt.Sprintf("Host: | %s", m.HostName),
+			fmt.Sprintf("OS: | %s", m.OS),
+			fmt.Sprintf("Updated: | %s", m.Updated.Format("2006-01-02 15:04:05 MST")))
+		for module := range m.Profiles {
+			modules = append(modules, module)
+		}
+		if len(m.Files) > 0 {
+			info = append(info, fmt.Sprintf("File hashes: | %d; check them with rover verify", len(m.Files)))
+		}
+	}
+	// Archives of data gathered without a manifest, whose manifest then
+	// only holds file hashes, name their modules by their directories
+	if len(m.Tasks) == 0 {
+		seen := map[string]bool{}
+		for _, module := range modules {
+			seen[module] = true
+		}
+		for _, name := range z.Names() {
+			if i := strings.Index(name, "/"); i > 0 && name[:i] != "log" && !seen[name[:i]] {
+				seen[name[:i]] = true
+				modules = append(modules, name[:i])
+			}
+		}
+	}
+	sort.Strings(modules)
+	collected := []string{}
+	for _, module := range modules {
+		if p, ok := m.Profiles[module]; ok {
+			collected = append(collected, fmt.Sprintf("%s (%s)", module, p))
+		} else {
+			collected = append(collected, module)
+		}
+	}
+	info = append(info, fmt.Sprintf("Modules: | %s", listOrNone(collected)))
+	versions := bundleVersions(z, modules)
+	for _, module := range modules {
+		if v, ok := versions[module]; ok {
+			info = append(info, fmt.Sprintf("%s version: | %s", module, v))
+		}
+	}
+	if r := (FindingsReport{}); readBundleJSON(z, FindingsFile, &r) == nil {
+		counts := map[string]int{}
+		for _, f := range r.Findings {
+			counts[f.Severity]++
+		}
+		info = append(info, fmt.Sprintf("Findings: | %d critical, %d warning, %d info",
+			counts[SeverityCritical], counts[SeverityWarning], counts[SeverityInfo]))
+	}
+	c.UI.Output(columnize.SimpleFormat(info))
+
+	if len(m.Tasks) > 0 {
+		results := map[string][]TaskResult{}
+		failed := []string{"Task | Status | Exit | Detail"}
+		for _, r := range m.Tasks {
+			results[r.Module] = append(results[r.Module], r)
+			switch r.Status {
+			case StatusOK, StatusSkipped:
+				continue
+			}
+			failed = append(failed, fmt.Sprintf("%s | %s | %d | %s", r.OutputFile(), r.Status, r.ExitStatus, strings.Join(r.Argv, " ")))
+		}
+		rows := []string{summaryHeader}
+		for _, module := range modules {
+			rows = append(rows, summaryRow(module, results[module]))
+		}
+		c.UI.Output("\nTasks:\n" + columnize.SimpleFormat(rows))
+		if len(failed) > 1 {
+			c.UI.Output("\nFailed tasks:\n" + columnize.SimpleFormat(failed))
+		}
+	}
+
+	files := []string{"Bytes | File"}
+	var total int64
+	for _, name := range z.Names() {
+		files = append(files, fmt.Sprintf("%d | %s", z.Size(name), name))
+		total += z.Size(name)
+	}
+	c.UI.Output("\nFiles:\n" + columnize.SimpleFormat(files))
+	c.UI.Output(fmt.Sprintf("\n%d file(s), %d bytes uncompressed.", len(z.Names()), total))
+	return 0
+}
+
+// Synopsis output
+func (c *InspectCommand) Synopsis() string {
+	return "Summarize, print or search a rover archive without extracting it"
+}
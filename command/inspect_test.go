@@ -0,0 +1,58 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/pierrre/archivefile/zip"
+)
+
+func TestInspect(t *testing.T) {
+	dir := writeBundle(t, map[string]string{
+		"penguin/manifest.json": `{"hostname": "penguin", "os": "linux", "profiles": {"consul": "standard"}, "tasks": [
+  {"module": "consul", "output": "consul_version", "argv": ["consul", "version"], "status": "ok", "bytes": 42},
+  {"module": "consul", "output": "consul_members", "argv": ["consul", "members"], "status": "failed", "exit_status": 2}
+]}`,
+		"penguin/consul/consul_version.txt": "Consul v1.4.3\nProtocol 2 spoken by default\n",
+		"penguin/consul/consul_members.txt": "Error retrieving members: Permission denied\n",
+	})
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "rover-penguin-20190101000000.zip")
+	if err := zip.ArchiveFile(filepath.Join(dir, "penguin"), archive, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ui := new(cli.MockUi)
+	c := &InspectCommand{UI: ui}
+	if code := c.Run([]string{archive}); code != 0 {
+		t.Fatalf("inspect failed: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	out := ui.OutputWriter.String()
+	for _, want := range []string{"penguin", "consul (standard)", "1.4.3", "consul/consul_members.txt  failed  2", "consul/consul_version.txt"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in summary:\n%s", want, out)
+		}
+	}
+
+	ui = new(cli.MockUi)
+	c = &InspectCommand{UI: ui}
+	if code := c.Run([]string{"-cat", "consul/consul_version.txt", archive}); code != 0 || !strings.HasPrefix(ui.OutputWriter.String(), "Consul v1.4.3\n") {
+		t.Fatalf("expected the file contents, got %d: %q", code, ui.OutputWriter.String())
+	}
+
+	ui = new(cli.MockUi)
+	c = &InspectCommand{UI: ui}
+	if code := c.Run([]string{"-grep", "(?i)denied", archive, "consul/*"}); code != 0 {
+		t.Fatalf("expected a match, got %d", code)
+	}
+	if got := ui.OutputWriter.String(); got != "consul/consul_members.txt:1: Error retrieving members: Permission denied\n" {
+		t.Fatalf("unexpected grep output %q", got)
+	}
+	c = &InspectCommand{UI: new(cli.MockUi)}
+	if code := c.Run([]string{"-grep", "denied", archive, "system/*"}); code != 1 {
+		t.Fatalf("expected no match outside the given files, got %d", code)
+	}
+}
@@ -0,0 +1,142 @@
+// Package command for manifest
+// Manifest records the result of every task in a collection so that the
+// bundle describes what succeeded, failed or was skipped without reading
+// rover.log
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ManifestFile is the name of the manifest at the root of the host directory
+const ManifestFile = "manifest.json"
+
+// Task result statuses recorded in the manifest
+const (
+	StatusOK        = "ok"
+	StatusFailed    = "failed"
+	StatusDenied    = "denied"
+	StatusMissing   = "missing"
+	StatusTimeout   = "timeout"
+	StatusCancelled = "cancelled"
+	StatusSkipped   = "skipped"
+)
+
+// TaskResult describes what happened to a single task
+type TaskResult struct {
+	Module     string    `json:"module"`
+	Output     string    `json:"output"`
+	File       string    `json:"file,omitempty"`
+	Argv       []string  `json:"argv"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	DurationMs int64     `json:"duration_ms"`
+	Status     string    `json:"status"`
+	ExitStatus int       `json:"exit_status"`
+	Binary     string    `json:"binary,omitempty"`
+	Bytes      int64     `json:"bytes"`
+	Truncated  bool      `json:"truncated,omitempty"`
+	SkipReason string    `json:"skip_reason,omitempty"`
+	Redactions int       `json:"redactions"`
+	Unredacted bool      `json:"unredacted,omitempty"`
+	// Error says why rover could not run the task to completion, as
+	// opposed to the task running and failing
+	Error string `json:"error,omitempty"`
+}
+
+// OutputFile returns the path of the result's output file relative to the
+// host directory
+func (r TaskResult) OutputFile() string {
+	if r.File != "" {
+		return r.File
+	}
+	return filepath.Join(r.Module, r.Output+".txt")
+}
+
+// Manifest is the content of manifest.json
+type Manifest struct {
+	HostName string    `json:"hostname"`
+	OS       string    `json:"os"`
+	Updated  time.Time `json:"updated"`
+	// Profiles maps each module to the profile it was last collected with
+	Profiles map[string]string `json:"profiles"`
+	Tasks    []TaskResult      `json:"tasks"`
+	// Files is added by rover archive, which writes the manifest last
+	Files []FileHash `json:"files,omitempty"`
+}
+
+// FileHash is the SHA-256 hash of a file in an archive, by its path
+// relative to the host directory
+type FileHash struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// manifestMu serializes manifest updates within a rover process
+var manifestMu sync.Mutex
+
+// ReadManifest loads manifest.json from the host directory
+func ReadManifest(host string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(filepath.Join(host, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("cannot parse %s with error %v", ManifestFile, err)
+	}
+	return m, nil
+}
+
+// UpdateManifest merges results collected with profile into the manifest for
+// host, replacing every earlier entry for the modules in results so that
+// running a module again reflects only its latest collection
+func UpdateManifest(host string, hostOS string, profile string, results []TaskResult) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	m, err := ReadManifest(host)
+	if os.IsNotExist(err) {
+		m = &Manifest{}
+	} else if err != nil {
+		return err
+	}
+	modules := map[string]bool{}
+	for _, r := range results {
+		modules[r.Module] = true
+	}
+	tasks := []TaskResult{}
+	for _, r := range m.Tasks {
+		if !modules[r.Module] {
+			tasks = append(tasks, r)
+		}
+	}
+	if m.Profiles == nil {
+		m.Profiles = map[string]string{}
+	}
+	for module := range modules {
+		m.Profiles[module] = profile
+	}
+	m.HostName = filepath.Base(host)
+	m.OS = hostOS
+	m.Updated = time.Now().UTC()
+	m.Tasks = append(tasks, results...)
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode %s with error %v", ManifestFile, err)
+	}
+	// Write to a temporary file first so a failed write never leaves a
+	// truncated manifest behind
+	p := filepath.Join(host, ManifestFile)
+	if err := ioutil.WriteFile(p+".tmp", append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("cannot write %s with error %v", ManifestFile, err)
+	}
+	return os.Rename(p+".tmp", p)
+}
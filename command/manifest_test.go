@@ -0,0 +1,52 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rover-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	first := []TaskResult{
+		{Module: "system", Output: "df", Status: StatusOK},
+		{Module: "consul", Output: "consul_info", Status: StatusMissing, ExitStatus: 1},
+	}
+	if err := UpdateManifest(dir, Linux, "minimal", first); err != nil {
+		t.Fatal(err)
+	}
+	// Collecting a module again replaces only that module's entries
+	second := []TaskResult{
+		{Module: "consul", Output: "consul_info", Status: StatusOK},
+		{Module: "consul", Output: "consul_members", Status: StatusFailed, ExitStatus: 2},
+	}
+	if err := UpdateManifest(dir, Linux, "deep", second); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.HostName != filepath.Base(dir) || m.OS != Linux {
+		t.Fatalf("unexpected manifest header %q %q", m.HostName, m.OS)
+	}
+	if m.Profiles["system"] != "minimal" || m.Profiles["consul"] != "deep" {
+		t.Fatalf("unexpected profiles %v", m.Profiles)
+	}
+	want := []string{"system/df.txt ok", "consul/consul_info.txt ok", "consul/consul_members.txt failed"}
+	if len(m.Tasks) != len(want) {
+		t.Fatalf("expected %d tasks, got %d: %v", len(want), len(m.Tasks), m.Tasks)
+	}
+	for i, r := range m.Tasks {
+		if got := r.OutputFile() + " " + r.Status; got != want[i] {
+			t.Fatalf("task %d: expected %q, got %q", i, want[i], got)
+		}
+	}
+}
@@ -4,77 +4,150 @@
 package command
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"time"
 
 	"github.com/briandowns/spinner"
-	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
 )
 
+// nomadTasks are the Nomad probes followed by Nomad-specific operating
+// system tasks based on host OS ID
+var nomadTasks = []Task{
+	{Output: "nomad_status", Command: "nomad", Args: []string{"status"}},
+	{Output: "nomad_version", Command: "nomad", Args: []string{"version"}},
+	{Output: "nomad_operator_raft_listpeers", Command: "nomad", Args: []string{"operator", "raft", "list-peers"}},
+
+	// Nomad log messages from system logs (sudo required)
+	{Output: "nomad_syslog", Command: "grep", Args: []string{"-w", "nomad", "/var/log/system.log"}, OS: []string{Darwin}, Privileged: true, Tags: []string{TagSlow}},
+	{Output: "nomad_syslog", Command: "grep", Args: []string{"-w", "nomad", "/var/log/syslog"}, OS: []string{FreeBSD, Linux}, IfExists: "/var/log/syslog", Privileged: true, Tags: []string{TagSlow}},
+	{Output: "nomad_syslog", Command: "grep", Args: []string{"-w", "nomad", "/var/log/messages"}, OS: []string{FreeBSD, Linux}, IfMissing: "/var/log/syslog", Privileged: true, Tags: []string{TagSlow}},
+
+	// Select process table information when Linux and PID determined
+	{Output: "proc_nomad_limits", Command: "cat", Args: []string{"/proc/{pid}/limits"}, OS: []string{Linux}},
+	{Output: "proc_nomad_status", Command: "cat", Args: []string{"/proc/{pid}/status"}, OS: []string{Linux}},
+	{Output: "proc_nomad_open_file_count", Command: "sh", Args: []string{"-c", "ls /proc/{pid}/fd | wc -l"}, OS: []string{Linux}, Privileged: true},
+	{Output: "systemctl_status_nomad", Command: "systemctl", Args: []string{"status", "nomad"}, OS: []string{Linux}, IfExists: "/run/systemd/system"},
+	{Output: "nomad_journald", Command: "journalctl", Args: []string{"-b", "--no-pager", "-u", "nomad"}, OS: []string{Linux}, IfExists: "/run/systemd/system", Privileged: true, Tags: []string{TagSlow}},
+
+	// Agent and cluster state from the HTTP API; the listings grow with the
+	// cluster so they are capped to keep bundles of large clusters usable
+	{Output: "nomad_agent_self", API: "/v1/agent/self", Ext: "json"},
+	{Output: "nomad_agent_members", API: "/v1/agent/members", Ext: "json"},
+	{Output: "nomad_operator_raft_configuration", API: "/v1/operator/raft/configuration", Ext: "json"},
+	{Output: "nomad_metrics", API: "/v1/metrics", Ext: "json"},
+	{Output: "nomad_nodes", API: "/v1/nodes", Ext: "json", MaxBytes: nomadListMaxBytes},
+	{Output: "nomad_jobs", API: "/v1/jobs", Ext: "json", MaxBytes: nomadListMaxBytes},
+	{Output: "nomad_allocations", API: "/v1/allocations", Ext: "json", MaxBytes: nomadListMaxBytes, Tags: []string{TagSlow}},
+	{Output: "nomad_evaluations", API: "/v1/evaluations", Ext: "json", MaxBytes: nomadListMaxBytes, Tags: []string{TagSlow}},
+
+	// Full Nomad goroutine stack dump and heap dump
+	{Output: "nomad_goroutine", API: "/v1/agent/pprof/goroutine?debug=2", Tags: []string{TagDeep}},
+	{Output: "nomad_heap", API: "/v1/agent/pprof/heap?debug=1", Tags: []string{TagDeep}},
+}
+
+// nomadListMaxBytes caps each cluster wide listing
+const nomadListMaxBytes = 64 << 20
+
+func init() {
+	Collectors.Register(NewTaskSet("nomad", nomadTasks...))
+}
+
 // NomadCommand describes Nomad related fields
 type NomadCommand struct {
-	HostName string
-	OS       string
-	UI       cli.Ui
-	NomadPID string
+	Timeout      time.Duration
+	Parallelism  int
+	Profile      string
+	NoRedact     bool
+	Strict       bool
+	ConfigPath   string
+	OutputDir    string
+	HostName     string
+	OS           string
+	RunContext   *RunContext
+	UI           cli.Ui
+	NomadPID     string
+	NomadVersion string
 }
 
 // Help output
 func (c *NomadCommand) Help() string {
 	helpText := `
-Usage: rover nomad
+Usage: rover nomad [options]
 	Execute a series of Nomad related commands and store output in text files
+
+General Options:
+  -config=<path>	Task configuration file or directory [default: $ROVER_CONFIG_DIR]
+  -output-dir=<dir>	Directory holding the <hostname> data directory [default: $ROVER_OUTPUT_DIR or the latest run]
+  -log-level=<level>	Log level: trace, debug, info, warn or error [default: $ROVER_LOG_LEVEL or info]
+  -log-json		Write rover.log as JSON [default: true when $ROVER_LOG_FORMAT is json]
+  -log-mirror		Print logged warnings to the terminal [default: true]
+  -timeout=<duration>	Default deadline for each task [default: 2m]
+  -parallelism=<n>	Maximum number of tasks to run at once [default: 4]
+  -profile=<name>	Collection profile: minimal, standard, deep or custom [default: standard]
+  -no-redact		Do not redact secrets from output; internal use only [default: false]
+  -strict		Exit non-zero when any task fails, times out or is cancelled [default: false]
 `
 
 	return strings.TrimSpace(helpText)
 }
 
 // Run nomad commands
-func (c *NomadCommand) Run(_ []string) int {
-	n := NomadCommand{}
-	c.OS = runtime.GOOS
-	h, err := GetHostName()
-	if err != nil {
-		out := fmt.Sprintf("Cannot get system hostname with error %v", err)
-		c.UI.Output(out)
-		return 1
+func (c *NomadCommand) Run(args []string) int {
+	run := c.RunContext
+	if run == nil {
+		run = NewRunContext(c.UI)
 	}
-	n.HostName = h
-	// Internal logging
-	l := "rover.log"
-	p := filepath.Join(fmt.Sprintf("%s", c.HostName), "log")
-	if err := os.MkdirAll(p, os.ModePerm); err != nil {
-		out := fmt.Sprintf("Cannot create log directory %s.", p)
-		c.UI.Error(out)
+	c.OS = run.OS
+	cmdFlags := flag.NewFlagSet("nomad", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.StringVar(&c.ConfigPath, "config", "", configPathDescr)
+	cmdFlags.DurationVar(&c.Timeout, "timeout", DefaultTaskTimeout, timeoutDescr)
+	cmdFlags.IntVar(&c.Parallelism, "parallelism", DefaultParallelism, parallelismDescr)
+	cmdFlags.StringVar(&c.Profile, "profile", DefaultProfile, profileDescr)
+	cmdFlags.BoolVar(&c.NoRedact, "no-redact", false, noRedactDescr)
+	cmdFlags.BoolVar(&c.Strict, "strict", false, strictDescr)
+	cmdFlags.StringVar(&c.OutputDir, "output-dir", "", outputDirDescr)
+	run.Flags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
-	f, err := os.OpenFile(filepath.Join(p, l), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		out := fmt.Sprintf("Failed to open log file %s with error: %v", f, err)
-		c.UI.Error(out)
+	if err := run.Open(c.OutputDir, RunReuse); err != nil {
+		c.UI.Error(err.Error())
 		return 1
 	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	logger := hclog.New(&hclog.LoggerOptions{Name: "rover", Level: hclog.LevelFromString("INFO"), Output: w})
+	defer run.Close()
+	c.HostName = run.HostName
+	logger := run.Logger
 	logger.Info("nomad", "hello from the Nomad module at", c.HostName)
 	logger.Info("nomad", "our detected OS", c.OS)
-	p, err = CheckProc("nomad")
+
+	if err := LoadConfig(ConfigPath(c.ConfigPath), Collectors); err != nil {
+		logger.Error("nomad", "cannot load configuration with error", err.Error())
+		c.UI.Error(err.Error())
+		return 1
+	}
+	profile, err := Collectors.Profile(c.Profile)
+	if err != nil {
+		logger.Error("nomad", "cannot find profile with error", err.Error())
+		c.UI.Error(err.Error())
+		return 1
+	}
+	redactor := commandRedactor(logger, c.UI, c.NoRedact)
+	p, err := run.CheckProc("nomad")
 	if err != nil {
-		logger.Warn("nomad", "nomad process not detected:", err.Error())
+		logger.Info("nomad", "nomad process not detected:", err.Error())
 		out := "Nomad process not detected in this environment."
 		c.UI.Warn(out)
 		return 1
 	}
 	c.NomadPID = p
 	// Handle creating the command output directory
-	outPath := filepath.Join(".", fmt.Sprintf("%s/nomad", n.HostName))
+	outPath := filepath.Join(run.HostDir(), "nomad")
 	if err := os.MkdirAll(outPath, os.ModePerm); err != nil {
 		logger.Error("nomad", "cannot create directory", outPath, "error", err.Error())
 		out := fmt.Sprintf("Cannot create directory %s with error %v", outPath, err)
@@ -97,47 +170,38 @@ func (c *NomadCommand) Run(_ []string) int {
 		s.FinalMSG = "Gathered Nomad data\n"
 		s.Start()
 
-		Dump("nomad", "nomad_status", "nomad", "status")
-		Dump("nomad", "nomad_version", "nomad", "version")
-		Dump("nomad", "nomad_operator_raft_listpeers", "nomad", "operator", "raft", "list-peers")
-
-		// Nomad-specific operating system tasks based on host OS ID
-		switch c.OS {
-		case Darwin:
-			logger.Info("attempt to extract nomad log messages from system log (sudo required) ...")
-			Dump("nomad", "nomad_syslog", "grep", "-w", "nomad", "/var/log/system.log")
-		case FreeBSD:
-			// Grep for "nomad" in /var/log/messages or /var/log/syslog (sudo required)
-			logger.Info("attempt to extact nomad log messages from system logs (sudo required) ...")
-			if FileExist("/var/log/syslog") {
-				logger.Info("checking /var/log/syslog for nomad entries (sudo required) ...")
-				Dump("nomad", "nomad_syslog", "grep", "-w", "nomad", "/var/log/syslog")
-			} else {
-				logger.Info("no /var/log/syslog found, checking /var/log/messages for nomad entries (sudo required) ...")
-				Dump("nomad", "nomad_syslog", "grep", "-w", "nomad", "/var/log/messages")
-			}
-		case Linux:
-			// Select process table information when Linux and PID determined
-			Dump("nomad", "proc_nomad_limits", "cat", fmt.Sprintf("/proc/%s/limits", c.NomadPID))
-			Dump("nomad", "proc_nomad_status", "cat", fmt.Sprintf("/proc/%s/status", c.NomadPID))
-			Dump("nomad", "proc_nomad_open_file_count", "sh", "-c", fmt.Sprintf("ls /proc/%s/fd | wc -l", c.NomadPID))
-			// Grep for "nomad" in /var/log/messages or /var/log/syslog (sudo required)
-			logger.Info("attempting to extract nomad log messages from system logs (sudo required) ...")
-			if FileExist("/var/log/syslog") {
-				logger.Info("checking /var/log/syslog for nomad entries (sudo required) ...")
-				Dump("nomad", "nomad_syslog", "grep", "-w", "nomad", "/var/log/syslog")
-			} else {
-				logger.Info("no /var/log/syslog found, checking /var/log/messages for nomad entries (sudo required) ...")
-				Dump("nomad", "nomad_syslog", "grep", "-w", "nomad", "/var/log/messages")
-			}
-			if FileExist("/run/systemd/system") {
-				logger.Info("nomad", "attempting to gather Nomad systemd unit status")
-				Dump("nomad", "systemctl_status_nomad", "systemctl", "status", "nomad")
-				logger.Info("nomad", "attempting to gather Vault logging from systemd journal.")
-				Dump("nomad", "nomad_journald", "journalctl", "-b", "--no-pager", "-u", "nomad")
-			}
+		tasks, err := Collectors.Lookup("nomad")
+		if err != nil {
+			logger.Error("nomad", "cannot find task set with error", err.Error())
+			c.UI.Error(err.Error())
+			s.Stop()
+			return 1
+		}
+		api, err := moduleAPI(Nomad)
+		if err != nil {
+			logger.Warn("nomad", "cannot configure API client, skipping API tasks", err.Error())
+		}
+		ctx, cancel := InterruptContext()
+		defer cancel()
+		c.NomadVersion = moduleVersion(ctx, run, Nomad, api)
+		results := ExecuteTasks(ctx, logger, tasks, TaskEnv{
+			Run:         run,
+			OS:          c.OS,
+			Version:     c.NomadVersion,
+			Vars:        moduleVars(c.NomadPID),
+			Timeout:     c.Timeout,
+			Parallelism: c.Parallelism,
+			Profile:     profile,
+			Redactor:    redactor,
+			API:         api,
+		})
+		if err := UpdateManifest(run.HostDir(), c.OS, profile.Name, results); err != nil {
+			logger.Warn("nomad", "cannot update manifest with error", err.Error())
 		}
 		s.Stop()
+		summary := &Summary{}
+		summary.Add(Nomad, results)
+		return summary.Report(c.UI, c.Strict)
 	} else {
 		logger.Info("no nomad details learned from this environment")
 	}
@@ -0,0 +1,108 @@
+// Package command for profiles
+// Profiles select which subsets of the registered tasks run, from a quick
+// snapshot to a deep dive with profiling data and full logs
+package command
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// TagSampling marks tasks which measure over an interval; tasks with
+	// Sampling set carry it implicitly
+	TagSampling = "sampling"
+	// TagSlow marks tasks which can take a long time or produce a lot of
+	// output, such as package listings and log files
+	TagSlow = "slow"
+	// TagDeep marks opt-in tasks which only run when a profile includes
+	// them, such as pprof dumps
+	TagDeep = "deep"
+
+	// DefaultProfile is used when a command is not given -profile
+	DefaultProfile = "standard"
+
+	profileDescr = "Collection profile: minimal, standard, deep or one defined in configuration"
+)
+
+// Profile selects the tasks to run: a task matching Exclude never runs, and
+// a task tagged deep runs only when it matches Include. Entries match a task
+// tag or a task's "module/output" name
+type Profile struct {
+	Name        string
+	Description string
+	Include     []string
+	Exclude     []string
+}
+
+// builtinProfiles are registered with Collectors
+var builtinProfiles = []Profile{
+	{Name: "minimal", Description: "Quick snapshot without sampling, slow or deep tasks, or kernel parameters", Exclude: []string{TagSampling, TagSlow, "system/sysctl"}},
+	{Name: "standard", Description: "Every task except deep dives"},
+	{Name: "deep", Description: "Every task including pprof dumps", Include: []string{TagDeep}},
+}
+
+func init() {
+	for _, p := range builtinProfiles {
+		Collectors.AddProfile(p)
+	}
+}
+
+// HasTag reports whether the task carries tag
+func (t Task) HasTag(tag string) bool {
+	if tag == TagSampling && t.Sampling {
+		return true
+	}
+	return containsString(t.Tags, tag)
+}
+
+// matches reports whether a profile entry names the task or one of its tags
+func (t Task) matches(entries []string) bool {
+	id := t.Module + "/" + t.Output
+	for _, e := range entries {
+		if e == id || t.HasTag(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the profile runs task t, and if not, why
+func (p *Profile) Allows(t Task) (bool, string) {
+	if t.matches(p.Exclude) {
+		return false, fmt.Sprintf("excluded by profile %s", p.Name)
+	}
+	if t.HasTag(TagDeep) && !t.matches(p.Include) {
+		return false, fmt.Sprintf("not included in profile %s", p.Name)
+	}
+	return true, ""
+}
+
+// AddProfile adds a profile, replacing any profile of the same name
+func (r *Registry) AddProfile(p Profile) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.profiles[p.Name] = &p
+}
+
+// Profile returns the profile registered for name
+func (r *Registry) Profile(name string) (*Profile, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.profiles[name]
+	if !ok {
+		names := make([]string, 0, len(r.profiles))
+		for n := range r.profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("no profile named %q; available profiles are %v", name, names)
+	}
+	return p, nil
+}
+
+// hasProfile reports whether a profile named name is registered
+func (r *Registry) hasProfile(name string) bool {
+	_, err := r.Profile(name)
+	return err == nil
+}
@@ -0,0 +1,101 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rover-profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := `
+profile "network" {
+  description = "Only quick tasks plus the deep socket dump"
+  include     = ["myapp/sockets"]
+  exclude     = ["slow", "sampling", "myapp/uptime"]
+}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "profiles.hcl"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry()
+	for _, p := range builtinProfiles {
+		r.AddProfile(p)
+	}
+	if err := LoadConfig(dir, r); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks := []Task{
+		{Module: "myapp", Output: "status"},
+		{Module: "myapp", Output: "uptime"},
+		{Module: "myapp", Output: "vmstat", Sampling: true},
+		{Module: "myapp", Output: "logs", Tags: []string{TagSlow}},
+		{Module: "myapp", Output: "heap", Tags: []string{TagDeep}},
+		{Module: "myapp", Output: "sockets", Tags: []string{TagDeep}},
+	}
+	want := map[string][]string{
+		"minimal":  {"status", "uptime"},
+		"standard": {"status", "uptime", "vmstat", "logs"},
+		"deep":     {"status", "uptime", "vmstat", "logs", "heap", "sockets"},
+		"network":  {"status", "sockets"},
+	}
+	for name, outputs := range want {
+		p, err := r.Profile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, task := range tasks {
+			if ok, _ := p.Allows(task); ok {
+				got = append(got, task.Output)
+			}
+		}
+		if len(got) != len(outputs) {
+			t.Fatalf("profile %s: expected %v, got %v", name, outputs, got)
+		}
+		for i := range got {
+			if got[i] != outputs[i] {
+				t.Fatalf("profile %s: expected %v, got %v", name, outputs, got)
+			}
+		}
+	}
+
+	// Kernel parameters and the system journal are part of the default
+	// bundle, and only a minimal snapshot leaves them out
+	system, err := Collectors.Lookup("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range system.Tasks() {
+		if task.Output != "sysctl" && task.Output != "journalctl_system" {
+			continue
+		}
+		for name, allowed := range map[string]bool{"minimal": false, "standard": true, "deep": true} {
+			p, err := r.Profile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, _ := p.Allows(task); ok != allowed {
+				t.Errorf("profile %s: expected system/%s allowed to be %v", name, task.Output, allowed)
+			}
+		}
+	}
+
+	if _, err := r.Profile("missing"); err == nil {
+		t.Fatal("expected an unknown profile to be an error")
+	}
+	override := `profile "minimal" { exclude = ["slow"] }`
+	if err := ioutil.WriteFile(filepath.Join(dir, "profiles.hcl"), []byte(override), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfig(dir, r); err == nil {
+		t.Fatal("expected replacing a built in profile without override to fail")
+	}
+}
@@ -0,0 +1,192 @@
+// Package command for redaction
+// Redaction scrubs tokens, keys and passwords from captured output before
+// it is bundled so that bundles can be shared outside the organization
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+)
+
+const (
+	// RedactedText replaces secrets matched by rules without a replacement
+	RedactedText = "[REDACTED]"
+
+	noRedactDescr = "Disable redaction of secrets in captured output (internal use only)"
+)
+
+// RedactRule replaces every match of Pattern on a line with Replace, which
+// can refer to capture groups as ${1} to keep the text around a secret
+type RedactRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Replace string
+}
+
+// builtinRedactRules cover the secrets most often found in bundles; the
+// private key rule catches keys on a single line, e.g. inside JSON, while
+// multiline keys are handled by the Redactor itself
+var builtinRedactRules = []RedactRule{
+	{Name: "private_key", Pattern: regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----.*?-----END [A-Z0-9 ]*PRIVATE KEY-----`), Replace: "[REDACTED PRIVATE KEY]"},
+	{Name: "token_argument", Pattern: regexp.MustCompile(`(?i)((?:^|\s)--?(?:token|client-token|acl-token|http-token)[= ]"?)[^\s"']+`), Replace: "${1}" + RedactedText},
+	{Name: "token_header", Pattern: regexp.MustCompile(`(?i)(x-(?:consul|vault|nomad)-token:\s*)[^\s"']+`), Replace: "${1}" + RedactedText},
+	{Name: "bearer", Pattern: regexp.MustCompile(`(?i)(\bbearer\s+)[A-Za-z0-9._~+/=-]+`), Replace: "${1}" + RedactedText},
+	{Name: "vault_token", Pattern: regexp.MustCompile(`\b(?:hv[sbr]|[sbr])\.[A-Za-z0-9_-]{20,}`), Replace: RedactedText},
+	{Name: "aws_access_key", Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), Replace: RedactedText},
+	{Name: "aws_secret_key", Pattern: regexp.MustCompile(`(?i)(aws_secret_access_key["']?\s*[=:]\s*["']?)[A-Za-z0-9/+=]{40}`), Replace: "${1}" + RedactedText},
+	{Name: "url_password", Pattern: regexp.MustCompile(`([A-Za-z][A-Za-z0-9+.-]*://[^:/\s@]+:)[^@\s/]+@`), Replace: "${1}" + RedactedText + "@"},
+	{Name: "password_argument", Pattern: regexp.MustCompile(`(?i)((?:^|\s)--?(?:password|passwd|secret)[= ]"?)[^\s"']+`), Replace: "${1}" + RedactedText},
+	{Name: "env_secret", Pattern: regexp.MustCompile(`(\b[A-Z0-9_]*(?:PASSWORD|PASSWD|SECRET|TOKEN|API_KEY)[A-Z0-9_]*=["']?)[^\s"']+`), Replace: "${1}" + RedactedText},
+}
+
+var (
+	privateKeyBegin = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----`)
+	privateKeyEnd   = regexp.MustCompile(`-----END [A-Z0-9 ]*PRIVATE KEY-----`)
+)
+
+func init() {
+	for _, rule := range builtinRedactRules {
+		Collectors.AddRedactRule(rule)
+	}
+}
+
+// Redactor applies redaction rules to captured output
+type Redactor struct {
+	rules []RedactRule
+}
+
+// NewRedactor returns a Redactor applying rules in order
+func NewRedactor(rules ...RedactRule) *Redactor {
+	return &Redactor{rules: rules}
+}
+
+// RedactLine applies every rule to a single line and returns the result and
+// the number of secrets replaced
+func (r *Redactor) RedactLine(line string) (string, int) {
+	count := 0
+	for _, rule := range r.rules {
+		if n := len(rule.Pattern.FindAllStringIndex(line, -1)); n > 0 {
+			count += n
+			line = rule.Pattern.ReplaceAllString(line, rule.Replace)
+		}
+	}
+	return line, count
+}
+
+// Redact copies in to out line by line, replacing secrets and dropping the
+// body of multiline private keys; it returns the number of secrets replaced
+func (r *Redactor) Redact(in io.Reader, out io.Writer) (int, error) {
+	br := bufio.NewReader(in)
+	bw := bufio.NewWriter(out)
+	count := 0
+	inKey := false
+	for {
+		line, readErr := br.ReadString('\n')
+		if len(line) > 0 {
+			switch {
+			case inKey:
+				if privateKeyEnd.MatchString(line) {
+					inKey = false
+				}
+				line = ""
+			case privateKeyBegin.MatchString(line) && !privateKeyEnd.MatchString(line):
+				loc := privateKeyBegin.FindStringIndex(line)
+				line = line[:loc[0]] + "[REDACTED PRIVATE KEY]\n"
+				inKey = true
+				count++
+			default:
+				var n int
+				line, n = r.RedactLine(line)
+				count += n
+			}
+			if _, err := bw.WriteString(line); err != nil {
+				return count, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return count, readErr
+		}
+	}
+	return count, bw.Flush()
+}
+
+// RedactFile redacts the file at path in place, going through a temporary
+// file so a failure never leaves partially redacted output behind
+func (r *Redactor) RedactFile(path string) (int, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	tmp := path + ".redact"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	count, err := r.Redact(in, out)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return count, fmt.Errorf("cannot redact %s with error %v", path, err)
+	}
+	if count == 0 {
+		return 0, os.Remove(tmp)
+	}
+	return count, os.Rename(tmp, path)
+}
+
+// commandRedactor returns the Redactor for a collector command, or nil with
+// a warning when redaction is disabled with -no-redact
+func commandRedactor(logger hclog.Logger, ui cli.Ui, noRedact bool) *Redactor {
+	if !noRedact {
+		return Collectors.Redactor()
+	}
+	logger.Info("redact", "redaction disabled with -no-redact; output may contain secrets")
+	ui.Warn("Redaction is disabled; captured output may contain secrets and is for internal use only.")
+	return nil
+}
+
+// AddRedactRule adds a rule, replacing any rule of the same name in place
+func (r *Registry) AddRedactRule(rule RedactRule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.redactRules {
+		if existing.Name == rule.Name {
+			r.redactRules[i] = rule
+			return
+		}
+	}
+	r.redactRules = append(r.redactRules, rule)
+}
+
+// hasRedactRule reports whether a rule named name is registered
+func (r *Registry) hasRedactRule(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rule := range r.redactRules {
+		if rule.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Redactor returns a Redactor for every registered rule
+func (r *Registry) Redactor() *Redactor {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rules := make([]RedactRule, len(r.redactRules))
+	copy(rules, r.redactRules)
+	return NewRedactor(rules...)
+}
@@ -0,0 +1,265 @@
+// Package command for analyzer rules
+// Rules describe checks over captured output as data, so that site specific
+// checks can be added with rule blocks in configuration without rebuilding
+package command
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Rule parsers
+const (
+	// ParserMatch counts the lines matching the pattern
+	ParserMatch = "match"
+	// ParserRegex yields the value, and optionally name, capture groups of
+	// every match of the pattern
+	ParserRegex = "regex"
+	// ParserTable yields a column of each row of a whitespace aligned table
+	ParserTable = "table"
+)
+
+var (
+	ruleParsers = []string{ParserMatch, ParserRegex, ParserTable}
+	ruleOps     = []string{"<", "<=", ">", ">=", "==", "!="}
+)
+
+// Rule checks one captured output: the parser extracts values from File,
+// each value is compared with the thresholds using Op and the most severe
+// threshold crossed gives the severity of the finding
+type Rule struct {
+	Name string
+	// File is the output to read, relative to the host directory, e.g.
+	// system/df.txt
+	File    string
+	Parser  string
+	Pattern *regexp.Regexp
+	// Header is the first column name of the table header line; Column is
+	// compared and NameColumn names each row, defaulting to Header
+	Header     string
+	Column     string
+	NameColumn string
+	Op         string
+	// Thresholds maps severities to the value compared against
+	Thresholds map[string]string
+	// Message can refer to {name}, {value} and {threshold}
+	Message string
+}
+
+// ruleValue is a value extracted by a rule parser and the name of what it
+// describes, e.g. a mount point or a network interface
+type ruleValue struct {
+	Name  string
+	Value string
+}
+
+// values extracts the values to compare from the text of the output
+func (rule Rule) values(text string) []ruleValue {
+	values := []ruleValue{}
+	switch rule.Parser {
+	case ParserMatch:
+		// The first matching line names the finding
+		v := ruleValue{}
+		count := 0
+		for _, line := range strings.Split(text, "\n") {
+			if rule.Pattern.MatchString(line) {
+				if count == 0 {
+					v.Name = strings.TrimSpace(line)
+				}
+				count++
+			}
+		}
+		v.Value = strconv.Itoa(count)
+		values = append(values, v)
+	case ParserRegex:
+		names := rule.Pattern.SubexpNames()
+		for _, m := range rule.Pattern.FindAllStringSubmatch(text, -1) {
+			v := ruleValue{}
+			for i, n := range names {
+				switch n {
+				case "name":
+					v.Name = m[i]
+				case "value":
+					v.Value = m[i]
+				}
+			}
+			values = append(values, v)
+		}
+	case ParserTable:
+		nameColumn := rule.NameColumn
+		if nameColumn == "" {
+			nameColumn = rule.Header
+		}
+		for _, row := range parseTable(text, rule.Header) {
+			values = append(values, ruleValue{Name: row[nameColumn], Value: row[rule.Column]})
+		}
+	}
+	return values
+}
+
+// Evaluate runs the rule over b and returns its findings for module; the
+// error is that of reading the output, e.g. when it was not captured
+func (rule Rule) Evaluate(module string, b Bundle) ([]Finding, error) {
+	data, err := b.ReadFile(rule.File)
+	if err != nil {
+		return nil, err
+	}
+	findings := []Finding{}
+	for _, v := range rule.values(string(data)) {
+		v.Value = strings.TrimSpace(v.Value)
+		for _, severity := range []string{SeverityCritical, SeverityWarning, SeverityInfo} {
+			threshold, ok := rule.Thresholds[severity]
+			if !ok || !compareRuleValue(v.Value, rule.Op, threshold) {
+				continue
+			}
+			message := strings.NewReplacer("{name}", v.Name, "{value}", v.Value, "{threshold}", threshold).Replace(rule.Message)
+			findings = append(findings, Finding{Module: module, Check: rule.Name, Severity: severity, Message: message, Sources: []string{rule.File}})
+			break
+		}
+	}
+	return findings, nil
+}
+
+// compareRuleValue compares value with threshold; values are compared as
+// numbers, ignoring a trailing percent sign, when both parse as numbers and
+// otherwise as strings, which only the equality operators support
+func compareRuleValue(value, op, threshold string) bool {
+	v, vErr := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	t, tErr := strconv.ParseFloat(strings.TrimSuffix(threshold, "%"), 64)
+	if vErr != nil || tErr != nil {
+		switch op {
+		case "==":
+			return value == threshold
+		case "!=":
+			return value != threshold
+		}
+		return false
+	}
+	switch op {
+	case "<":
+		return v < t
+	case "<=":
+		return v <= t
+	case ">":
+		return v > t
+	case ">=":
+		return v >= t
+	case "==":
+		return v == t
+	case "!=":
+		return v != t
+	}
+	return false
+}
+
+// ruleConfig is the decoded form of a rule block
+type ruleConfig struct {
+	File       string `hcl:"file"`
+	Parser     string `hcl:"parser"`
+	Pattern    string `hcl:"pattern"`
+	Header     string `hcl:"header"`
+	Column     string `hcl:"column"`
+	NameColumn string `hcl:"name_column"`
+	Op         string `hcl:"op"`
+	// Thresholds are numbers or strings
+	Critical interface{} `hcl:"critical"`
+	Warning  interface{} `hcl:"warning"`
+	Info     interface{} `hcl:"info"`
+	Message  string      `hcl:"message"`
+	Override bool        `hcl:"override"`
+}
+
+// rule builds the rule named name, returning every problem with the block
+func (rc ruleConfig) rule(name string) (Rule, []string) {
+	problems := []string{}
+	rule := Rule{
+		Name:       name,
+		File:       rc.File,
+		Parser:     rc.Parser,
+		Header:     rc.Header,
+		Column:     rc.Column,
+		NameColumn: rc.NameColumn,
+		Op:         rc.Op,
+		Thresholds: map[string]string{},
+		Message:    rc.Message,
+	}
+	if rule.Op == "" {
+		rule.Op = ">"
+	}
+	if rc.File == "" {
+		problems = append(problems, fmt.Sprintf("rule %q has no file", name))
+	}
+	if rc.Message == "" {
+		problems = append(problems, fmt.Sprintf("rule %q has no message", name))
+	}
+	if !containsString(ruleOps, rule.Op) {
+		problems = append(problems, fmt.Sprintf("rule %q has unknown op %q; use one of %s", name, rc.Op, strings.Join(ruleOps, " ")))
+	}
+	switch rc.Parser {
+	case ParserMatch, ParserRegex:
+		re, err := regexp.Compile(rc.Pattern)
+		if rc.Pattern == "" {
+			problems = append(problems, fmt.Sprintf("rule %q has no pattern", name))
+		} else if err != nil {
+			problems = append(problems, fmt.Sprintf("rule %q has invalid pattern with error %v", name, err))
+		} else if rc.Parser == ParserRegex && !containsString(re.SubexpNames(), "value") {
+			problems = append(problems, fmt.Sprintf("rule %q pattern has no value group, e.g. (?P<value>\\d+)", name))
+		}
+		rule.Pattern = re
+	case ParserTable:
+		if rc.Header == "" || rc.Column == "" {
+			problems = append(problems, fmt.Sprintf("rule %q must set header and column for the table parser", name))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("rule %q has unknown parser %q; use one of %s", name, rc.Parser, strings.Join(ruleParsers, ", ")))
+	}
+	for severity, threshold := range map[string]interface{}{SeverityCritical: rc.Critical, SeverityWarning: rc.Warning, SeverityInfo: rc.Info} {
+		switch v := threshold.(type) {
+		case nil:
+		case string, int, float64:
+			rule.Thresholds[severity] = fmt.Sprint(v)
+		default:
+			problems = append(problems, fmt.Sprintf("rule %q has invalid %s threshold; use a number or string", name, severity))
+		}
+	}
+	if len(rule.Thresholds) == 0 {
+		problems = append(problems, fmt.Sprintf("rule %q must set at least one of critical, warning or info", name))
+	}
+	return rule, problems
+}
+
+// AddRule adds a rule, replacing any rule of the same name in place
+func (r *Registry) AddRule(rule Rule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.rules {
+		if existing.Name == rule.Name {
+			r.rules[i] = rule
+			return
+		}
+	}
+	r.rules = append(r.rules, rule)
+}
+
+// hasRule reports whether a rule named name is registered
+func (r *Registry) hasRule(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rule := range r.rules {
+		if rule.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules returns the registered rules in the order they were added
+func (r *Registry) Rules() []Rule {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rules := make([]Rule, len(r.rules))
+	copy(rules, r.rules)
+	return rules
+}
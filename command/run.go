@@ -0,0 +1,403 @@
+// Package command for run contexts
+// A run context is made once by main and handed to every command, which
+// passes it on to its tasks and helpers: it carries the hostname, OS,
+// options, runner and file system and the single logger of the run, and
+// the output directory holding the <hostname> data directory, which every
+// command and rover archive agree on, locked while a command writes to it
+// so that simultaneous runs are detected rather than clobbering each other
+package command
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+)
+
+const (
+	// OutputDirEnv names the environment variable which sets the output
+	// directory when -output-dir is not given
+	OutputDirEnv = "ROVER_OUTPUT_DIR"
+	// LogLevelEnv names the environment variable which sets the log level
+	// when -log-level is not given
+	LogLevelEnv = "ROVER_LOG_LEVEL"
+	// LogFormatEnv names the environment variable which, set to json,
+	// writes rover.log as JSON when -log-json is not given
+	LogFormatEnv = "ROVER_LOG_FORMAT"
+	// RunLockFile is the lock file held in the output directory while a
+	// command writes to it
+	RunLockFile    = "rover.lock"
+	runDirPrefix   = "run-"
+	outputDirDescr = "Directory holding the <hostname> data directory"
+	logLevelDescr  = "Log level: trace, debug, info, warn or error"
+	logJSONDescr   = "Write rover.log as JSON"
+	logMirrorDescr = "Print logged warnings to the terminal"
+)
+
+// RunOptions are the options which apply to every command of a run
+type RunOptions struct {
+	LogLevel string
+	LogJSON  bool
+	// MirrorWarnings prints logged warnings to the terminal as well
+	MirrorWarnings bool
+}
+
+// RunContext is the output directory of a run, the host whose data is
+// written in it and the logger writing its rover.log
+type RunContext struct {
+	HostName string
+	OS       string
+	// OutputDir holds the HostName data directory
+	OutputDir string
+	// Owned is set when the output directory was made under the state
+	// directory rather than given, so that it is removed once archived
+	Owned   bool
+	Options RunOptions
+	// Logger writes to rover.log while the run is open and discards
+	// everything before
+	Logger hclog.Logger
+	UI     cli.Ui
+	// Runner runs the commands of tasks and probes, and FS reads the host
+	// for them; tests replace both with fakes
+	Runner Runner
+	FS     FileSystem
+
+	// opened counts the commands using the run, as rover all runs rover
+	// archive in the same run
+	opened int
+	// lock is the lock file this run took, if any
+	lock   string
+	log    *os.File
+	buf    *bufio.Writer
+	writer *runLogWriter
+	// mu is held by the logger while it writes to buf
+	mu sync.Mutex
+}
+
+// Run modes for Open
+const (
+	// RunReuse uses the latest run under the state directory, or starts a
+	// new one when there is none
+	RunReuse = iota
+	// RunNew always starts a new run under the state directory
+	RunNew
+	// RunExisting uses the latest run under the state directory and fails
+	// when there is none
+	RunExisting
+)
+
+// NewRunContext returns the context for a run on this host with options
+// from the environment; ui, which may be nil, is where warnings are
+// mirrored
+func NewRunContext(ui cli.Ui) *RunContext {
+	// An error is reported by Open, which needs the hostname
+	h, _ := GetHostName()
+	level := os.Getenv(LogLevelEnv)
+	if level == "" {
+		level = "info"
+	}
+	return &RunContext{
+		HostName: h,
+		OS:       runtime.GOOS,
+		Options: RunOptions{
+			LogLevel:       level,
+			LogJSON:        strings.EqualFold(os.Getenv(LogFormatEnv), "json"),
+			MirrorWarnings: true,
+		},
+		Logger: hclog.NewNullLogger(),
+		UI:     ui,
+		Runner: execRunner{},
+		FS:     osFS{},
+	}
+}
+
+// Flags adds the flags for the run options to f
+func (r *RunContext) Flags(f *flag.FlagSet) {
+	f.StringVar(&r.Options.LogLevel, "log-level", r.Options.LogLevel, logLevelDescr)
+	f.BoolVar(&r.Options.LogJSON, "log-json", r.Options.LogJSON, logJSONDescr)
+	f.BoolVar(&r.Options.MirrorWarnings, "log-mirror", r.Options.MirrorWarnings, logMirrorDescr)
+}
+
+// StateDir is the directory under which runs are made when no output
+// directory is given: $XDG_STATE_HOME/rover or ~/.local/state/rover, or
+// rover in the temporary directory when there is no home directory
+func StateDir() string {
+	if d := os.Getenv("XDG_STATE_HOME"); d != "" {
+		return filepath.Join(d, "rover")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "state", "rover")
+	}
+	return filepath.Join(os.TempDir(), "rover")
+}
+
+// OpenRun opens a run with the default options in dir, as Open does
+func OpenRun(dir string, mode int) (*RunContext, error) {
+	r := NewRunContext(nil)
+	if err := r.Open(dir, mode); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Open finds the output directory of the run, from dir when it is not
+// empty, then $ROVER_OUTPUT_DIR, then the state directory according to
+// mode, locks it and opens rover.log. A run which is already open is
+// shared
+func (r *RunContext) Open(dir string, mode int) error {
+	if r.opened > 0 {
+		if dir != "" && filepath.Clean(dir) != filepath.Clean(r.OutputDir) {
+			return fmt.Errorf("cannot use %s while the run in %s is open", dir, r.OutputDir)
+		}
+		r.opened++
+		return nil
+	}
+	level := hclog.LevelFromString(r.Options.LogLevel)
+	if level == hclog.NoLevel {
+		return fmt.Errorf("unknown log level %q; use one of trace, debug, info, warn or error", r.Options.LogLevel)
+	}
+	if r.HostName == "" {
+		h, err := GetHostName()
+		if err != nil {
+			return err
+		}
+		r.HostName = h
+	}
+	r.OutputDir, r.Owned = dir, false
+	if r.OutputDir == "" {
+		r.OutputDir = os.Getenv(OutputDirEnv)
+	}
+	if r.OutputDir == "" {
+		state := StateDir()
+		if mode != RunNew {
+			r.OutputDir = latestRun(state, r.HostName)
+		}
+		if r.OutputDir == "" && mode == RunExisting {
+			return fmt.Errorf("no rover data found in %s; please use rover commands to generate data first, or use -output-dir", state)
+		}
+		if r.OutputDir == "" {
+			if err := os.MkdirAll(state, 0700); err != nil {
+				return fmt.Errorf("cannot create state directory %s with error %v", state, err)
+			}
+			// The timestamp orders runs and the random suffix keeps runs
+			// started in the same second apart
+			d, err := ioutil.TempDir(state, runDirPrefix+time.Now().Format("20060102150405")+"-")
+			if err != nil {
+				return fmt.Errorf("cannot create run directory with error %v", err)
+			}
+			r.OutputDir = d
+		}
+		r.Owned = true
+	}
+	if err := os.MkdirAll(r.OutputDir, os.ModePerm); err != nil {
+		return fmt.Errorf("cannot create output directory %s with error %v", r.OutputDir, err)
+	}
+	if err := r.takeLock(); err != nil {
+		return err
+	}
+	if err := r.openLog(level); err != nil {
+		r.releaseLock()
+		return err
+	}
+	r.opened = 1
+	return nil
+}
+
+// latestRun returns the newest run directory under state which holds data
+// for host, if any
+func latestRun(state, host string) string {
+	matches, _ := filepath.Glob(filepath.Join(state, runDirPrefix+"*"))
+	sort.Strings(matches)
+	for i := len(matches) - 1; i >= 0; i-- {
+		if info, err := os.Stat(filepath.Join(matches[i], host)); err == nil && info.IsDir() {
+			return matches[i]
+		}
+	}
+	return ""
+}
+
+// takeLock creates the lock file holding the PID of this process, taking
+// it over when the process which left it has gone; a lock already held by
+// this process is shared
+func (r *RunContext) takeLock() error {
+	p := filepath.Join(r.OutputDir, RunLockFile)
+	for i := 0; i < 2; i++ {
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(p)
+				return fmt.Errorf("cannot write lock file %s with error %v", p, err)
+			}
+			r.lock = p
+			return nil
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("cannot create lock file %s with error %v", p, err)
+		}
+		b, _ := ioutil.ReadFile(p)
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+		if pid == os.Getpid() {
+			return nil
+		}
+		if pid > 0 && processAlive(pid) {
+			return fmt.Errorf("another rover run (pid %d) is using %s; wait for it to finish or use -output-dir", pid, r.OutputDir)
+		}
+		// The lock was left behind by a run which did not finish
+		os.Remove(p)
+	}
+	return fmt.Errorf("cannot take lock file %s", p)
+}
+
+// releaseLock removes the lock file taken by the run, if any
+func (r *RunContext) releaseLock() error {
+	if r.lock == "" {
+		return nil
+	}
+	err := os.Remove(r.lock)
+	r.lock = ""
+	return err
+}
+
+// openLog opens rover.log in the data directory for the logger of the run
+func (r *RunContext) openLog(level hclog.Level) error {
+	p := filepath.Join(r.HostDir(), "log")
+	if err := os.MkdirAll(p, os.ModePerm); err != nil {
+		return fmt.Errorf("Cannot create log directory %s.", p)
+	}
+	f, err := os.OpenFile(filepath.Join(p, "rover.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to open log file %s with error: %v", filepath.Join(p, "rover.log"), err)
+	}
+	r.log, r.buf = f, bufio.NewWriter(f)
+	r.writer = &runLogWriter{file: r.buf}
+	if r.Options.MirrorWarnings {
+		r.writer.ui = r.UI
+	}
+	r.Logger = hclog.New(&hclog.LoggerOptions{Name: "rover", Level: level, Output: r.writer, Mutex: &r.mu, JSONFormat: r.Options.LogJSON})
+	return nil
+}
+
+// runLogWriter writes log lines to rover.log, printing warnings to the
+// terminal too when ui is set; hclog hands it one whole line at a time,
+// holding the lock of the logger. Once rover.log is closed, lines are only
+// printed
+type runLogWriter struct {
+	file *bufio.Writer
+	ui   cli.Ui
+}
+
+// Write writes a line without a level
+func (w *runLogWriter) Write(p []byte) (int, error) {
+	if w.file == nil {
+		return len(p), nil
+	}
+	return w.file.Write(p)
+}
+
+// LevelWrite writes a line logged at level
+func (w *runLogWriter) LevelWrite(level hclog.Level, p []byte) (int, error) {
+	if level == hclog.Warn && w.ui != nil {
+		w.ui.Warn(strings.TrimSuffix(string(p), "\n"))
+	}
+	return w.Write(p)
+}
+
+// Flush writes what has been logged so far to rover.log, as rover archive
+// does before reading the data directory
+func (r *RunContext) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.buf == nil {
+		return nil
+	}
+	return r.buf.Flush()
+}
+
+// CloseLog flushes and closes rover.log before rover archive removes the
+// data directory holding it; the logger of the run, and every logger taken
+// from it, then only prints warnings to the terminal
+func (r *RunContext) CloseLog() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.log == nil {
+		return nil
+	}
+	err := r.buf.Flush()
+	if cerr := r.log.Close(); err == nil {
+		err = cerr
+	}
+	r.writer.file = nil
+	r.log, r.buf = nil, nil
+	return err
+}
+
+// HostDir is the data directory of the run
+func (r *RunContext) HostDir() string {
+	return filepath.Join(r.OutputDir, r.HostName)
+}
+
+// ArchiveDir is where archives of the run are written unless -path says
+// otherwise: the output directory, or the state directory for runs made
+// there, which are removed once archived
+func (r *RunContext) ArchiveDir() string {
+	if r.Owned {
+		return filepath.Dir(r.OutputDir)
+	}
+	return r.OutputDir
+}
+
+// Close ends one use of the run; the last flushes and closes rover.log,
+// releases the lock taken by the run and, when its data directory has been
+// removed, removes a run directory made under the state directory
+func (r *RunContext) Close() error {
+	if r.opened == 0 {
+		return nil
+	}
+	r.opened--
+	if r.opened > 0 {
+		return nil
+	}
+	err := r.CloseLog()
+	r.Logger = hclog.NewNullLogger()
+	if lerr := r.releaseLock(); err == nil {
+		err = lerr
+	}
+	if r.Owned {
+		// Only succeeds when nothing is left in it
+		os.Remove(r.OutputDir)
+	}
+	return err
+}
+
+// logger returns the logger of the run for helpers, which discards
+// everything when there is no run
+func (r *RunContext) logger() hclog.Logger {
+	if r == nil || r.Logger == nil {
+		return hclog.NewNullLogger()
+	}
+	return r.Logger
+}
+
+// dataDir returns the data directory of the run, where helpers write
+// output, failing when the run is not open
+func (r *RunContext) dataDir() (string, error) {
+	if r == nil || r.opened == 0 {
+		return "", fmt.Errorf("cannot write output without an open rover run")
+	}
+	return r.HostDir(), nil
+}
@@ -0,0 +1,169 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+)
+
+func TestOpenRun(t *testing.T) {
+	state, err := ioutil.TempDir("", "rover-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(state)
+	for k, v := range map[string]string{"XDG_STATE_HOME": state, OutputDirEnv: ""} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	// Archiving needs a run to exist
+	if _, err := OpenRun("", RunExisting); err == nil {
+		t.Fatal("expected no existing run to be found")
+	}
+
+	// A run is made under the state directory, and helpers write to it
+	// while it is open
+	run, err := OpenRun("", RunReuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !run.Owned || filepath.Dir(run.OutputDir) != filepath.Join(state, "rover") || run.ArchiveDir() != filepath.Join(state, "rover") {
+		t.Fatalf("unexpected run directory %s", run.OutputDir)
+	}
+	if h, err := run.dataDir(); err != nil || h != run.HostDir() {
+		t.Fatalf("expected helpers to use %s, got %s: %v", run.HostDir(), h, err)
+	}
+	if err := os.MkdirAll(run.HostDir(), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process cannot use it while this one is, but this process
+	// shares its own lock as rover all does with rover archive
+	if err := ioutil.WriteFile(filepath.Join(run.OutputDir, RunLockFile), []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenRun("", RunExisting); err == nil || !strings.Contains(err.Error(), "another rover run (pid 1)") {
+		t.Fatalf("expected the run to be locked, got %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(run.OutputDir, RunLockFile), []byte(strings.Repeat("9", 9)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A lock left by a process which has gone is taken over
+	again, err := OpenRun("", RunExisting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.OutputDir != run.OutputDir {
+		t.Fatalf("expected the latest run %s to be reused, got %s", run.OutputDir, again.OutputDir)
+	}
+	nested, err := OpenRun(run.OutputDir, RunExisting)
+	if err != nil {
+		t.Fatalf("expected the lock to be shared within a process, got %v", err)
+	}
+	nested.Close()
+	if _, err := os.Stat(filepath.Join(run.OutputDir, RunLockFile)); err != nil {
+		t.Fatalf("expected a shared lock to be kept by its owner, got %v", err)
+	}
+
+	// Starting a new run leaves the other alone
+	fresh, err := OpenRun("", RunNew)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh.OutputDir == run.OutputDir {
+		t.Fatal("expected a new run directory")
+	}
+	// Each run keeps its own data directory while both are open
+	if h, err := run.dataDir(); err != nil || h != run.HostDir() {
+		t.Fatalf("expected helpers of the first run to use %s, got %s: %v", run.HostDir(), h, err)
+	}
+	fresh.Close()
+	if _, err := fresh.dataDir(); err == nil {
+		t.Fatal("expected no output to be written to a closed run")
+	}
+	if _, err := os.Stat(filepath.Join(fresh.HostDir(), "log", "rover.log")); err != nil {
+		t.Fatalf("expected the new run to keep its log, got %v", err)
+	}
+
+	// Once its data is archived and removed, closing removes the run
+	if err := os.RemoveAll(run.HostDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := again.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(run.OutputDir); !os.IsNotExist(err) {
+		t.Fatalf("expected the archived run to be removed, got %v", err)
+	}
+
+	// An output directory which is given is used as is and never removed
+	given := filepath.Join(state, "given")
+	os.Setenv(OutputDirEnv, given)
+	run, err = OpenRun("", RunReuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run.Close()
+	if run.Owned || run.OutputDir != given || run.ArchiveDir() != given {
+		t.Fatalf("unexpected run directory %s", run.OutputDir)
+	}
+	if _, err := os.Stat(given); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rover-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for k, v := range map[string]string{LogLevelEnv: "", LogFormatEnv: ""} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	ui := new(cli.MockUi)
+	run := NewRunContext(ui)
+	run.Options.LogLevel = "loud"
+	if err := run.Open(dir, RunReuse); err == nil || !strings.Contains(err.Error(), "unknown log level") {
+		t.Fatalf("expected an unknown level to be refused, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, RunLockFile)); !os.IsNotExist(err) {
+		t.Fatalf("expected no lock to be taken, got %v", err)
+	}
+
+	// Helpers log to the run while it is open, a command sharing the run
+	// leaves it open, and warnings are printed to the terminal too
+	run.Options = RunOptions{LogLevel: "warn", LogJSON: true, MirrorWarnings: true}
+	if err := run.Open(dir, RunReuse); err != nil {
+		t.Fatal(err)
+	}
+	if err := run.Open("", RunExisting); err != nil {
+		t.Fatalf("expected the open run to be shared, got %v", err)
+	}
+	run.Close()
+	run.logger().Info("test", "ignored", "info")
+	run.logger().Warn("test", "mirrored", "warning")
+	if err := run.Close(); err != nil {
+		t.Fatal(err)
+	}
+	run.logger().Warn("test", "discarded", "after close")
+
+	if !strings.Contains(ui.ErrorWriter.String(), "mirrored") || strings.Contains(ui.ErrorWriter.String(), "ignored") {
+		t.Errorf("expected only the warning on the terminal, got %q", ui.ErrorWriter.String())
+	}
+	b, err := ioutil.ReadFile(filepath.Join(run.HostDir(), "log", "rover.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "{") || !strings.Contains(lines[0], `"mirrored"`) {
+		t.Errorf("expected one JSON warning in the log, got %q", b)
+	}
+}
@@ -0,0 +1,122 @@
+// Package command for runners
+// Runners start the external commands of tasks and probes, and file
+// systems read the host for them, so that the run context can swap both
+// for fakes which replay recorded output in tests
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Runner runs external commands
+type Runner interface {
+	// LookPath finds a command in PATH, as exec.LookPath does
+	LookPath(name string) (string, error)
+	// Run runs name with args until ctx is done, writing its stdout and
+	// stderr to out. It returns the exit status of a command which ran,
+	// and an error when it could not be started or waited for
+	Run(ctx context.Context, out io.Writer, name string, args ...string) (int, error)
+}
+
+// FileSystem reads files on the host
+type FileSystem interface {
+	Stat(name string) (os.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+}
+
+// runnerWaitDelay is how long a command's output is still read once it
+// has exited or been killed; a child which left its own process group, as
+// with setsid, can hold the output open for longer than the command runs
+const runnerWaitDelay = 2 * time.Second
+
+// execRunner runs commands as child processes in their own process group
+type execRunner struct{}
+
+// LookPath finds a command in PATH
+func (execRunner) LookPath(name string) (string, error) {
+	return exec.LookPath(name)
+}
+
+// Run runs a command; when ctx is done its whole process group is killed
+func (execRunner) Run(ctx context.Context, out io.Writer, name string, args ...string) (int, error) {
+	// We audit all command parameters by specifying them explicitly as
+	// tasks and not allowing any form of user input to be passed in for
+	// most command parameter values, so subprocess launching with strict
+	// parameters maybe not too terribly sad here
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.WaitDelay = runnerWaitDelay
+	if err := cmd.Start(); err != nil {
+		return 1, err
+	}
+	err := cmd.Wait()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// The command itself exited; only the output left open was cut off
+		return cmd.ProcessState.ExitCode(), nil
+	}
+	if exiterr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus(), nil
+		}
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// osFS reads the files of the host
+type osFS struct{}
+
+// Stat describes a file
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Open opens a file for reading
+func (osFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+// runner returns the runner of the run, which runs real commands when
+// there is no run
+func (r *RunContext) runner() Runner {
+	if r == nil || r.Runner == nil {
+		return execRunner{}
+	}
+	return r.Runner
+}
+
+// fs returns the file system of the run, which is the one of the host
+// when there is no run
+func (r *RunContext) fs() FileSystem {
+	if r == nil || r.FS == nil {
+		return osFS{}
+	}
+	return r.FS
+}
+
+// commandOutput runs a probe command with the runner of the run and
+// returns its output, failing when it exits with a non-zero status
+func (r *RunContext) commandOutput(name string, args ...string) (string, error) {
+	var out bytes.Buffer
+	status, err := r.runner().Run(context.Background(), &out, name, args...)
+	if err != nil {
+		return "", err
+	}
+	if status != 0 {
+		return out.String(), fmt.Errorf("%s exited with status %d", strings.TrimSpace(name+" "+strings.Join(args, " ")), status)
+	}
+	return out.String(), nil
+}
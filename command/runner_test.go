@@ -0,0 +1,308 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+)
+
+// hostFixture is a host recorded in testdata/hosts: the commands on its
+// PATH, the output and exit status of command lines run on it, and the
+// files present on it
+type hostFixture struct {
+	OS       string   `json:"os"`
+	Path     []string `json:"path"`
+	Commands []struct {
+		Argv   []string `json:"argv"`
+		Output string   `json:"output"`
+		Exit   int      `json:"exit"`
+	} `json:"commands"`
+	Files map[string]string `json:"files"`
+}
+
+// replayRunner replays the command lines of a fixture and records every
+// command line it runs; a command on PATH with no recorded line prints
+// nothing and succeeds, and "*" puts every command on PATH
+type replayRunner struct {
+	fixture *hostFixture
+	mu      sync.Mutex
+	calls   []string
+}
+
+// LookPath finds a command on the PATH of the fixture
+func (r *replayRunner) LookPath(name string) (string, error) {
+	for _, p := range r.fixture.Path {
+		if p == name || p == "*" {
+			return "/usr/bin/" + name, nil
+		}
+	}
+	return "", fmt.Errorf("exec: %q: executable file not found in $PATH", name)
+}
+
+// Run replays the recorded output of a command line
+func (r *replayRunner) Run(ctx context.Context, out io.Writer, name string, args ...string) (int, error) {
+	name = filepath.Base(name)
+	if _, err := r.LookPath(name); err != nil {
+		return 1, err
+	}
+	line := strings.Join(append([]string{name}, args...), " ")
+	r.mu.Lock()
+	r.calls = append(r.calls, line)
+	r.mu.Unlock()
+	for _, c := range r.fixture.Commands {
+		if strings.Join(c.Argv, " ") == line {
+			io.WriteString(out, c.Output)
+			return c.Exit, nil
+		}
+	}
+	return 0, nil
+}
+
+// ran reports whether the command line was run
+func (r *replayRunner) ran(line string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return containsString(r.calls, line)
+}
+
+// fixtureFS holds the files of a fixture
+type fixtureFS map[string]string
+
+// Stat describes a file of the fixture
+func (fs fixtureFS) Stat(name string) (os.FileInfo, error) {
+	data, ok := fs[name]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return fixtureFileInfo{name: filepath.Base(name), size: int64(len(data))}, nil
+}
+
+// Open opens a file of the fixture
+func (fs fixtureFS) Open(name string) (io.ReadCloser, error) {
+	data, ok := fs[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return ioutil.NopCloser(strings.NewReader(data)), nil
+}
+
+// fixtureFileInfo describes a file of a fixture
+type fixtureFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi fixtureFileInfo) Name() string       { return fi.name }
+func (fi fixtureFileInfo) Size() int64        { return fi.size }
+func (fi fixtureFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi fixtureFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fixtureFileInfo) IsDir() bool        { return false }
+func (fi fixtureFileInfo) Sys() interface{}   { return nil }
+
+// openFixture opens a run in a temporary directory which replays the
+// host fixture name, or a host where every command succeeds silently
+// when name is empty; the returned function closes the run and removes
+// the directory
+func openFixture(t *testing.T, name string) (*RunContext, *replayRunner, func()) {
+	fixture := &hostFixture{OS: Linux, Path: []string{"*"}}
+	if name != "" {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", "hosts", name+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(b, fixture); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	dir, err := ioutil.TempDir("", "rover-fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := &replayRunner{fixture: fixture}
+	run := NewRunContext(nil)
+	run.Runner = runner
+	run.FS = fixtureFS(fixture.Files)
+	if err := run.Open(dir, RunReuse); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return run, runner, func() {
+		run.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCheckProcAndVersion(t *testing.T) {
+	for _, tc := range []struct {
+		fixture, module, pid, version string
+	}{
+		{"linux-vault-0.9.1", Vault, "4242", "0.9.1"},
+		{"linux-vault-0.9.1", Consul, "", ""},
+		{"linux-vault-1.0.3", Vault, "1717", "1.0.3"},
+		// Without pgrep the process table from ps is searched
+		{"freebsd-ps", Consul, "907", "1.4.3"},
+		{"freebsd-ps", Nomad, "", ""},
+	} {
+		run, _, done := openFixture(t, tc.fixture)
+		pid, _ := run.CheckProc(tc.module)
+		v, err := run.CheckHashiVersion(tc.module)
+		done()
+		if pid != tc.pid || v != tc.version || err != nil {
+			t.Errorf("%s %s: expected pid %q and version %q, got %q and %q: %v", tc.fixture, tc.module, tc.pid, tc.version, pid, v, err)
+		}
+	}
+}
+
+func TestVaultTasks(t *testing.T) {
+	vault, err := Collectors.Lookup(Vault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		fixture string
+		// ran and notRan are command lines which must and must not run
+		ran, notRan []string
+		status      map[string]string
+	}{
+		{
+			fixture: "linux-vault-0.9.1",
+			ran: []string{"vault audit-list", "vault auth -methods", "vault mounts",
+				"cat /proc/4242/limits", "grep -w vault /var/log/syslog", "systemctl status vault", "journalctl -b --no-pager -u vault"},
+			notRan: []string{"vault audit list", "vault auth list", "vault secrets list", "grep -w vault /var/log/messages"},
+			status: map[string]string{"vault_audit_list": StatusOK, "vault_sys_health": StatusSkipped},
+		},
+		{
+			fixture: "linux-vault-1.0.3",
+			ran: []string{"vault audit list", "vault auth list", "vault secrets list",
+				"cat /proc/1717/limits", "grep -w vault /var/log/messages"},
+			notRan: []string{"vault audit-list", "vault mounts", "grep -w vault /var/log/syslog", "systemctl status vault"},
+			status: map[string]string{"vault_audit_list": StatusFailed, "systemctl_status_vault": StatusSkipped},
+		},
+	} {
+		run, runner, done := openFixture(t, tc.fixture)
+		if err := os.MkdirAll(filepath.Join(run.HostDir(), Vault), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		pid, _ := run.CheckProc(Vault)
+		v, _ := run.CheckHashiVersion(Vault)
+		results := ExecuteTasks(context.Background(), hclog.NewNullLogger(), vault, TaskEnv{
+			Run: run, OS: runner.fixture.OS, Version: v, Vars: moduleVars(pid), Parallelism: 4,
+		})
+		for _, line := range tc.ran {
+			if !runner.ran(line) {
+				t.Errorf("%s: expected %q to run, ran %v", tc.fixture, line, runner.calls)
+			}
+		}
+		for _, line := range tc.notRan {
+			if runner.ran(line) {
+				t.Errorf("%s: expected %q not to run", tc.fixture, line)
+			}
+		}
+		// Variants of a task for other versions are skipped alongside the
+		// one which ran
+		statuses := map[string]string{}
+		for _, r := range results {
+			if statuses[r.Output] == "" || r.Status != StatusSkipped {
+				statuses[r.Output] = r.Status
+			}
+		}
+		for output, want := range tc.status {
+			if statuses[output] != want {
+				t.Errorf("%s: expected %s to be %s, got %s", tc.fixture, output, want, statuses[output])
+			}
+		}
+
+		// The replayed output is what lands in the bundle
+		b, err := ioutil.ReadFile(filepath.Join(run.HostDir(), Vault, "proc_vault_limits.txt"))
+		if err != nil || !strings.Contains(string(b), "Max open files") {
+			t.Errorf("%s: expected the recorded limits, got %q: %v", tc.fixture, b, err)
+		}
+		done()
+	}
+}
+
+func TestCollectorTaskLists(t *testing.T) {
+	// Every task of every built in module which applies on an OS runs its
+	// own command line, and nothing else runs
+	for _, module := range []string{"system", Consul, Nomad, Vault} {
+		c, err := Collectors.Lookup(module)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, goos := range []string{Linux, Darwin, FreeBSD} {
+			run, runner, done := openFixture(t, "")
+			if err := os.MkdirAll(filepath.Join(run.HostDir(), module), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			env := TaskEnv{Run: run, OS: goos, Version: "1.0.0", Vars: moduleVars("1"), Parallelism: 4, Profile: &Profile{Name: "deep", Include: []string{TagDeep}}}
+			results := ExecuteTasks(context.Background(), hclog.NewNullLogger(), c, env)
+			want := map[string]bool{}
+			ran := 0
+			for _, r := range results {
+				if r.Status == StatusSkipped {
+					continue
+				}
+				ran++
+				// Files are copied from the host, which has none
+				if r.Status != StatusOK && !(r.Status == StatusMissing && r.Argv[0] == "copy") {
+					t.Errorf("%s on %s: expected %s to succeed, got %s: %s", module, goos, r.Output, r.Status, r.Error)
+				}
+				want[strings.Join(r.Argv, " ")] = true
+				if _, err := os.Stat(filepath.Join(run.HostDir(), r.OutputFile())); err != nil {
+					t.Errorf("%s on %s: expected output for %s: %v", module, goos, r.Output, err)
+				}
+			}
+			if ran == 0 {
+				t.Errorf("%s on %s: expected tasks to run", module, goos)
+			}
+			for _, line := range runner.calls {
+				if !want[line] {
+					t.Errorf("%s on %s: unexpected command line %q", module, goos, line)
+				}
+			}
+			done()
+		}
+	}
+}
+
+func TestNomadVersionTasks(t *testing.T) {
+	// Version constraints on Nomad tasks are checked against the version
+	// of the nomad binary
+	for _, task := range []Task{
+		{Module: Nomad, Output: "nomad_new", Command: "nomad", Args: []string{"new"}, Version: ">= 0.9.0"},
+		{Module: Nomad, Output: "nomad_old", Command: "nomad", Args: []string{"old"}, Version: "< 0.9.0"},
+	} {
+		if err := Collectors.AddTask(task); err != nil {
+			t.Fatal(err)
+		}
+		defer Collectors.RemoveTask(Nomad, task.Output)
+	}
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	defer os.Setenv("NOMAD_ADDR", os.Getenv("NOMAD_ADDR"))
+	os.Setenv("NOMAD_ADDR", srv.URL)
+
+	run, runner, done := openFixture(t, "linux-nomad-0.8.7")
+	defer done()
+	ui := new(cli.MockUi)
+	c := &NomadCommand{RunContext: run, UI: ui}
+	if code := c.Run([]string{"-output-dir", run.OutputDir}); code != 0 {
+		t.Fatalf("nomad failed: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if c.NomadVersion != "0.8.7" || !runner.ran("nomad old") || runner.ran("nomad new") || !runner.ran("cat /proc/102/limits") {
+		t.Errorf("expected the tasks for 0.8.7 to run, got version %q and %v", c.NomadVersion, runner.calls)
+	}
+}
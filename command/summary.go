@@ -0,0 +1,77 @@
+// Package command for summaries
+// Every collector command ends with a summary of how the tasks of each
+// module went; tasks fail on their own without stopping the collection,
+// and -strict decides whether failures make the exit status non-zero
+package command
+
+import (
+	"fmt"
+
+	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
+)
+
+const strictDescr = "Exit non-zero when any task fails, times out or is cancelled"
+
+// summaryHeader names the columns of summaryRow
+const summaryHeader = "Module | Tasks | OK | Failed | Denied | Missing | Timed out | Cancelled | Skipped | Bytes"
+
+// summaryRow formats the task counts for one module as a columnize row
+func summaryRow(module string, results []TaskResult) string {
+	counts := map[string]int{}
+	var bytes int64
+	for _, r := range results {
+		counts[r.Status]++
+		bytes += r.Bytes
+	}
+	return fmt.Sprintf("%s | %d | %d | %d | %d | %d | %d | %d | %d | %d", module,
+		len(results)-counts[StatusSkipped], counts[StatusOK], counts[StatusFailed], counts[StatusDenied],
+		counts[StatusMissing], counts[StatusTimeout], counts[StatusCancelled], counts[StatusSkipped], bytes)
+}
+
+// Summary collects the task results of each module of a command
+type Summary struct {
+	rows     []string
+	failures int
+}
+
+// Add records the results of module
+func (s *Summary) Add(module string, results []TaskResult) {
+	s.rows = append(s.rows, summaryRow(module, results))
+	for _, r := range results {
+		switch r.Status {
+		case StatusFailed, StatusTimeout, StatusCancelled:
+			s.failures++
+		}
+	}
+}
+
+// Fail records a module which could not be collected at all
+func (s *Summary) Fail(module string) {
+	s.rows = append(s.rows, fmt.Sprintf("%s | - | - | - | - | - | - | - | - | -", module))
+	s.failures++
+}
+
+// Failures is the number of tasks which failed, timed out or were
+// cancelled, plus the modules which could not be collected
+func (s *Summary) Failures() int {
+	return s.failures
+}
+
+// Report prints the summary and returns the exit status for it: 1 when
+// strict and anything failed, else 0. Tasks which were denied or whose
+// command is missing are expected on many hosts and do not count
+func (s *Summary) Report(ui cli.Ui, strict bool) int {
+	if len(s.rows) > 0 {
+		ui.Output(columnize.SimpleFormat(append([]string{summaryHeader}, s.rows...)))
+	}
+	if s.failures == 0 {
+		return 0
+	}
+	if !strict {
+		ui.Warn(fmt.Sprintf("%d task(s) or module(s) failed; see rover.log for details.", s.failures))
+		return 0
+	}
+	ui.Error(fmt.Sprintf("%d task(s) or module(s) failed; exiting with an error because of -strict.", s.failures))
+	return 1
+}
@@ -3,9 +3,8 @@
 package command
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -13,7 +12,6 @@ import (
 	"time"
 
 	"github.com/briandowns/spinner"
-	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
 )
 
@@ -34,43 +32,213 @@ const (
 	Windows string = "windows"
 )
 
+// systemTasks are the operating system probes; common commands and file
+// contents first, followed by the different command subsets chosen by OS.
+// We use runtime.GOOS for now as it is accurate enough for the platforms
+// we are targeting
+//
+// # Future commands which are optional on some systems
+//
+// dig -t any -c any brianshumate.com
+// lsof
+var systemTasks = []Task{
+	// OS release info
+	{Output: "os_release_redhat", Command: "cat", Args: []string{"/etc/redhat-release"}, IfExists: "/etc/redhat-release"},
+	{Output: "os_release_fedora", Command: "cat", Args: []string{"/etc/fedora-release"}, IfExists: "/etc/fedora-release"},
+	{Output: "os_release_slackware", Command: "cat", Args: []string{"/etc/slackware-release"}, IfExists: "/etc/slackware-release"},
+	{Output: "os_release_debian", Command: "cat", Args: []string{"/etc/debian_release"}, IfExists: "/etc/debian_release"},
+	{Output: "os_release", Command: "cat", Args: []string{"/etc/os-release"}, IfExists: "/etc/os-release"},
+
+	// Common commands
+	{Output: "date", Command: "date"},
+	{Output: "df", Command: "df"},
+	{Output: "df_i", Command: "df", Args: []string{"-i"}},
+	{Output: "df_h", Command: "df", Args: []string{"-h"}},
+	{Output: "dmesg", Command: "dmesg", Privileged: true},
+	{Output: "hostname", Command: "hostname"},
+	{Output: "last", Command: "last", Tags: []string{TagSlow}},
+	{Output: "mount", Command: "mount"},
+	{Output: "netstat_anW", Command: "netstat", Args: []string{"-anW"}},
+	{Output: "netstat_indW", Command: "netstat", Args: []string{"-indW"}},
+	{Output: "netstat_mmmW", Command: "netstat", Args: []string{"-mmmW"}},
+	{Output: "netstat_nralW", Command: "netstat", Args: []string{"-nralW"}},
+	{Output: "netstat_rn", Command: "netstat", Args: []string{"-rn"}},
+	{Output: "netstat_sW", Command: "netstat", Args: []string{"-sW"}},
+	{Output: "pfctl_rules", Command: "pfctl", Args: []string{"-s rules"}, Privileged: true},
+	{Output: "pfctl_nat", Command: "pfctl", Args: []string{"-s nat"}, Privileged: true},
+	{Output: "sysctl", Command: "sysctl", Args: []string{"-a"}},
+	{Output: "uname", Command: "uname", Args: []string{"-a"}},
+	{Output: "w", Command: "w"},
+
+	// Common file contents
+	{Output: "file_etc_fstab", Command: "cat", Args: []string{"/etc/fstab"}},
+	{Output: "file_etc_hosts", Command: "cat", Args: []string{"/etc/hosts"}},
+	{Output: "file_etc_resolv_conf", Command: "cat", Args: []string{"/etc/resolv.conf"}},
+
+	// Darwin specific commands
+	{Output: "ifconfig", Command: "ifconfig", Args: []string{"-a"}, OS: []string{Darwin}},
+	{Output: "netstat_rs", Command: "netstat", Args: []string{"-rs"}, OS: []string{Darwin}},
+	{Output: "ps", Command: "ps", Args: []string{"aux"}, OS: []string{Darwin}},
+	{Output: "top", Command: "top", Args: []string{"-l 1"}, OS: []string{Darwin}},
+	{Output: "vm_stat", Command: "vm_stat", OS: []string{Darwin}},
+
+	// FreeBSD specific commands
+	{Output: "arp_a", Command: "arp", Args: []string{"-a"}, OS: []string{FreeBSD}},
+	{Output: "ifconfig", Command: "ifconfig", Args: []string{"-a"}, OS: []string{FreeBSD}},
+	{Output: "iostat_bsd", Command: "iostat", Args: []string{"-c 10"}, OS: []string{FreeBSD}, Sampling: true},
+	{Output: "pkg_info", Command: "pkg", Args: []string{"info"}, OS: []string{FreeBSD}, Tags: []string{TagSlow}},
+	{Output: "ps", Command: "ps", Args: []string{"aux"}, OS: []string{FreeBSD}},
+	{Output: "swapinfo", Command: "swapinfo", OS: []string{FreeBSD}},
+	{Output: "top", Command: "top", Args: []string{"-n", "-b"}, OS: []string{FreeBSD}},
+	{Output: "vmstat", Command: "vmstat", Args: []string{"1", "10"}, OS: []string{FreeBSD}, Sampling: true},
+
+	// FreeBSD file contents
+	{Output: "file_var_run_dmesg_boot", Command: "cat", Args: []string{"/var/run/dmesg.boot"}, OS: []string{FreeBSD}},
+	{Output: "file_var_log_messages", Command: "cat", Args: []string{"/var/log/messages"}, OS: []string{FreeBSD}, Privileged: true, Tags: []string{TagSlow}},
+	{Output: "file_etc_rc_conf", Command: "cat", Args: []string{"/etc/rc.conf"}, OS: []string{FreeBSD}},
+	{Output: "file_etc_sysctl_conf", Command: "cat", Args: []string{"/etc/sysctl.conf"}, OS: []string{FreeBSD}},
+
+	// Linux specific commands
+	{Output: "bonding", Command: "find", Args: []string{"/proc/net/bonding/", "-type", "f", "-print", "-exec", "cat", "{}", ";"}, OS: []string{Linux}},
+	{Output: "disk_by_id", Command: "ls", Args: []string{"-l", "/dev/disk/by-id"}, OS: []string{Linux}},
+	{Output: "dpkg", Command: "dpkg", Args: []string{"-l"}, OS: []string{Linux}, Tags: []string{TagSlow}},
+	{Output: "free", Command: "free", Args: []string{"-m"}, OS: []string{Linux}},
+	{Output: "ifconfig", Command: "ifconfig", Args: []string{"-a"}, OS: []string{Linux}},
+	{Output: "iostat_linux", Command: "iostat", Args: []string{"-mx", "1", "10"}, OS: []string{Linux}, Sampling: true},
+	{Output: "ip_addr", Command: "ip", Args: []string{"addr"}, OS: []string{Linux}},
+	{Output: "lsb_release", Command: "lsb_release", OS: []string{Linux}},
+	{Output: "ps", Command: "ps", Args: []string{"-aux"}, OS: []string{Linux}},
+	{Output: "rpm", Command: "rpm", Args: []string{"-qa"}, OS: []string{Linux}, Tags: []string{TagSlow}},
+	{Output: "rx_crc_errors", Command: "find", Args: []string{"/sys/class/net/", "-type", "l", "-print", "-exec", "cat", "{}/statistics/rx_crc_errors", ";"}, OS: []string{Linux}},
+	{Output: "schedulers", Command: "find", Args: []string{"/sys/block/", "-type", "l", "-print", "-exec", "cat", "{}/queue/scheduler", ";"}, OS: []string{Linux}},
+	{Output: "sestatus", Command: "sestatus", Args: []string{"-v"}, OS: []string{Linux}},
+	{Output: "swapctl", Command: "swapctl", Args: []string{"-s"}, OS: []string{Linux}},
+	{Output: "swapon", Command: "swapon", Args: []string{"-s"}, OS: []string{Linux}},
+	{Output: "top", Command: "top", Args: []string{"-n 1", "-b"}, OS: []string{Linux}},
+	{Output: "vmstat", Command: "vmstat", Args: []string{"1", "10"}, OS: []string{Linux}, Sampling: true},
+	{Output: "sys-class-net", Command: "ls", Args: []string{"/sys/class/net"}, OS: []string{Linux}},
+	{Output: "proc-net-fib_trie", Command: "cat", Args: []string{"/proc/net/fib_trie"}, OS: []string{Linux}},
+
+	// ¡¿ systemd stuff ¡¿
+	{Output: "journalctl_dmesg", Command: "journalctl", Args: []string{"--dmesg", "--no-pager"}, OS: []string{Linux}, IfExists: "/run/systemd/system", Privileged: true, Tags: []string{TagSlow}},
+	{Output: "journalctl_system", Command: "journalctl", Args: []string{"--system", "--no-pager"}, OS: []string{Linux}, IfExists: "/run/systemd/system", Privileged: true, Tags: []string{TagSlow}},
+	{Output: "systemctl_all", Command: "systemctl", Args: []string{"--all", "--no-pager"}, OS: []string{Linux}, IfExists: "/run/systemd/system"},
+	{Output: "systemctl_unit_files", Command: "systemctl", Args: []string{"list-unit-files", "--no-pager"}, OS: []string{Linux}, IfExists: "/run/systemd/system"},
+	{Output: "timedatectl", Command: "timedatectl", OS: []string{Linux}, IfExists: "/run/systemd/system"},
+	{Output: "chronyc_tracking", Command: "chronyc", Args: []string{"tracking"}, OS: []string{Linux}, IfExists: "/usr/bin/chronyc"},
+
+	// Linux file contents
+	{Output: "file_var_log_daemon", Command: "cat", Args: []string{"/var/log/daemon"}, OS: []string{Linux}, Privileged: true, Tags: []string{TagSlow}},
+	{Output: "file_var_log_debug", Command: "cat", Args: []string{"/var/log/debug"}, OS: []string{Linux}, Privileged: true, Tags: []string{TagSlow}},
+	{Output: "file_etc_security_limits", Command: "cat", Args: []string{"/etc/security/limits.conf"}, OS: []string{Linux}},
+	{Output: "file_var_log_kern", Command: "cat", Args: []string{"/var/log/kern.log"}, OS: []string{Linux}, Privileged: true, Tags: []string{TagSlow}},
+	{Output: "file_var_log_messages", Command: "cat", Args: []string{"/var/log/messages"}, OS: []string{Linux}, Privileged: true, Tags: []string{TagSlow}},
+	{Output: "file_var_log_syslog", Command: "cat", Args: []string{"/var/log/syslog"}, OS: []string{Linux}, Privileged: true, Tags: []string{TagSlow}},
+	{Output: "file_var_log_system_log", Command: "cat", Args: []string{"/var/log/system.log"}, OS: []string{Linux}, Privileged: true, Tags: []string{TagSlow}},
+
+	// proc entries
+	{Output: "proc_cgroups", Command: "cat", Args: []string{"/proc/cgroups"}, OS: []string{Linux}},
+	{Output: "proc_cpuinfo", Command: "cat", Args: []string{"/proc/cpuinfo"}, OS: []string{Linux}},
+	{Output: "proc_diskstats", Command: "cat", Args: []string{"/proc/diskstats"}, OS: []string{Linux}},
+	{Output: "proc_interrupts", Command: "cat", Args: []string{"/proc/interrupts"}, OS: []string{Linux}},
+	{Output: "proc_meminfo", Command: "cat", Args: []string{"/proc/meminfo"}, OS: []string{Linux}},
+	{Output: "proc_mounts", Command: "cat", Args: []string{"/proc/mounts"}, OS: []string{Linux}},
+	{Output: "proc_partitions", Command: "cat", Args: []string{"/proc/partitions"}, OS: []string{Linux}},
+	{Output: "proc_stat", Command: "cat", Args: []string{"/proc/stat"}, OS: []string{Linux}},
+	{Output: "proc_swaps", Command: "cat", Args: []string{"/proc/swaps"}, OS: []string{Linux}},
+	{Output: "proc_uptime", Command: "cat", Args: []string{"/proc/uptime"}, OS: []string{Linux}},
+	{Output: "proc_version", Command: "cat", Args: []string{"/proc/version"}, OS: []string{Linux}},
+	{Output: "proc_vmstat", Command: "cat", Args: []string{"/proc/vmstat"}, OS: []string{Linux}},
+	{Output: "proc_sys_vm_swappiness", Command: "cat", Args: []string{"/proc/sys/vm/swappiness"}, OS: []string{Linux}},
+}
+
+func init() {
+	Collectors.Register(NewTaskSet("system", systemTasks...))
+}
+
 // SystemCommand describes system related fields
 type SystemCommand struct {
-	Arch         string
-	HostName     string
-	OS           string
-	ReleaseFiles []string
-	UI           cli.Ui
-	LogFile      string
+	Timeout     time.Duration
+	Parallelism int
+	Profile     string
+	NoRedact    bool
+	Strict      bool
+	ConfigPath  string
+	Arch        string
+	OutputDir   string
+	HostName    string
+	OS          string
+	RunContext  *RunContext
+	UI          cli.Ui
+	LogFile     string
 }
 
 // Help output
 func (c *SystemCommand) Help() string {
 	helpText := `
-Usage: rover system
+Usage: rover system [options]
 	Executes operating system commands and saves output to text files
+
+General Options:
+  -config=<path>	Task configuration file or directory [default: $ROVER_CONFIG_DIR]
+  -output-dir=<dir>	Directory holding the <hostname> data directory [default: $ROVER_OUTPUT_DIR or the latest run]
+  -log-level=<level>	Log level: trace, debug, info, warn or error [default: $ROVER_LOG_LEVEL or info]
+  -log-json		Write rover.log as JSON [default: true when $ROVER_LOG_FORMAT is json]
+  -log-mirror		Print logged warnings to the terminal [default: true]
+  -timeout=<duration>	Default deadline for each task [default: 2m]
+  -parallelism=<n>	Maximum number of tasks to run at once [default: 4]
+  -profile=<name>	Collection profile: minimal, standard, deep or custom [default: standard]
+  -no-redact		Do not redact secrets from output; internal use only [default: false]
+  -strict		Exit non-zero when any task fails, times out or is cancelled [default: false]
 `
 
 	return strings.TrimSpace(helpText)
 }
 
 // Run the command
-func (c *SystemCommand) Run(_ []string) int {
+func (c *SystemCommand) Run(args []string) int {
+	run := c.RunContext
+	if run == nil {
+		run = NewRunContext(c.UI)
+	}
 	c.Arch = runtime.GOARCH
-	h, err := GetHostName()
-	if err != nil {
-		out := fmt.Sprintf("Cannot get system hostname with error %v", err)
-		c.UI.Output(out)
+	cmdFlags := flag.NewFlagSet("system", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.StringVar(&c.ConfigPath, "config", "", configPathDescr)
+	cmdFlags.DurationVar(&c.Timeout, "timeout", DefaultTaskTimeout, timeoutDescr)
+	cmdFlags.IntVar(&c.Parallelism, "parallelism", DefaultParallelism, parallelismDescr)
+	cmdFlags.StringVar(&c.Profile, "profile", DefaultProfile, profileDescr)
+	cmdFlags.BoolVar(&c.NoRedact, "no-redact", false, noRedactDescr)
+	cmdFlags.BoolVar(&c.Strict, "strict", false, strictDescr)
+	cmdFlags.StringVar(&c.OutputDir, "output-dir", "", outputDirDescr)
+	run.Flags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+	if err := run.Open(c.OutputDir, RunReuse); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	defer run.Close()
+	c.HostName = run.HostName
+	c.OS = run.OS
+	logger := run.Logger
+
+	logger.Info("system", "hello from the System module at", c.HostName)
+	logger.Info("system", "our detected OS", c.OS)
 
+	if err := LoadConfig(ConfigPath(c.ConfigPath), Collectors); err != nil {
+		logger.Error("system", "cannot load configuration with error", err.Error())
+		c.UI.Error(err.Error())
 		return 1
 	}
-	c.HostName = h
-	c.OS = runtime.GOOS
-	c.ReleaseFiles = []string{"/etc/redhat-release",
-		"/etc/fedora-release",
-		"/etc/slackware-release",
-		"/etc/debian_release",
-		"/etc/os-release"}
+	profile, err := Collectors.Profile(c.Profile)
+	if err != nil {
+		logger.Error("system", "cannot find profile with error", err.Error())
+		c.UI.Error(err.Error())
+		return 1
+	}
+	redactor := commandRedactor(logger, c.UI, c.NoRedact)
 
 	// Shout out to Ye Olde School BSD spinner!
 	roverSpinnerSet := []string{"/", "|", "\\", "-", "|", "\\", "-"}
@@ -78,183 +246,43 @@ func (c *SystemCommand) Run(_ []string) int {
 	s.Writer = os.Stderr
 	err = s.Color("fgHiCyan")
 	if err != nil {
-		log.Printf("install", "weird-error", err.Error())
+		logger.Warn("system", "weird-error", err.Error())
 	}
 	s.Suffix = " Gathering system data, please wait ..."
 	s.FinalMSG = "Gathered system data\n"
 	s.Start()
 
-	// Internal logging
-	l := "rover.log"
-	p := filepath.Join(fmt.Sprintf("%s", c.HostName), "log")
-	if err := os.MkdirAll(p, os.ModePerm); err != nil {
-		fmt.Println(fmt.Sprintf("Cannot create log directory %s.", p))
-		os.Exit(1)
-	}
-	f, err := os.OpenFile(filepath.Join(p, l), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Println(fmt.Sprintf("Failed to open log file %s with error: %v", f, err))
-		os.Exit(1)
-	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	logger := hclog.New(&hclog.LoggerOptions{Name: "rover", Level: hclog.LevelFromString("INFO"), Output: w})
-
-	logger.Info("system", "hello from the System module at", c.HostName)
-	logger.Info("system", "our detected OS", c.OS)
-
 	// Handle creating the command output directory
-	// TODO: maybe not such a hardcoded path?
-	outPath := filepath.Join(".", fmt.Sprintf("%s/system", c.HostName))
+	outPath := filepath.Join(run.HostDir(), "system")
 	if err := os.MkdirAll(outPath, os.ModePerm); err != nil {
 		out := fmt.Sprintf("Cannot create directory %s!", outPath)
 		logger.Error("system", "cannot create directory with error", err.Error())
 		c.UI.Error(out)
-		os.Exit(1)
+		s.Stop()
+		return 1
 	}
 
-	// Grab OS release info
-	for _, file := range c.ReleaseFiles {
-		if FileExist(file) {
-			Dump("system", "os_release", "cat", file)
-			logger.Info("system", "dumping release file", file)
-		}
+	tasks, err := Collectors.Lookup("system")
+	if err != nil {
+		logger.Error("system", "cannot find task set with error", err.Error())
+		s.Stop()
+		c.UI.Error(err.Error())
+		return 1
 	}
-
-	// Common commands and file contents from which to gather information
-	// across the currently supported range of operating system commands
-	Dump("system", "date", "date")
-	Dump("system", "df", "df")
-	Dump("system", "df_i", "df", "-i")
-	Dump("system", "df_h", "df", "-h")
-	Dump("system", "dmesg", "dmesg")
-	Dump("system", "hostname", "hostname")
-	Dump("system", "last", "last")
-	Dump("system", "mount", "mount")
-	Dump("system", "netstat_anW", "netstat", "-anW")
-	Dump("system", "netstat_indW", "netstat", "-indW")
-	Dump("system", "netstat_mmmW", "netstat", "-mmmW")
-	Dump("system", "netstat_nralW", "netstat", "-nralW")
-	Dump("system", "netstat_rn", "netstat", "-rn")
-	Dump("system", "netstat_sW", "netstat", "-sW")
-	Dump("system", "pfctl_rules", "pfctl", "-s rules")
-	Dump("system", "pfctl_nat", "pfctl", "-s nat")
-	Dump("system", "sysctl", "sysctl", "-a")
-	Dump("system", "uname", "uname", "-a")
-	Dump("system", "w", "w")
-
-	// File contents
-	Dump("system", "file_etc_fstab", "cat", "/etc/fstab")
-	Dump("system", "file_etc_hosts", "cat", "/etc/hosts")
-	Dump("system", "file_etc_resolv_conf", "cat", "/etc/resolv.conf")
-
-	// Different command subsets chosen by OS
-	// We use runtime.GOOS for now as it is accurate enough for
-	// the platforms we are targeting...
-	// Could look at build constraints later as well
-	//
-	// Future commands which are optional on some systems
-	//
-	// dig -t any -c any brianshumate.com
-	// lsof
-	//
-	//
-	switch c.OS {
-
-	case Darwin:
-
-		// Darwin specific commands
-		Dump("system", "ifconfig", "ifconfig", "-a")
-		Dump("system", "netstat_rs", "netstat", "-rs")
-		Dump("system", "ps", "ps", "aux")
-		Dump("system", "top", "top", "-l 1")
-		Dump("system", "vm_stat", "vm_stat")
-
-	case FreeBSD:
-
-		// FreeBSD specific ommands
-		Dump("system", "arp_a", "arp", "-a")
-		Dump("system", "ifconfig", "ifconfig", "-a")
-		Dump("system", "iostat_bsd", "iostat", "-c 10")
-		Dump("system", "pkg_info", "pkg", "info")
-		Dump("system", "ps", "ps", "aux")
-		Dump("system", "swapinfo", "swapinfo")
-		Dump("system", "sysctl", "sysctl", "-a")
-		Dump("system", "top", "top", "-n", "-b")
-		Dump("system", "vmstat", "vmstat", "1", "10")
-
-		// File contents
-		Dump("system", "file_var_run_dmesg_boot", "cat", "/var/run/dmesg.boot")
-		Dump("system", "file_var_log_messages", "cat", "/var/log/messages")
-		Dump("system", "file_etc_rc_conf", "cat", "/etc/rc.conf")
-		Dump("system", "file_etc_sysctl_conf", "cat", "/etc/sysctl.conf")
-
-	case Linux:
-
-		// Linux specific commands
-		Dump("system", "bonding", "find", "/proc/net/bonding/", "-type", "f", "-print", "-exec", "cat", "{}", ";")
-		Dump("system", "disk_by_id", "ls", "-l", "/dev/disk/by-id")
-		Dump("system", "dmesg", "dmesg")
-		Dump("system", "dpkg", "dpkg", "-l")
-		Dump("system", "free", "free", "-m")
-		Dump("system", "ifconfig", "ifconfig", "-a")
-		Dump("system", "iostat_linux", "iostat", "-mx", "1", "10")
-		Dump("system", "ip_addr", "ip", "addr")
-		Dump("system", "lsb_release", "lsb_release")
-		Dump("system", "ps", "ps", "-aux")
-		Dump("system", "rpm", "rpm", "-qa")
-		Dump("system", "rx_crc_errors", "find", "/sys/class/net/", "-type", "l", "-print", "-exec", "cat", "{}/statistics/rx_crc_errors", ";")
-		Dump("system", "schedulers", "find", "/sys/block/", "-type", "l", "-print", "-exec", "cat", "{}/queue/scheduler", ";")
-		Dump("system", "sestatus", "sestatus", "-v")
-		Dump("system", "swapctl", "swapctl", "-s")
-		Dump("system", "swapon", "swapon", "-s")
-		Dump("system", "top", "top", "-n 1", "-b")
-		Dump("system", "vmstat", "vmstat", "1", "10")
-		Dump("system", "sys-class-net", "ls", "/sys/class/net")
-		Dump("system", "proc-net-fib_trie", "cat", "/proc/net/fib_trie")
-
-		// ¡¿ systemd stuff ¡¿
-		if FileExist("/run/systemd/system") {
-			logger.Info("system", "evidence of systemd present here")
-			logger.Info("system", "attempting to gather systemd related information")
-			Dump("system", "journalctl_dmesg", "journalctl", "--dmesg", "--no-pager")
-			Dump("system", "journalctl_system", "journalctl", "--system", "--no-pager")
-			Dump("system", "systemctl_all", "systemctl", "--all", "--no-pager")
-			Dump("system", "systemctl_unit_files", "systemctl", "list-unit-files", "--no-pager")
-		} else {
-			logger.Info("system", "there is no evidence of systemd present here")
-		}
-
-		// File contents
-		Dump("system", "file_var_log_daemon", "cat", "/var/log/daemon")
-		Dump("system", "file_var_log_debug", "cat", "/var/log/debug")
-		Dump("system", "file_etc_security_limits", "cat", "/etc/security/limits.conf")
-		Dump("system", "file_var_log_kern", "cat", "/var/log/kern.log")
-		Dump("system", "file_var_log_messages", "cat", "/var/log/messages")
-		Dump("system", "file_var_log_syslog", "cat", "/var/log/syslog")
-		Dump("system", "file_var_log_system_log", "cat", "/var/log/system.log")
-
-		// proc entries
-		Dump("system", "proc_cgroups", "cat", "/proc/cgroups")
-		Dump("system", "proc_cpuinfo", "cat", "/proc/cpuinfo")
-		Dump("system", "proc_diskstats", "cat", "/proc/diskstats")
-		Dump("system", "proc_interrupts", "cat", "/proc/interrupts")
-		Dump("system", "proc_meminfo", "cat", "/proc/meminfo")
-		Dump("system", "proc_mounts", "cat", "/proc/mounts")
-		Dump("system", "proc_partitions", "cat", "/proc/partitions")
-		Dump("system", "proc_stat", "cat", "/proc/stat")
-		Dump("system", "proc_swaps", "cat", "/proc/swaps")
-		Dump("system", "proc_uptime", "cat", "/proc/uptime")
-		Dump("system", "proc_version", "cat", "/proc/version")
-		Dump("system", "proc_vmstat", "cat", "/proc/vmstat")
-		Dump("system", "proc_sys_vm_swappiness", "cat", "/proc/sys/vm/swappiness")
+	ctx, cancel := InterruptContext()
+	defer cancel()
+	results := ExecuteTasks(ctx, logger, tasks, TaskEnv{Run: run, OS: c.OS, Timeout: c.Timeout, Parallelism: c.Parallelism, Profile: profile, Redactor: redactor})
+	if err := UpdateManifest(run.HostDir(), c.OS, profile.Name, results); err != nil {
+		logger.Warn("system", "cannot update manifest with error", err.Error())
 	}
 
 	// XXX: old style
 	// out := "Executed system commands and stored output"
 	// c.UI.Output(out)
 	s.Stop()
-	return 0
+	summary := &Summary{}
+	summary.Add("system", results)
+	return summary.Report(c.UI, c.Strict)
 }
 
 // Synopsis for command
@@ -3,14 +3,14 @@
 package command
 
 import (
-	"bufio"
 	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"time"
 
@@ -19,7 +19,6 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/briandowns/spinner"
-	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
 	"github.com/ryanuber/columnize"
 )
@@ -34,13 +33,17 @@ type UploadCommand struct {
 	AccessKey   string
 	ArchiveFile string
 	Bucket      string
+	OutputDir   string
 	HostName    string
 	OS          string
 	Prefix      string
 	Region      string
 	SecretKey   string
 	Token       string
+	RunContext  *RunContext
 	UI          cli.Ui
+	// Endpoint replaces the AWS endpoint for S3 compatible storage when set
+	Endpoint string
 }
 
 // Help output
@@ -51,6 +54,10 @@ Usage: rover upload [options]
 
 General Options:
   -file="rover-host-20171028110212.zip"	Specify the filename to upload.
+  -output-dir=<dir>	Directory whose rover.log records the upload [default: $ROVER_OUTPUT_DIR or the latest run]
+  -log-level=<level>	Log level: trace, debug, info, warn or error [default: $ROVER_LOG_LEVEL or info]
+  -log-json		Write rover.log as JSON [default: true when $ROVER_LOG_FORMAT is json]
+  -log-mirror		Print logged warnings to the terminal [default: true]
 
 Environment Variables:
 
@@ -71,38 +78,28 @@ Environment Variables:
 
 // Run command
 func (c *UploadCommand) Run(args []string) int {
-	c.OS = runtime.GOOS
-	h, err := GetHostName()
-	if err != nil {
-		out := fmt.Sprintf("Cannot get system hostname with error %v", err)
-		c.UI.Output(out)
-
-		return 1
+	run := c.RunContext
+	if run == nil {
+		run = NewRunContext(c.UI)
 	}
-	c.HostName = h
-	// Internal logging
-	l := "rover.log"
-	p := filepath.Join(fmt.Sprintf("%s", c.HostName), "log")
-	if err := os.MkdirAll(p, os.ModePerm); err != nil {
-		fmt.Println(fmt.Sprintf("Cannot create log directory %s.", p))
-		return 1
-	}
-	f, err := os.OpenFile(filepath.Join(p, l), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Println(fmt.Sprintf("Failed to open log file %s with error: %v", f, err))
-		return 1
-	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	logger := hclog.New(&hclog.LoggerOptions{Name: "rover", Level: hclog.LevelFromString("INFO"), Output: w})
-	logger.Info("upload", "hello from the Upload module at", c.HostName)
-	logger.Info("upload", "our detected OS", c.OS)
+	c.OS = run.OS
 	cmdFlags := flag.NewFlagSet("upload", flag.ContinueOnError)
 	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
 	cmdFlags.StringVar(&c.ArchiveFile, "file", archiveFileDefault, archiveFileDescr)
+	cmdFlags.StringVar(&c.OutputDir, "output-dir", "", outputDirDescr)
+	run.Flags(cmdFlags)
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
+	if err := run.Open(c.OutputDir, RunReuse); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	defer run.Close()
+	c.HostName = run.HostName
+	logger := run.Logger
+	logger.Info("upload", "hello from the Upload module at", c.HostName)
+	logger.Info("upload", "our detected OS", c.OS)
 	c.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
 	c.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
 	c.Bucket = os.Getenv("AWS_BUCKET")
@@ -123,6 +120,9 @@ func (c *UploadCommand) Run(args []string) int {
 	}
 	creds := credentials.NewStaticCredentials(c.AccessKey, c.SecretKey, c.Token)
 	cfg := aws.NewConfig().WithRegion(c.Region).WithCredentials(creds)
+	if c.Endpoint != "" {
+		cfg = cfg.WithEndpoint(c.Endpoint).WithS3ForcePathStyle(true)
+	}
 	svc := s3.New(session.New(), cfg)
 	file, err := os.Open(c.ArchiveFile)
 	if err != nil {
@@ -131,15 +131,8 @@ func (c *UploadCommand) Run(args []string) int {
 		logger.Error("upload", "error", out)
 		return 1
 	}
-	defer func() {
-		// Close after zip file is successfully uploaded
-		err = file.Close()
-		if err != nil {
-			out := fmt.Sprintf("Could not close %s! Error: %v", c.ArchiveFile, err)
-			c.UI.Error(out)
-			os.Exit(1)
-		}
-	}()
+	// The archive is only read, so there is nothing to lose on close
+	defer file.Close()
 	fileInfo, err := file.Stat()
 	if err != nil {
 		out := fmt.Sprintf("Could not stat file %s! Error: %v", c.ArchiveFile, err)
@@ -148,23 +141,24 @@ func (c *UploadCommand) Run(args []string) int {
 	}
 	var fileSize int64 = fileInfo.Size()
 	buffer := make([]byte, fileSize)
-	defer func() {
-		// Read from the buffer
-		_, err = file.Read(buffer)
-		if err != nil {
-			out := fmt.Sprintf("Could not read buffer! Error: %s", err)
-			logger.Error("upload", "error", err.Error())
-			c.UI.Error(out)
-			os.Exit(1)
-		}
-	}()
-	path := fmt.Sprintf("%s/%s", c.Prefix, file.Name())
+	// Read the whole file before building the request so the upload body
+	// is the archive and not an empty buffer
+	_, err = io.ReadFull(file, buffer)
+	if err != nil {
+		out := fmt.Sprintf("Could not read buffer! Error: %s", err)
+		logger.Error("upload", "error", err.Error())
+		c.UI.Error(out)
+		return 1
+	}
+	// The archive is usually in the output or state directory, whose path
+	// is of no use in the bucket
+	key := path.Join(c.Prefix, filepath.Base(c.ArchiveFile))
 	fileBytes := bytes.NewReader(buffer)
 	// For more than application/zip later
 	fileType := http.DetectContentType(buffer)
 	params := &s3.PutObjectInput{
 		Bucket:        aws.String(c.Bucket),
-		Key:           aws.String(path),
+		Key:           aws.String(key),
 		Body:          fileBytes,
 		ContentLength: aws.Int64(fileSize),
 		ContentType:   aws.String(fileType),
@@ -177,14 +171,19 @@ func (c *UploadCommand) Run(args []string) int {
 	if err != nil {
 		logger.Warn("upload", "weird-error", err.Error())
 	}
-	s.Suffix = " Gathering Vault information ..."
-	s.FinalMSG = fmt.Sprintf("Success! Uploaded s3://%s/%s", c.Bucket, file.Name())
+	s.Suffix = " Uploading archive ..."
+	s.FinalMSG = fmt.Sprintf("Success! Uploaded s3://%s/%s", c.Bucket, key)
 	s.Start()
 
 	resp, err := svc.PutObject(params)
 	if err != nil {
+		s.Lock()
+		s.FinalMSG = ""
+		s.Unlock()
+		s.Stop()
 		out := fmt.Sprintf("Error: %s from AWS! Response: %s", err, resp)
 		c.UI.Error(out)
+		return 1
 	}
 	s.Stop()
 
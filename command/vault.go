@@ -4,24 +4,85 @@
 package command
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"time"
 
 	"github.com/briandowns/spinner"
 	"github.com/hashicorp/go-hclog"
-	"github.com/hashicorp/go-version"
 	"github.com/mitchellh/cli"
 )
 
+// vaultTasks are the Vault probes; unauthenticated first, then commands
+// that require a token, followed by Vault-specific operating system tasks
+// based on host OS ID
+var vaultTasks = []Task{
+	{Output: "vault_version", Command: "vault", Args: []string{"version"}},
+	{Output: "vault_status", Command: "vault", Args: []string{"status"}},
+
+	// CLI syntax differs for versions before the GREAT RENAMINING in 0.9.2
+	{Output: "vault_audit_list", Command: "vault", Args: []string{"audit", "list"}, Version: "> 0.9.2"},
+	{Output: "vault_auth_methods", Command: "vault", Args: []string{"auth", "list"}, Version: "> 0.9.2"},
+	{Output: "vault_mounts", Command: "vault", Args: []string{"secrets", "list"}, Version: "> 0.9.2"},
+	{Output: "vault_audit_list", Command: "vault", Args: []string{"audit-list"}, Version: "<= 0.9.2"},
+	{Output: "vault_auth_methods", Command: "vault", Args: []string{"auth", "-methods"}, Version: "<= 0.9.2"},
+	{Output: "vault_mounts", Command: "vault", Args: []string{"mounts"}, Version: "<= 0.9.2"},
+
+	// Vault log messages from system logs (sudo required)
+	{Output: "vault_syslog", Command: "grep", Args: []string{"-w", "vault", "/var/log/system.log"}, OS: []string{Darwin}, Privileged: true, Tags: []string{TagSlow}},
+	{Output: "vault_syslog", Command: "grep", Args: []string{"-w", "vault", "/var/log/syslog"}, OS: []string{FreeBSD, Linux}, IfExists: "/var/log/syslog", Privileged: true, Tags: []string{TagSlow}},
+	{Output: "vault_syslog", Command: "grep", Args: []string{"-w", "vault", "/var/log/messages"}, OS: []string{FreeBSD, Linux}, IfMissing: "/var/log/syslog", Privileged: true, Tags: []string{TagSlow}},
+
+	// Select process table information when Linux and PID determined
+	{Output: "proc_vault_limits", Command: "cat", Args: []string{"/proc/{pid}/limits"}, OS: []string{Linux}},
+	{Output: "proc_vault_status", Command: "cat", Args: []string{"/proc/{pid}/status"}, OS: []string{Linux}},
+	{Output: "proc_vault_open_file_count", Command: "sh", Args: []string{"-c", "ls /proc/{pid}/fd | wc -l"}, OS: []string{Linux}, Privileged: true},
+	{Output: "systemctl_status_vault", Command: "systemctl", Args: []string{"status", "vault"}, OS: []string{Linux}, IfExists: "/run/systemd/system"},
+	{Output: "vault_journald", Command: "journalctl", Args: []string{"-b", "--no-pager", "-u", "vault"}, OS: []string{Linux}, IfExists: "/run/systemd/system", Privileged: true, Tags: []string{TagSlow}},
+
+	// Server state from the HTTP API, which works without the vault binary;
+	// health reports sealed and standby servers with 200 rather than an error
+	{Output: "vault_sys_health", API: vaultHealthPath, Ext: "json"},
+	{Output: "vault_sys_seal_status", API: "/v1/sys/seal-status", Ext: "json"},
+	{Output: "vault_sys_leader", API: "/v1/sys/leader", Ext: "json"},
+	{Output: "vault_sys_ha_status", API: "/v1/sys/ha-status", Ext: "json"},
+	{Output: "vault_sys_storage_raft_configuration", API: "/v1/sys/storage/raft/configuration", Ext: "json"},
+	{Output: "vault_sys_mounts", API: "/v1/sys/mounts", Ext: "json"},
+	{Output: "vault_sys_auth", API: "/v1/sys/auth", Ext: "json"},
+	{Output: "vault_sys_audit", API: "/v1/sys/audit", Ext: "json"},
+	{Output: "vault_sys_metrics", API: "/v1/sys/metrics", Ext: "json"},
+
+	// Full Vault goroutine stack dump and heap dump
+	{Output: "vault_goroutine", API: "/v1/sys/pprof/goroutine?debug=2", Tags: []string{TagDeep}},
+	{Output: "vault_heap", API: "/v1/sys/pprof/heap?debug=1", Tags: []string{TagDeep}},
+}
+
+// vaultHealthPath is the health check request which succeeds for every
+// server state so that the response body is always captured
+const vaultHealthPath = "/v1/sys/health?standbyok=true&perfstandbyok=true&sealedcode=200&uninitcode=200"
+
+func init() {
+	Collectors.Register(NewTaskSet("vault", vaultTasks...))
+}
+
 // VaultCommand describes Vault related fields
 type VaultCommand struct {
+	Timeout         time.Duration
+	Parallelism     int
+	Profile         string
+	NoRedact        bool
+	Strict          bool
+	ConfigPath      string
+	OutputDir       string
 	HostName        string
 	OS              string
+	RunContext      *RunContext
 	UI              cli.Ui
 	VaultPID        string
 	VaultTokenValue string
@@ -31,45 +92,70 @@ type VaultCommand struct {
 // Help output
 func (c *VaultCommand) Help() string {
 	helpText := `
-Usage: rover consul
+Usage: rover vault [options]
 	Execute Vault related commands and store output in text files
+
+General Options:
+  -config=<path>	Task configuration file or directory [default: $ROVER_CONFIG_DIR]
+  -output-dir=<dir>	Directory holding the <hostname> data directory [default: $ROVER_OUTPUT_DIR or the latest run]
+  -log-level=<level>	Log level: trace, debug, info, warn or error [default: $ROVER_LOG_LEVEL or info]
+  -log-json		Write rover.log as JSON [default: true when $ROVER_LOG_FORMAT is json]
+  -log-mirror		Print logged warnings to the terminal [default: true]
+  -timeout=<duration>	Default deadline for each task [default: 2m]
+  -parallelism=<n>	Maximum number of tasks to run at once [default: 4]
+  -profile=<name>	Collection profile: minimal, standard, deep or custom [default: standard]
+  -no-redact		Do not redact secrets from output; internal use only [default: false]
+  -strict		Exit non-zero when any task fails, times out or is cancelled [default: false]
 `
 
 	return strings.TrimSpace(helpText)
 }
 
 // Run vault commands
-func (c *VaultCommand) Run(_ []string) int {
-	c.OS = runtime.GOOS
-	h, err := GetHostName()
-	if err != nil {
-		out := fmt.Sprintf("Cannot get system hostname with error %v", err)
-		c.UI.Output(out)
-		return 1
+func (c *VaultCommand) Run(args []string) int {
+	run := c.RunContext
+	if run == nil {
+		run = NewRunContext(c.UI)
 	}
-	c.HostName = h
-	// Internal logging
-	l := "rover.log"
-	p := filepath.Join(fmt.Sprintf("%s", c.HostName), "log")
-	if err := os.MkdirAll(p, os.ModePerm); err != nil {
-		out := fmt.Sprintf("Cannot create log directory %s.", p)
-		c.UI.Error(out)
+	c.OS = run.OS
+	cmdFlags := flag.NewFlagSet("vault", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.StringVar(&c.ConfigPath, "config", "", configPathDescr)
+	cmdFlags.DurationVar(&c.Timeout, "timeout", DefaultTaskTimeout, timeoutDescr)
+	cmdFlags.IntVar(&c.Parallelism, "parallelism", DefaultParallelism, parallelismDescr)
+	cmdFlags.StringVar(&c.Profile, "profile", DefaultProfile, profileDescr)
+	cmdFlags.BoolVar(&c.NoRedact, "no-redact", false, noRedactDescr)
+	cmdFlags.BoolVar(&c.Strict, "strict", false, strictDescr)
+	cmdFlags.StringVar(&c.OutputDir, "output-dir", "", outputDirDescr)
+	run.Flags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
-	f, err := os.OpenFile(filepath.Join(p, l), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		out := fmt.Sprintf("Failed to open log file %s with error: %v", f, err)
-		c.UI.Error(out)
+	if err := run.Open(c.OutputDir, RunReuse); err != nil {
+		c.UI.Error(err.Error())
 		return 1
 	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	logger := hclog.New(&hclog.LoggerOptions{Name: "rover", Level: hclog.LevelFromString("INFO"), Output: w})
+	defer run.Close()
+	c.HostName = run.HostName
+	logger := run.Logger
 	logger.Info("vault", "hello from the Vault module at", c.HostName)
 	logger.Info("vault", "our detected OS", c.OS)
-	p, err = CheckProc("vault")
+
+	if err := LoadConfig(ConfigPath(c.ConfigPath), Collectors); err != nil {
+		logger.Error("vault", "cannot load configuration with error", err.Error())
+		c.UI.Error(err.Error())
+		return 1
+	}
+	profile, err := Collectors.Profile(c.Profile)
 	if err != nil {
-		logger.Warn("vault", "vault process not detected:", err.Error())
+		logger.Error("vault", "cannot find profile with error", err.Error())
+		c.UI.Error(err.Error())
+		return 1
+	}
+	redactor := commandRedactor(logger, c.UI, c.NoRedact)
+	p, err := run.CheckProc("vault")
+	if err != nil {
+		logger.Info("vault", "vault process not detected:", err.Error())
 		out := "Vault process not detected in this environment."
 		c.UI.Warn(out)
 		return 1
@@ -77,7 +163,7 @@ func (c *VaultCommand) Run(_ []string) int {
 	c.VaultPID = p
 	c.VaultTokenValue = os.Getenv("VAULT_TOKEN")
 	// Command output directory
-	outPath := filepath.Join(".", fmt.Sprintf("%s/vault", c.HostName))
+	outPath := filepath.Join(run.HostDir(), "vault")
 	if err := os.MkdirAll(outPath, os.ModePerm); err != nil {
 		out := fmt.Sprintf("Cannot create directory %s", outPath)
 		logger.Error("vault", "cannot create directory", outPath)
@@ -85,7 +171,7 @@ func (c *VaultCommand) Run(_ []string) int {
 		return 1
 	}
 	// Drop a note about VAULT_TOKEN (zero length == unset)
-	logger.Warn("vault", "VAULT_TOKEN length", hclog.Fmt("%v", len(c.VaultTokenValue)))
+	logger.Info("vault", "VAULT_TOKEN length", hclog.Fmt("%v", len(c.VaultTokenValue)))
 	// Dump commands only if running Vault server process detected
 	if c.VaultPID != "" {
 		logger.Info("vault", "server process identified", c.VaultPID)
@@ -101,73 +187,38 @@ func (c *VaultCommand) Run(_ []string) int {
 		s.FinalMSG = "Gathered Vault data\n"
 		s.Start()
 
-		c.VaultVersion = CheckHashiVersion("vault")
-		v1, err := version.NewVersion(c.VaultVersion)
+		ctx, cancel := InterruptContext()
+		defer cancel()
+		api, err := moduleAPI(Vault)
 		if err != nil {
-			logger.Error("vault", "version compare issue with error", err.Error())
-			out := fmt.Sprintf("Version compare error %v", err)
-			c.UI.Error(out)
-			return 1
+			logger.Warn("vault", "cannot configure API client, skipping API tasks", err.Error())
 		}
-		// Compare CLI syntax for differences introduced by the GREAT RENAMINING!
-		v2, err := version.NewVersion("0.9.2")
+		c.VaultVersion = moduleVersion(ctx, run, Vault, api)
+		tasks, err := Collectors.Lookup("vault")
 		if err != nil {
-			logger.Error("vault", "version constraint parsing issue with error", err.Error())
-			out := fmt.Sprintf("Version constraint parsing issue with error %v", err)
-			c.UI.Error(out)
+			logger.Error("vault", "cannot find task set with error", err.Error())
+			c.UI.Error(err.Error())
+			s.Stop()
 			return 1
 		}
-		// Unauthenticated stuff first
-		Dump("vault", "vault_version", "vault", "version")
-		Dump("vault", "vault_status", "vault", "status")
-		// These require a token
-		if v1.GreaterThan(v2) {
-			Dump("vault", "vault_audit_list", "vault", "audit", "list")
-			Dump("vault", "vault_auth_methods", "vault", "auth", "list")
-			Dump("vault", "vault_mounts", "vault", "secrets", "list")
-		} else {
-			Dump("vault", "vault_audit_list", "vault", "audit-list")
-			Dump("vault", "vault_auth_methods", "vault", "auth", "-methods")
-			Dump("vault", "vault_mounts", "vault", "mounts")
-		}
-
-		// Vault-specific operating system tasks based on host OS ID
-		switch c.OS {
-		case Darwin:
-			logger.Info("vault", "attempting to extract vault log messages from system log.")
-			Dump("vault", "vault_syslog", "grep", "-w", "vault", "/var/log/system.log")
-		case FreeBSD:
-			// Grep for "vault" in /var/log/messages or /var/log/syslog (sudo required)
-			logger.Info("vault", "attempt to extract vault log messages from system logs (sudo required).")
-			if FileExist("/var/log/syslog") {
-				logger.Info("vault", "checking /var/log/syslog for vault entries (sudo required).")
-				Dump("vault", "vault_syslog", "grep", "-w", "vault", "/var/log/syslog")
-			} else {
-				logger.Info("vault", "no /var/log/syslog found, checking /var/log/messages for vault entries (sudo required).")
-				Dump("vault", "vault_syslog", "grep", "-w", "vault", "/var/log/messages")
-			}
-		case Linux:
-			// Select process table information when Linux and PID determined
-			Dump("vault", "proc_vault_limits", "cat", fmt.Sprintf("/proc/%s/limits", c.VaultPID))
-			Dump("vault", "proc_vault_status", "cat", fmt.Sprintf("/proc/%s/status", c.VaultPID))
-			Dump("vault", "proc_vault_open_file_count", "sh", "-c", fmt.Sprintf("ls /proc/%s/fd | wc -l", c.VaultPID))
-			// Grep for "vault" in /var/log/messages or /var/log/syslog (sudo required)
-			logger.Info("vault", "attempt to extract vault log messages from system logs (sudo required).")
-			if FileExist("/var/log/syslog") {
-				logger.Info("vault", "checking /var/log/syslog for vault entries (sudo required).")
-				Dump("vault", "vault_syslog", "grep", "-w", "vault", "/var/log/syslog")
-			} else {
-				logger.Info("vault", "no /var/log/syslog found, checking /var/log/messages for vault entries (sudo required).")
-				Dump("vault", "vault_syslog", "grep", "-w", "vault", "/var/log/messages")
-			}
-			if FileExist("/run/systemd/system") {
-				logger.Info("vault", "attempting to gather Vault systemd unit status")
-				Dump("vault", "systemctl_status_vault", "systemctl", "status", "vault")
-				logger.Info("vault", "attempting to gather Vault logging from systemd journal.")
-				Dump("vault", "vault_journald", "journalctl", "-b", "--no-pager", "-u", "vault")
-			}
+		results := ExecuteTasks(ctx, logger, tasks, TaskEnv{
+			Run:         run,
+			OS:          c.OS,
+			Version:     c.VaultVersion,
+			Vars:        moduleVars(c.VaultPID),
+			Timeout:     c.Timeout,
+			Parallelism: c.Parallelism,
+			Profile:     profile,
+			Redactor:    redactor,
+			API:         api,
+		})
+		if err := UpdateManifest(run.HostDir(), c.OS, profile.Name, results); err != nil {
+			logger.Warn("vault", "cannot update manifest with error", err.Error())
 		}
 		s.Stop()
+		summary := &Summary{}
+		summary.Add(Vault, results)
+		return summary.Report(c.UI, c.Strict)
 	} else {
 		logger.Info("no vault details learned from this environment.")
 	}
@@ -175,6 +226,23 @@ func (c *VaultCommand) Run(_ []string) int {
 	return 0
 }
 
+// vaultServerVersion returns the version reported by the server health check
+func vaultServerVersion(ctx context.Context, api APIClient) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var b bytes.Buffer
+	if err := api.Fetch(ctx, vaultHealthPath, &b); err != nil {
+		return "", err
+	}
+	var health struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &health); err != nil {
+		return "", fmt.Errorf("cannot parse health check response with error %v", err)
+	}
+	return health.Version, nil
+}
+
 // Synopsis output
 func (c *VaultCommand) Synopsis() string {
 	return "Execute Vault related commands and store output"
@@ -0,0 +1,290 @@
+// Package command for verify
+// Verify proves that a bundle is the one rover archive wrote: the archive
+// matches its checksum and signature, and every file in it matches the
+// SHA-256 hash recorded in its manifest
+package command
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+const (
+	// ChecksumSuffix is appended to the name of an archive to name the file
+	// holding its SHA-256 checksum, in the format of sha256sum
+	ChecksumSuffix = ".sha256"
+	// SignatureSuffix is appended to the name of an archive to name the
+	// file holding the base64 ed25519 signature of its SHA-256 checksum
+	SignatureSuffix = ".sig"
+	signKeyDescr    = "PEM ed25519 private key file to sign the archive with"
+)
+
+// readSigningKey reads a PEM PKCS #8 ed25519 private key, such as one made
+// by openssl genpkey -algorithm ed25519
+func readSigningKey(p string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(p)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key %s with error %v", p, err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key %s is not an ed25519 key", p)
+	}
+	return private, nil
+}
+
+// readVerifyKey reads a PEM ed25519 public key, or the public half of a
+// private key
+func readVerifyKey(p string) (ed25519.PublicKey, error) {
+	block, err := readPEM(p)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "PRIVATE KEY" {
+		private, err := readSigningKey(p)
+		if err != nil {
+			return nil, err
+		}
+		return private.Public().(ed25519.PublicKey), nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse public key %s with error %v", p, err)
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an ed25519 key", p)
+	}
+	return public, nil
+}
+
+// readPEM reads the first PEM block of the file p
+func readPEM(p string) (*pem.Block, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read key file %s with error %v", p, err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM key found in key file %s", p)
+	}
+	return block, nil
+}
+
+// writeIntegrity writes the checksum of the archive at p and, when key is
+// not nil, the signature of the checksum
+func writeIntegrity(p string, sum []byte, key ed25519.PrivateKey) error {
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum), filepath.Base(p))
+	if err := ioutil.WriteFile(p+ChecksumSuffix, []byte(line), 0644); err != nil {
+		return err
+	}
+	if key == nil {
+		return nil
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, sum))
+	return ioutil.WriteFile(p+SignatureSuffix, []byte(sig+"\n"), 0644)
+}
+
+// fileSHA256 returns the SHA-256 hash of the file p
+func fileSHA256(p string) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// VerifyCommand describes verify related fields
+type VerifyCommand struct {
+	Key    string
+	failed int
+	UI     cli.Ui
+}
+
+// Help output
+func (c *VerifyCommand) Help() string {
+	helpText := `
+Usage: rover verify [options] <archive>
+	Check that an archive is unaltered: that it matches the checksum
+	rover archive wrote beside it, that the signature made with -sign-key
+	is good when -key is given, and that every file in it matches the
+	SHA-256 hash recorded in its manifest. The hashes inside an encrypted
+	archive are checked by verifying it again after rover decrypt
+
+General Options:
+  -key=<keyfile>	PEM ed25519 public key to check the signature with
+`
+
+	return strings.TrimSpace(helpText)
+}
+
+// Run command
+func (c *VerifyCommand) Run(args []string) int {
+	cmdFlags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.StringVar(&c.Key, "key", "", "ed25519 public key file")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+	if cmdFlags.NArg() != 1 {
+		c.UI.Error(c.Help())
+		return 1
+	}
+	archive := cmdFlags.Arg(0)
+	sum, err := fileSHA256(archive)
+	if err != nil {
+		out := fmt.Sprintf("Cannot read archive %s with error %v", archive, err)
+		c.UI.Error(out)
+		return 1
+	}
+
+	c.checksum(archive, sum)
+	c.signature(archive, sum)
+	c.fileHashes(archive)
+	if c.failed > 0 {
+		c.UI.Error(fmt.Sprintf("%s failed %d check(s).", archive, c.failed))
+		return 1
+	}
+	c.UI.Output(fmt.Sprintf("%s is verified.", archive))
+	return 0
+}
+
+// fail reports a failed check
+func (c *VerifyCommand) fail(format string, a ...interface{}) {
+	c.failed++
+	c.UI.Error("FAILED: " + fmt.Sprintf(format, a...))
+}
+
+// checksum compares the archive with its checksum file
+func (c *VerifyCommand) checksum(archive string, sum []byte) {
+	b, err := ioutil.ReadFile(archive + ChecksumSuffix)
+	if os.IsNotExist(err) {
+		c.UI.Warn(fmt.Sprintf("No %s file; the checksum was not checked.", ChecksumSuffix))
+		return
+	}
+	if err != nil {
+		c.fail("cannot read checksum with error %v", err)
+		return
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 || !strings.EqualFold(fields[0], hex.EncodeToString(sum)) {
+		c.fail("the archive does not match its checksum")
+		return
+	}
+	c.UI.Output(fmt.Sprintf("OK: checksum %s", hex.EncodeToString(sum)))
+}
+
+// signature checks the signature of the checksum with the key given
+func (c *VerifyCommand) signature(archive string, sum []byte) {
+	b, err := ioutil.ReadFile(archive + SignatureSuffix)
+	if c.Key == "" {
+		if err == nil {
+			c.UI.Warn("The archive is signed; use -key to check the signature.")
+		}
+		return
+	}
+	if err != nil {
+		c.fail("cannot read signature with error %v", err)
+		return
+	}
+	key, err := readVerifyKey(c.Key)
+	if err != nil {
+		c.fail("%v", err)
+		return
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || !ed25519.Verify(key, sum, sig) {
+		c.fail("the signature is not good for %s", c.Key)
+		return
+	}
+	c.UI.Output(fmt.Sprintf("OK: signature by %s", c.Key))
+}
+
+// fileHashes checks every file in the archive against the manifest
+func (c *VerifyCommand) fileHashes(archive string) {
+	if _, err := archiveFormat(archive); err != nil {
+		c.UI.Warn(fmt.Sprintf("Cannot check file hashes: %v", err))
+		return
+	}
+	z, err := OpenArchiveBundle(archive)
+	if err != nil {
+		c.fail("%v", err)
+		return
+	}
+	defer z.Close()
+	m := &Manifest{}
+	b, err := z.ReadFile(ManifestFile)
+	if err == nil {
+		err = json.Unmarshal(b, m)
+	}
+	if err != nil || len(m.Files) == 0 {
+		c.fail("no file hashes found in %s", ManifestFile)
+		return
+	}
+	// Hash every file in one pass over the archive
+	hashes := map[string][]byte{}
+	err = z.Walk(func(name string, r io.Reader) error {
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return fmt.Errorf("cannot read %s with error %v", name, err)
+		}
+		hashes[name] = h.Sum(nil)
+		return nil
+	})
+	if err != nil {
+		c.fail("%v", err)
+		return
+	}
+	listed := map[string]bool{ManifestFile: true}
+	for _, f := range m.Files {
+		listed[f.Name] = true
+		sum, ok := hashes[f.Name]
+		if !ok {
+			c.fail("%s is missing from the archive", f.Name)
+		} else if !bytes.Equal(sum, mustHex(f.SHA256)) {
+			c.fail("%s does not match its hash", f.Name)
+		}
+	}
+	for _, name := range z.Names() {
+		if !listed[name] {
+			c.fail("%s is not listed in %s", name, ManifestFile)
+		}
+	}
+	c.UI.Output(fmt.Sprintf("Checked %d file hash(es) from %s", len(m.Files), ManifestFile))
+}
+
+// mustHex decodes s, returning nothing when it is not hexadecimal so that
+// it matches no hash
+func mustHex(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+// Synopsis output
+func (c *VerifyCommand) Synopsis() string {
+	return "Verify the checksum, signature and file hashes of a rover archive"
+}
@@ -0,0 +1,122 @@
+package command
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+)
+
+// writeSigningKey writes a new ed25519 key pair as PEM files, returning
+// their paths
+func writeSigningKey(t *testing.T, dir, name string) (string, string) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicFile := filepath.Join(dir, name+".pub")
+	if err := ioutil.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	privateFile := filepath.Join(dir, name+".pem")
+	if err := ioutil.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: priv}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return publicFile, privateFile
+}
+
+func TestVerifyArchive(t *testing.T) {
+	dir := writeBundle(t, map[string]string{
+		"penguin/system/df.txt": "Filesystem Size Used Avail Use% Mounted on\n",
+	})
+	defer os.RemoveAll(dir)
+	public, private := writeSigningKey(t, dir, "support")
+	otherPublic, _ := writeSigningKey(t, dir, "other")
+	signer, err := readSigningKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range archiveFormats {
+		archive := filepath.Join(dir, "rover-penguin."+format)
+		if err := createArchive(filepath.Join(dir, "penguin"), archive, format, nil, signer); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		ui := new(cli.MockUi)
+		c := &VerifyCommand{UI: ui}
+		if code := c.Run([]string{"-key", public, archive}); code != 0 {
+			t.Fatalf("%s: verify failed: %d\n\n%s", format, code, ui.ErrorWriter.String())
+		}
+		if !strings.Contains(ui.OutputWriter.String(), "Checked 1 file hash(es)") {
+			t.Errorf("%s: expected the file hashes to be checked, got %s", format, ui.OutputWriter.String())
+		}
+
+		// Another key does not verify the signature
+		ui = new(cli.MockUi)
+		c = &VerifyCommand{UI: ui}
+		if code := c.Run([]string{"-key", otherPublic, archive}); code != 1 || !strings.Contains(ui.ErrorWriter.String(), "signature is not good") {
+			t.Errorf("%s: expected another key to fail, got %d\n\n%s", format, code, ui.ErrorWriter.String())
+		}
+	}
+
+	// An archive rewritten with altered contents fails its checksum, its
+	// signature and the hashes in its manifest
+	archive := filepath.Join(dir, "rover-penguin."+FormatZip)
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, f := range r.File {
+		fw, err := zw.Create(f.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Name == "penguin/system/df.txt" {
+			fw.Write([]byte("nothing to see\n"))
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(fw, rc)
+		rc.Close()
+	}
+	r.Close()
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(archive, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ui := new(cli.MockUi)
+	c := &VerifyCommand{UI: ui}
+	if code := c.Run([]string{"-key", public, archive}); code != 1 {
+		t.Fatalf("expected a tampered archive to fail, got %d", code)
+	}
+	for _, want := range []string{"does not match its checksum", "signature is not good", "system/df.txt does not match its hash"} {
+		if !strings.Contains(ui.ErrorWriter.String(), want) {
+			t.Errorf("expected %q, got %s", want, ui.ErrorWriter.String())
+		}
+	}
+}
@@ -15,12 +15,71 @@ func main() {
 		ErrorWriter: os.Stderr,
 	}
 
+	// Every command shares one run context, whose log is flushed on exit
+	run := command.NewRunContext(&cli.ColoredUi{
+		Ui:        ui,
+		WarnColor: cli.UiColorYellow,
+	})
+
 	c := cli.NewCLI("rover", "0.2.0")
 	c.Args = os.Args[1:]
 
 	c.Commands = map[string]cli.CommandFactory{
+		"all": func() (cli.Command, error) {
+			return &command.AllCommand{
+				RunContext: run,
+				UI: &cli.ColoredUi{
+					Ui:          ui,
+					ErrorColor:  cli.UiColorRed,
+					InfoColor:   cli.UiColorCyan,
+					OutputColor: cli.UiColorNone,
+					WarnColor:   cli.UiColorYellow,
+				},
+			}, nil
+		},
+		"analyze": func() (cli.Command, error) {
+			return &command.AnalyzeCommand{
+				RunContext: run,
+				UI: &cli.ColoredUi{
+					Ui:          ui,
+					ErrorColor:  cli.UiColorRed,
+					InfoColor:   cli.UiColorCyan,
+					OutputColor: cli.UiColorNone,
+					WarnColor:   cli.UiColorYellow,
+				},
+			}, nil
+		},
 		"archive": func() (cli.Command, error) {
 			return &command.ArchiveCommand{
+				RunContext: run,
+				UI: &cli.ColoredUi{
+					Ui:          ui,
+					ErrorColor:  cli.UiColorRed,
+					InfoColor:   cli.UiColorCyan,
+					OutputColor: cli.UiColorGreen,
+					WarnColor:   cli.UiColorYellow,
+				},
+			}, nil
+		},
+		"collect": func() (cli.Command, error) {
+			return &command.CollectCommand{
+				RunContext: run,
+				UI: &cli.ColoredUi{
+					Ui:          ui,
+					ErrorColor:  cli.UiColorRed,
+					InfoColor:   cli.UiColorCyan,
+					OutputColor: cli.UiColorGreen,
+					WarnColor:   cli.UiColorYellow,
+				},
+			}, nil
+		},
+		"config": func() (cli.Command, error) {
+			return &command.ConfigCommand{
+				UI: ui,
+			}, nil
+		},
+		"config validate": func() (cli.Command, error) {
+			return &command.ConfigValidateCommand{
 				UI: &cli.ColoredUi{
 					Ui:          ui,
 					ErrorColor:  cli.UiColorRed,
@@ -32,6 +91,18 @@ func main() {
 		},
 		"consul": func() (cli.Command, error) {
 			return &command.ConsulCommand{
+				RunContext: run,
+				UI: &cli.ColoredUi{
+					Ui:          ui,
+					ErrorColor:  cli.UiColorRed,
+					InfoColor:   cli.UiColorCyan,
+					OutputColor: cli.UiColorGreen,
+					WarnColor:   cli.UiColorYellow,
+				},
+			}, nil
+		},
+		"decrypt": func() (cli.Command, error) {
+			return &command.DecryptCommand{
 				UI: &cli.ColoredUi{
 					Ui:          ui,
 					ErrorColor:  cli.UiColorRed,
@@ -41,8 +112,31 @@ func main() {
 				},
 			}, nil
 		},
+		"diff": func() (cli.Command, error) {
+			return &command.DiffCommand{
+				UI: &cli.ColoredUi{
+					Ui:          ui,
+					ErrorColor:  cli.UiColorRed,
+					InfoColor:   cli.UiColorCyan,
+					OutputColor: cli.UiColorNone,
+					WarnColor:   cli.UiColorYellow,
+				},
+			}, nil
+		},
 		"info": func() (cli.Command, error) {
 			return &command.InfoCommand{
+				RunContext: run,
+				UI: &cli.ColoredUi{
+					Ui:          ui,
+					ErrorColor:  cli.UiColorRed,
+					InfoColor:   cli.UiColorCyan,
+					OutputColor: cli.UiColorNone,
+					WarnColor:   cli.UiColorYellow,
+				},
+			}, nil
+		},
+		"inspect": func() (cli.Command, error) {
+			return &command.InspectCommand{
 				UI: &cli.ColoredUi{
 					Ui:          ui,
 					ErrorColor:  cli.UiColorRed,
@@ -54,6 +148,7 @@ func main() {
 		},
 		"nomad": func() (cli.Command, error) {
 			return &command.NomadCommand{
+				RunContext: run,
 				UI: &cli.ColoredUi{
 					Ui:          ui,
 					ErrorColor:  cli.UiColorRed,
@@ -65,6 +160,7 @@ func main() {
 		},
 		"system": func() (cli.Command, error) {
 			return &command.SystemCommand{
+				RunContext: run,
 				UI: &cli.ColoredUi{
 					Ui:          ui,
 					ErrorColor:  cli.UiColorRed,
@@ -77,6 +173,7 @@ func main() {
 		// upload is a WIP
 		"upload": func() (cli.Command, error) {
 			return &command.UploadCommand{
+				RunContext: run,
 				UI: &cli.ColoredUi{
 					Ui:          ui,
 					OutputColor: cli.UiColorGreen,
@@ -85,6 +182,18 @@ func main() {
 		},
 		"vault": func() (cli.Command, error) {
 			return &command.VaultCommand{
+				RunContext: run,
+				UI: &cli.ColoredUi{
+					Ui:          ui,
+					ErrorColor:  cli.UiColorRed,
+					InfoColor:   cli.UiColorCyan,
+					OutputColor: cli.UiColorGreen,
+					WarnColor:   cli.UiColorYellow,
+				},
+			}, nil
+		},
+		"verify": func() (cli.Command, error) {
+			return &command.VerifyCommand{
 				UI: &cli.ColoredUi{
 					Ui:          ui,
 					ErrorColor:  cli.UiColorRed,
@@ -105,5 +214,6 @@ func main() {
 		fmt.Fprintln(os.Stderr, err.Error())
 	}
 
+	run.Close()
 	os.Exit(exitStatus)
 }